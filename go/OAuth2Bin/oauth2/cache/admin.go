@@ -0,0 +1,422 @@
+package cache
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of the records listed by StoredGrants
+const (
+	KindAccessToken       = "access_token"
+	KindAuthorizationCode = "authorization_code"
+	KindDeviceCode        = "device_code"
+)
+
+// StoredGrant describes a grant or token held in the store, as listed by the admin API.
+//
+// Key is the authorization code, device code or access token which identifies the record,
+// along with the redirect URI of an authorization code, which it was issued for.
+// Grant is the authorization code an Authorization Code token was issued on.
+// Nonce is the one mixed into the token when it was generated, or sent in the authorization request for grants.
+// Status is that of a device authorization request: pending, approved or denied.
+type StoredGrant struct {
+	Flow                  string     `json:"flow"`
+	Kind                  string     `json:"kind"`
+	Key                   string     `json:"key"`
+	RefreshToken          string     `json:"refresh_token,omitempty"`
+	RedirectURI           string     `json:"redirect_uri,omitempty"`
+	ClientID              string     `json:"client_id"`
+	Scope                 string     `json:"scope,omitempty"`
+	Grant                 string     `json:"grant,omitempty"`
+	Nonce                 string     `json:"nonce,omitempty"`
+	UserCode              string     `json:"user_code,omitempty"`
+	Status                string     `json:"status,omitempty"`
+	CreationTime          time.Time  `json:"creation_time"`
+	ExpiresAt             time.Time  `json:"expires_at"`
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+	Active                bool       `json:"active"`
+}
+
+// The hashes holding the tokens issued by a flow
+type tokenFlow struct {
+	name             string
+	tokensSet        string
+	refreshTokensSet string
+}
+
+// The flows whose tokens are listed by StoredGrants
+var tokenFlows = []tokenFlow{
+	{name: AuthCodeFlowName, tokensSet: authCodeTokensSet, refreshTokensSet: authCodeRefreshTokensSet},
+	{name: ImplicitFlowName, tokensSet: implicitTokensSet},
+	{name: ROPCFlowName, tokensSet: ropcTokensSet, refreshTokensSet: ropcRefreshTokensSet},
+	{name: ClientCredsFlowName, tokensSet: clientCredsTokensSet},
+	{name: DeviceFlowName, tokensSet: deviceTokensSet},
+}
+
+// Holds the fields shared by the internal representations of the tokens of every flow,
+// those of the flows which do not issue refresh tokens being left empty
+type storedToken struct {
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		Scope        string `json:"scope"`
+	} `json:"token"`
+	Meta struct {
+		AuthGrant          string    `json:"auth_grant"`
+		ClientID           string    `json:"client_id"`
+		CreationTime       time.Time `json:"creation_time"`
+		Nonce              string    `json:"nonce"`
+		RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
+	} `json:"meta"`
+}
+
+// StoredGrants lists the grants and tokens held in the store, newest first, along with their metadata.
+// Only those of the flow and client passed are listed, unless they are empty.
+// Grants and tokens which have expired but were not yet removed by the store are listed as inactive.
+// It reads every record of the flows, hence it is meant for the admin API rather than serving requests.
+func StoredGrants(flow, clientID string) ([]StoredGrant, error) {
+	var grants []StoredGrant
+
+	for _, tokenFlow := range tokenFlows {
+		if flow != "" && flow != tokenFlow.name {
+			continue
+		}
+
+		tokens, err := storedTokens(tokenFlow)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, tokens...)
+	}
+
+	if flow == "" || flow == AuthCodeFlowName {
+		codes, err := storedAuthCodeGrants()
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, codes...)
+	}
+
+	if flow == "" || flow == DeviceFlowName {
+		codes, err := storedDeviceGrants()
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, codes...)
+	}
+
+	filtered := grants[:0]
+	for _, grant := range grants {
+		if clientID == "" || grant.ClientID == clientID {
+			filtered = append(filtered, grant)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].CreationTime.After(filtered[j].CreationTime)
+	})
+
+	return filtered, nil
+}
+
+// Lists the tokens issued by the flow
+func storedTokens(flow tokenFlow) ([]StoredGrant, error) {
+	records, err := store.GetAll(flow.tokensSet)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	now := time.Now()
+	grants := make([]StoredGrant, 0, len(records))
+	for accessToken, jsonBytes := range records {
+		var token storedToken
+		err = json.Unmarshal(jsonBytes, &token)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		grant := StoredGrant{
+			Flow:         flow.name,
+			Kind:         KindAccessToken,
+			Key:          accessToken,
+			RefreshToken: token.Token.RefreshToken,
+			ClientID:     token.Meta.ClientID,
+			Scope:        token.Token.Scope,
+			Grant:        token.Meta.AuthGrant,
+			Nonce:        token.Meta.Nonce,
+			CreationTime: token.Meta.CreationTime,
+			ExpiresAt:    token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn)),
+		}
+		grant.Active = now.Before(grant.ExpiresAt)
+
+		if flow.refreshTokensSet != "" {
+			refreshTokenExpiresAt := refreshTokenExpiry(token.Meta.CreationTime, token.Meta.RefreshTokenExpiry)
+			grant.RefreshTokenExpiresAt = &refreshTokenExpiresAt
+		}
+
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+// Lists the authorization codes awaiting a token request
+func storedAuthCodeGrants() ([]StoredGrant, error) {
+	records, err := store.GetAll(authCodeGrantSet)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	now := time.Now()
+	grants := make([]StoredGrant, 0, len(records))
+	for value, jsonBytes := range records {
+		var grant authCodeGrantMeta
+		err = json.Unmarshal(jsonBytes, &grant)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// The grants are stored under the code followed by the redirect URI
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		grants = append(grants, StoredGrant{
+			Flow:         AuthCodeFlowName,
+			Kind:         KindAuthorizationCode,
+			Key:          parts[0],
+			RedirectURI:  parts[1],
+			ClientID:     grant.ClientID,
+			Scope:        grant.Scope,
+			Nonce:        grant.Nonce,
+			CreationTime: grant.CreationTime,
+			ExpiresAt:    grant.expiry(),
+			Active:       now.Before(grant.expiry()),
+		})
+	}
+
+	return grants, nil
+}
+
+// Lists the device authorization requests awaiting a token request
+func storedDeviceGrants() ([]StoredGrant, error) {
+	records, err := store.GetAll(deviceGrantSet)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	now := time.Now()
+	grants := make([]StoredGrant, 0, len(records))
+	for deviceCode, jsonBytes := range records {
+		var grant deviceGrantMeta
+		err = json.Unmarshal(jsonBytes, &grant)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		expiry := grant.CreationTime.Add(deviceCodeLifetime)
+		grants = append(grants, StoredGrant{
+			Flow:         DeviceFlowName,
+			Kind:         KindDeviceCode,
+			Key:          deviceCode,
+			ClientID:     grant.ClientID,
+			Scope:        grant.Scope,
+			UserCode:     grant.UserCode,
+			Status:       lookupDeviceStatus(deviceCode),
+			CreationTime: grant.CreationTime,
+			ExpiresAt:    expiry,
+			Active:       now.Before(expiry),
+		})
+	}
+
+	return grants, nil
+}
+
+// RevokeClientGrants removes every grant and token issued to the client from the store,
+// along with the refresh tokens issued with them. Returns the number of grants and tokens removed.
+//
+// The revocation is best-effort: the grants and tokens are listed first and then removed one by one,
+// so those issued to the client in between, e.g. by a token request being handled meanwhile, survive it.
+// Lookups do not check the client against the revocation, so a client which keeps requesting tokens
+// must be stopped before its grants are revoked, e.g. by removing it from the configuration.
+func RevokeClientGrants(clientID string) (int, error) {
+	grants, err := StoredGrants("", clientID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, grant := range grants {
+		switch grant.Kind {
+		case KindAuthorizationCode:
+			removeAuthCodeGrant(grant.Key, grant.RedirectURI)
+		case KindDeviceCode:
+			invalidateDeviceGrant(grant.Key, &deviceGrantMeta{UserCode: grant.UserCode})
+		default:
+			revokeStoredToken(grant)
+		}
+	}
+
+	return len(grants), nil
+}
+
+// Removes the access token from the store along with the index of its refresh token
+func revokeStoredToken(grant StoredGrant) {
+	for _, flow := range tokenFlows {
+		if flow.name != grant.Flow {
+			continue
+		}
+
+		err := store.Delete(flow.tokensSet, grant.Key)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if flow.refreshTokensSet != "" && grant.RefreshToken != "" {
+			err = store.Delete(flow.refreshTokensSet, grant.RefreshToken)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// ExpireToken makes an access or refresh token issued by any of the flows expire now,
+// so that the expiry of tokens can be tested without waiting for it. The flow is identified
+// by the token's prefix and hint is the token_type_hint, as for RevokeToken.
+// An access token keeps its refresh token, which can be used to get a new one.
+// Returns true if a token was found and expired.
+func ExpireToken(token, hint string) bool {
+	var expirers []func(string) bool
+
+	switch {
+	case strings.HasPrefix(token, AuthCodeFlowID):
+		expirers = []func(string) bool{expireAuthCodeToken, expireAuthCodeRefreshToken}
+	case strings.HasPrefix(token, ROPCFlowID):
+		expirers = []func(string) bool{expireROPCToken, expireROPCRefreshToken}
+	case strings.HasPrefix(token, ImplicitFlowID):
+		expirers = []func(string) bool{expireImplicitToken}
+	case strings.HasPrefix(token, ClientCredsFlowID):
+		expirers = []func(string) bool{expireClientCredsToken}
+	case strings.HasPrefix(token, DeviceFlowID):
+		expirers = []func(string) bool{expireDeviceToken}
+	}
+
+	if hint == RefreshTokenHint && len(expirers) == 2 {
+		expirers[0], expirers[1] = expirers[1], expirers[0]
+	}
+
+	for _, expire := range expirers {
+		if expire(token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the lifetime in seconds with which a token created at creationTime has expired by now
+func expiredLifetime(creationTime time.Time) int {
+	return int(time.Since(creationTime) / time.Second)
+}
+
+func expireAuthCodeToken(accessToken string) bool {
+	token := lookupAuthCodeToken(accessToken)
+	if token == nil {
+		return false
+	}
+
+	token.Token.ExpiresIn = expiredLifetime(token.Meta.CreationTime)
+	return storeAuthCodeToken(*token) == nil
+}
+
+func expireAuthCodeRefreshToken(refreshToken string) bool {
+	token := findAuthCodeToken(refreshToken)
+	if token == nil {
+		return false
+	}
+
+	token.Meta.RefreshTokenExpiry = time.Now()
+	return storeAuthCodeToken(*token) == nil
+}
+
+func expireROPCToken(accessToken string) bool {
+	token := lookupROPCToken(accessToken)
+	if token == nil {
+		return false
+	}
+
+	token.Token.ExpiresIn = expiredLifetime(token.Meta.CreationTime)
+	return storeROPCToken(*token) == nil
+}
+
+func expireROPCRefreshToken(refreshToken string) bool {
+	token := findROPCToken(refreshToken)
+	if token == nil {
+		return false
+	}
+
+	token.Meta.RefreshTokenExpiry = time.Now()
+	return storeROPCToken(*token) == nil
+}
+
+func expireImplicitToken(accessToken string) bool {
+	token := lookupImplicitToken(accessToken)
+	if token == nil {
+		return false
+	}
+
+	expiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	token.Token.ExpiresIn = expiredLifetime(token.Meta.CreationTime)
+	return storeExpiredToken(implicitTokensSet, accessToken, token, expiry)
+}
+
+func expireClientCredsToken(accessToken string) bool {
+	token := lookupClientCredsToken(accessToken)
+	if token == nil {
+		return false
+	}
+
+	expiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	token.Token.ExpiresIn = expiredLifetime(token.Meta.CreationTime)
+	return storeExpiredToken(clientCredsTokensSet, accessToken, token, expiry)
+}
+
+func expireDeviceToken(accessToken string) bool {
+	token := lookupDeviceToken(accessToken)
+	if token == nil {
+		return false
+	}
+
+	expiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	token.Token.ExpiresIn = expiredLifetime(token.Meta.CreationTime)
+	return storeExpiredToken(deviceTokensSet, accessToken, token, expiry)
+}
+
+// Stores the expired access token of a flow which does not issue refresh tokens.
+// It is kept until the expiry it was issued with, as it would have been had it not been expired,
+// so that it is listed as expired rather than gone in the meantime.
+func storeExpiredToken(tokensSet, accessToken string, token interface{}, expiry time.Time) bool {
+	jsonBytes, err := json.Marshal(token)
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(tokensSet, accessToken, jsonBytes, ttlUntil(expiry))
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestStoredGrants(t *testing.T) {
+	code := NewAuthCodeGrant("adminClient", "https://oauth2bin.org/cb", "read", "nonce", PKCEChallenge{}, config.DefaultLifetimes)
+	ropcToken, err := NewROPCToken("adminClient", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ccToken, err := NewClientCredsToken("otherAdminClient", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grants, err := StoredGrants("", "adminClient")
+	if err != nil || len(grants) != 2 {
+		t.Fatalf("Unexpected grants: %+v %v", grants, err)
+	}
+
+	// Newest first
+	if grants[0].Key != ropcToken.AccessToken || grants[0].RefreshToken != ropcToken.RefreshToken || !grants[0].Active || grants[0].RefreshTokenExpiresAt == nil {
+		t.Fatalf("Unexpected token listed: %+v", grants[0])
+	}
+
+	if grants[1].Kind != KindAuthorizationCode || grants[1].Key != code || grants[1].RedirectURI != "https://oauth2bin.org/cb" || grants[1].Nonce != "nonce" {
+		t.Fatalf("Unexpected grant listed: %+v", grants[1])
+	}
+
+	grants, _ = StoredGrants(ClientCredsFlowName, "")
+	found := false
+	for _, grant := range grants {
+		found = found || grant.Key == ccToken.AccessToken
+		if grant.Flow != ClientCredsFlowName {
+			t.Fatalf("Grant of another flow listed: %+v", grant)
+		}
+	}
+
+	if !found {
+		t.Fatal("Client Credentials token not listed")
+	}
+
+	// Everything issued to the client is revoked, and only that
+	revoked, err := RevokeClientGrants("adminClient")
+	if err != nil || revoked != 2 {
+		t.Fatalf("Unexpected number of grants revoked: %d %v", revoked, err)
+	}
+
+	if grants, _ := StoredGrants("", "adminClient"); len(grants) != 0 {
+		t.Fatalf("Grants left after revocation: %+v", grants)
+	}
+
+	if ROPCRefreshTokenExists(ropcToken.RefreshToken, false) || !VerifyClientCredsToken(ccToken.AccessToken) {
+		t.Fatal("Unexpected tokens revoked")
+	}
+}
+
+func TestExpireToken(t *testing.T) {
+	token, err := NewROPCToken("clientID", "", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The access token expires, its refresh token can still be used
+	if !ExpireToken(token.AccessToken, "") || IntrospectToken(token.AccessToken, "").Active {
+		t.Fatal("Access token not expired")
+	}
+
+	if !IntrospectToken(token.RefreshToken, RefreshTokenHint).Active {
+		t.Fatal("Refresh token expired along with its access token")
+	}
+
+	if !ExpireToken(token.RefreshToken, RefreshTokenHint) || ROPCRefreshTokenExists(token.RefreshToken, false) {
+		t.Fatal("Refresh token not expired")
+	}
+
+	// Expired tokens of the flows without refresh tokens are kept until they would have expired
+	ccToken, err := NewClientCredsToken("clientID", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ExpireToken(ccToken.AccessToken, "") || IntrospectToken(ccToken.AccessToken, "").Active || !VerifyClientCredsToken(ccToken.AccessToken) {
+		t.Fatal("Client Credentials token not expired")
+	}
+
+	if ExpireToken("unknown", "") {
+		t.Fatal("Unknown token expired")
+	}
+}
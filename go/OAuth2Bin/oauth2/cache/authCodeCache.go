@@ -2,96 +2,191 @@ package cache
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"oauth2bin/oauth2/config"
 )
 
 const (
-	// Redis HSET which holds the issued tokens
+	// Hash which holds the issued tokens
 	authCodeTokensSet = "OA2B_AC_Tokens"
 
-	// Redis HSET which holds the issued grants until a token request is made.
+	// Hash which indexes the issued tokens by their refresh token
+	authCodeRefreshTokensSet = "OA2B_AC_RefreshTokens"
+
+	// Hash which indexes the issued tokens by their family
+	authCodeFamiliesSet = "OA2B_AC_Families"
+
+	// Hash which holds the issued grants until a token request is made.
 	authCodeGrantSet = "OA2B_AC_Grants"
 
+	// Hash which marks the authorization codes a token was issued for until they expire,
+	// so that concurrent token requests cannot both redeem the same code
+	authCodeRedeemedSet = "OA2B_AC_Redeemed"
+
 	// AuthCodeFlowID is prepended to a refresh token issued by the Authorization Code flow
 	AuthCodeFlowID = "AUTHCODE"
 )
 
+// ErrAuthCodeClientMismatch is returned when an authorization grant is redeemed
+// by a client other than the one it was issued to.
+var ErrAuthCodeClientMismatch = errors.New("authorization grant was issued to another client")
+
 // AuthCodeToken represents a token issued by the Authorization Code flow
 // https://tools.ietf.org/html/rfc6749#section-4.1.3
 type AuthCodeToken struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	// The client the token was issued to, the OpenID Connect nonce sent in the authorization
+	// request and the time at which the user granted the authorization, for including in the ID token.
+	ClientID string `json:"-"`
+	Nonce    string `json:"-"`
+	AuthTime int64  `json:"-"`
 }
 
-// Holds the meta data of an access token
+// Holds the meta data of an access token.
+// GrantedScope is the scope originally granted by the resource owner,
+// which bounds the scope that may be requested on refresh.
+// Family is the first refresh token issued for the grant, shared by the tokens of later refreshes.
+// FamilyExpiry is when the refresh tokens of the family expire, however often they are used,
+// and RefreshTokenExpiry is when the refresh token of this token expires unless used before.
 type authCodeTokenMeta struct {
-	AuthGrant    string    `json:"auth_grant"`
-	CreationTime time.Time `json:"creation_time"`
-	Nonce        string    `json:"nonce"`
+	AuthGrant          string    `json:"auth_grant"`
+	ClientID           string    `json:"client_id"`
+	CreationTime       time.Time `json:"creation_time"`
+	Nonce              string    `json:"nonce"`
+	GrantedScope       string    `json:"granted_scope"`
+	Family             string    `json:"family"`
+	FamilyExpiry       time.Time `json:"family_expiry"`
+	RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
+}
+
+// Returns when the refresh token expires
+func (m authCodeTokenMeta) refreshTokenExpiry() time.Time {
+	return refreshTokenExpiry(m.CreationTime, m.RefreshTokenExpiry)
 }
 
 // Holds the token as well as its metadata.
-// It is the internal representation of the token inside the Redis cache.
+// It is the internal representation of the token inside the store.
 type internalAuthCodeToken struct {
 	Token AuthCodeToken     `json:"token"`
 	Meta  authCodeTokenMeta `json:"meta"`
 }
 
+// Holds the meta data of an authorization grant.
+// It is the internal representation of the grant inside the store.
+// Lifetime is that of the grant in seconds, zero for grants issued before it was configurable.
+type authCodeGrantMeta struct {
+	ClientID     string        `json:"client_id"`
+	CreationTime time.Time     `json:"creation_time"`
+	Scope        string        `json:"scope"`
+	Nonce        string        `json:"nonce"`
+	PKCE         PKCEChallenge `json:"pkce"`
+	Lifetime     int           `json:"lifetime"`
+}
+
+// Returns when the grant expires
+func (g authCodeGrantMeta) expiry() time.Time {
+	lifetime := g.Lifetime
+	if lifetime == 0 {
+		lifetime = config.DefaultLifetimes.AuthCode
+	}
+
+	return g.CreationTime.Add(seconds(lifetime))
+}
+
 // NewAuthCodeToken issues new access tokens for the Authorization Code flow.
-// It searches for 'code' in the Redis cache and throws errors if not found.
-// If found, it checks if it has crossed is expiry limit which is 10 minutes.
+// It searches for 'code' in the store and throws errors if not found.
+// If found, it checks if it has crossed its expiry limit, the lifetime it was issued with.
 // If crossed, an error is thrown.
-// Else a new token is generated and returned.
-// Refer RFC 6749 Section 4.1.2 (https://tools.ietf.org/html/rfc6749#section-4.1.2)
-func NewAuthCodeToken(code, refreshToken, redirectURI string) (*AuthCodeToken, error) {
+// If the grant was issued to a client other than clientID, ErrAuthCodeClientMismatch is returned.
+// If the grant was issued with a PKCE code challenge, codeVerifier is checked against it
+// and ErrMissingCodeVerifier or ErrInvalidCodeVerifier is returned on failure.
+// Else a new token is generated with the lifetimes and returned.
+// Refer RFC 6749 Section 4.1.3 (https://tools.ietf.org/html/rfc6749#section-4.1.3)
+func NewAuthCodeToken(clientID, code, refreshToken, redirectURI, codeVerifier string, lifetimes config.Lifetimes) (*AuthCodeToken, error) {
 	// First check if such an authorization grant has been issued
-	conn := NewConn()
-	defer CloseConn(conn)
-
 	value := code + ":" + redirectURI
-	reply, err := redis.Int(conn.Do("HEXISTS", authCodeGrantSet, value))
-	if err != nil {
-		log.Println("NewAuthCodeToken: " + err.Error())
-		return nil, err
-	}
+	grantBytes, err := store.Get(authCodeGrantSet, value)
 
-	// If 'value' is not found in the Redis cache, there are the following possibilities:
+	// If 'value' is not found in the store, there are the following possibilities:
 	// - A toekn was already issued on this authorization grant and must be revoked.
-	// - It has expired and was removed by housekeep().
+	// - It has expired and was removed by the store.
 	// - It was never issued.
 	// - the redirect URI is wrong.
-	if reply == 0 {
+	if err == ErrNotFound {
 		return nil, fmt.Errorf("recycled/expired/invalid authorization grant or wrong redirect_uri")
+	} else if err != nil {
+		log.Println("NewAuthCodeToken: " + err.Error())
+		return nil, err
 	}
 
-	// If found, check if it has expired since housekeeping runs only every 5 minutes
-	intTime, err := redis.Int64(conn.Do("HGET", authCodeGrantSet, value))
+	// If found, check if it has expired since the store may not have removed it yet
+	var grant authCodeGrantMeta
+	err = json.Unmarshal(grantBytes, &grant)
 	if err != nil {
 		log.Println("NewAuthCodeToken: " + err.Error())
 		return nil, err
 	}
 
-	issueTime := time.Unix(intTime, 0)
-	if time.Now().Sub(issueTime) >= 10*time.Minute {
+	if !time.Now().Before(grant.expiry()) {
 		return nil, fmt.Errorf("expired authorization grant")
 	}
 
-	// If not expired, remove it from the Redis cache since
-	// we're about to issue a token for it.
-	go removeAuthCodeGrant(code, redirectURI)
+	if grant.ClientID != clientID {
+		return nil, ErrAuthCodeClientMismatch
+	}
+
+	err = grant.PKCE.Verify(codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	// If not expired, claim the code before issuing a token for it, so that
+	// it is redeemed only once however many token requests present it at once.
+	// Refer RFC 6749 Section 4.1.2 (https://tools.ietf.org/html/rfc6749#section-4.1.2)
+	claimed, err := store.SetNX(authCodeRedeemedSet, code, []byte(clientID), ttlUntil(grant.expiry()))
+	if err != nil {
+		log.Println("NewAuthCodeToken: " + err.Error())
+		return nil, err
+	}
 
+	if !claimed {
+		return nil, fmt.Errorf("recycled/expired/invalid authorization grant or wrong redirect_uri")
+	}
+
+	removeAuthCodeGrant(code, redirectURI)
+
+	token, err := issueAuthCodeToken(code, refreshToken, "", time.Time{}, grant.ClientID, grant.Scope, grant.Scope, lifetimes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Nonce = grant.Nonce
+	token.AuthTime = grant.CreationTime.Unix()
+	return token, nil
+}
+
+// Generates a token with the given scope and stores it in the store.
+// grantedScope is recorded in the token's metadata for validating future refresh requests.
+// family is that of the refresh token being refreshed, along with the time its refresh tokens expire.
+// If empty, the token starts a new family whose refresh tokens expire after the refresh token lifetime.
+func issueAuthCodeToken(code, refreshToken, family string, familyExpiry time.Time, clientID, scope, grantedScope string, lifetimes config.Lifetimes) (*AuthCodeToken, error) {
 	var token *AuthCodeToken
 	var meta *authCodeTokenMeta
+	var err error
+	exists := true
 
 	// Generates a new key if a duplicate is encoutered
-	for reply == 1 {
-		token, meta = generateAuthCodeToken(code)
+	for exists {
+		token, meta = generateAuthCodeToken(code, clientID, scope, grantedScope, lifetimes.AccessToken)
 
 		// Replace newly-generated refresh token with function parameter 'refreshToken'
 		// if it is of length 72 since SHA-256 generates a string of length 64 and we
@@ -100,56 +195,151 @@ func NewAuthCodeToken(code, refreshToken, redirectURI string) (*AuthCodeToken, e
 			token.RefreshToken = refreshToken
 		}
 
-		reply, err = redis.Int(conn.Do("HEXISTS", authCodeTokensSet, token.AccessToken))
+		meta.Family = family
+		if family == "" {
+			meta.Family = token.RefreshToken
+		}
+
+		meta.FamilyExpiry, meta.RefreshTokenExpiry = refreshTokenExpiries(meta.CreationTime, familyExpiry, lifetimes)
+
+		exists, err = store.Exists(authCodeTokensSet, token.AccessToken)
 		if err != nil {
 			log.Println(err)
 			return nil, err
 		}
 	}
 
-	jsonBytes, err := json.Marshal(internalAuthCodeToken{Token: *token, Meta: *meta})
+	err = storeAuthCodeToken(internalAuthCodeToken{Token: *token, Meta: *meta})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Stores the token until both the access and refresh tokens expire
+// and indexes it by its refresh token and family.
+// The indexes point to the access token last issued, replacing the one issued before it.
+func storeAuthCodeToken(token internalAuthCodeToken) error {
+	jsonBytes, err := json.Marshal(token)
 	if err != nil {
 		panic(err)
 	}
 
-	_, err = conn.Do("HSET", authCodeTokensSet, token.AccessToken, string(jsonBytes))
+	accessTokenExpiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	ttl := ttlUntil(laterOf(accessTokenExpiry, token.Meta.refreshTokenExpiry()))
+
+	err = store.Set(authCodeTokensSet, token.Token.AccessToken, jsonBytes, ttl)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return token, nil
+	return indexAuthCodeToken(token)
+}
+
+// Points the refresh token and family indexes to the token until its refresh token expires
+func indexAuthCodeToken(token internalAuthCodeToken) error {
+	accessToken := []byte(token.Token.AccessToken)
+	ttl := ttlUntil(token.Meta.refreshTokenExpiry())
+
+	err := store.Set(authCodeRefreshTokensSet, token.Token.RefreshToken, accessToken, ttl)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(authCodeFamiliesSet, token.Meta.Family, accessToken, ttl)
 }
 
 // NewAuthCodeRefreshToken returns new token for the previously issued refresh token
-// The refresh token is kept intach and can be used for future requests.
-func NewAuthCodeRefreshToken(refreshToken string) (*AuthCodeToken, error) {
-	code := NewAuthCodeGrant("")
-	token, err := NewAuthCodeToken(code, refreshToken, "")
+// The refresh token is kept intach and can be used for future requests, unless rotate is true.
+// The previously issued access token is invalidated.
+//
+// If rotate is true, a new refresh token is issued in place of the one presented,
+// which is recorded so that its reuse revokes the whole token family.
+// Refer RFC 6749 Section 10.4 (https://tools.ietf.org/html/rfc6749#section-10.4)
+//
+// If scope is empty, the new token is issued with the scope originally granted.
+// Else it must be a subset of the originally granted scope, or ErrInvalidScope is returned.
+// Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
+//
+// The new token is issued with the lifetimes, except that its refresh token expires
+// no later than the refresh tokens issued before it for the grant.
+func NewAuthCodeRefreshToken(refreshToken, scope string, rotate bool, lifetimes config.Lifetimes) (*AuthCodeToken, error) {
+	previous := findAuthCodeToken(refreshToken)
+	if previous == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	scope, err := narrowRefreshScope(scope, previous.Meta.GrantedScope)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is claimed before it is replaced, so that the refresh token
+	// issues a single new token however many requests present it at once
+	claimed, err := claimRefreshedToken(previous.Token.AccessToken, previous.Meta.ClientID, previous.Meta.refreshTokenExpiry())
 	if err != nil {
 		return nil, err
 	}
 
+	if !claimed {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	invalidateAuthCodeToken(previous.Token.AccessToken)
+
+	// Tokens issued before families and their expiry were tracked start their own
+	family, familyExpiry := previous.Meta.Family, previous.Meta.FamilyExpiry
+	if family == "" {
+		family = refreshToken
+	}
+
+	if familyExpiry.IsZero() {
+		familyExpiry = time.Now().Add(seconds(lifetimes.RefreshToken))
+	}
+
+	if !rotate {
+		return issueAuthCodeToken(previous.Meta.AuthGrant, refreshToken, family, familyExpiry, previous.Meta.ClientID, scope, previous.Meta.GrantedScope, lifetimes)
+	}
+
+	token, err := issueAuthCodeToken(previous.Meta.AuthGrant, "", family, familyExpiry, previous.Meta.ClientID, scope, previous.Meta.GrantedScope, lifetimes)
+	if err != nil {
+		return nil, err
+	}
+
+	recordRotatedRefreshToken(refreshToken, family, familyExpiry)
 	return token, nil
 }
 
-// NewAuthCodeGrant generates a new authorization grant and adds it to a Redis cache set.
+// NewAuthCodeGrant generates a new authorization grant and adds it to the store.
 // This function takes the redirect URI as an argument, since RFC 6749 requires the same URI
 // to be used in the token request as was used in the authorization grant request, if any.
 // Thus, we store it along with the authorization grant in order for us to verify it against
 // the one sent in the token request.
 // Refer: https://tools.ietf.org/html/rfc6749#section-4.1.3
-func NewAuthCodeGrant(redirectURI string) string {
+//
+// The client, the scope granted by the user, the OpenID Connect nonce and the PKCE code challenge,
+// if any, are stored alongside the grant so that the token can be issued with that scope and nonce,
+// and the code_verifier in the token request can be checked against the challenge.
+// Refer: https://tools.ietf.org/html/rfc7636#section-4.4
+//
+// The grant expires after the authorization code lifetime in lifetimes.
+func NewAuthCodeGrant(clientID, redirectURI, scope, nonce string, pkce PKCEChallenge, lifetimes config.Lifetimes) string {
 	var code string
-	var reply = 0
+	var added = false
 	var err error
 
+	grant := authCodeGrantMeta{ClientID: clientID, CreationTime: time.Now(), Scope: scope, Nonce: nonce, PKCE: pkce, Lifetime: lifetimes.AuthCode}
+	jsonBytes, err := json.Marshal(grant)
+	if err != nil {
+		panic(err)
+	}
+
 	// In case we get a duplicate value, we iterate until we get a unique one.
-	conn := NewConn()
-	defer CloseConn(conn)
-	for reply == 0 {
+	for !added {
 		code = generateNonce(20)
 		value := code + ":" + redirectURI
-		reply, err = redis.Int(conn.Do("HSET", authCodeGrantSet, value, time.Now().Unix()))
+		added, err = store.SetNX(authCodeGrantSet, value, jsonBytes, ttlUntil(grant.expiry()))
 
 		if err != nil {
 			log.Println(err)
@@ -159,63 +349,120 @@ func NewAuthCodeGrant(redirectURI string) string {
 	return code
 }
 
-// AuthCodeRefreshTokenExists checks if the refresh token exists in the Redis cache
+// AuthCodeRefreshTokenExists checks if the refresh token exists in the store
 // and returns the appropriate boolean value.
 // Params:
 // refreshToken: the token to look for in the cache
 // invalidateIfFound: if true, the token is invalidated if found
 func AuthCodeRefreshTokenExists(refreshToken string, invalidateIfFound bool) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
+	token := findAuthCodeToken(refreshToken)
+	if token == nil {
+		return false
+	}
 
-	var token internalAuthCodeToken
-	items, err := redis.ByteSlices(conn.Do("HGETALL", authCodeTokensSet))
-	if err != nil {
-		log.Println(err)
+	if invalidateIfFound {
+		invalidateAuthCodeToken(token.Token.AccessToken)
 	}
 
-	for i := 1; i < len(items); i += 2 {
-		err := json.Unmarshal(items[i], &token)
-		if err != nil {
+	return true
+}
+
+// Looks up the token last issued with the given refresh token through the refresh token index.
+// Returns nil if not found.
+func findAuthCodeToken(refreshToken string) *internalAuthCodeToken {
+	accessToken, err := store.Get(authCodeRefreshTokensSet, refreshToken)
+	if err != nil {
+		if err != ErrNotFound {
 			log.Println(err)
-			break
 		}
+		return nil
+	}
 
-		if refreshToken == token.Token.RefreshToken {
-			if invalidateIfFound {
-				invalidateAuthCodeToken(token.Token.AccessToken)
-			}
+	// The access token may have been revoked since it was indexed,
+	// and the refresh token may have expired before the store removed it
+	token := lookupAuthCodeToken(string(accessToken))
+	if token == nil || token.Token.RefreshToken != refreshToken || !time.Now().Before(token.Meta.refreshTokenExpiry()) {
+		return nil
+	}
 
-			return true
+	return token
+}
+
+// Removes the token issued with the given refresh token from the store,
+// thereby revoking the refresh token along with its access token.
+// Returns true if a token was removed.
+func invalidateAuthCodeRefreshToken(refreshToken string) bool {
+	token := findAuthCodeToken(refreshToken)
+	if token == nil {
+		return false
+	}
+
+	invalidateAuthCodeToken(token.Token.AccessToken)
+
+	err := store.Delete(authCodeRefreshTokensSet, refreshToken)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return true
+}
+
+// Removes the token last issued in the family from the store, along with its refresh token.
+// Since every refresh invalidates the access token it replaces, it is the only one left.
+func revokeAuthCodeTokenFamily(family string) {
+	accessToken, err := store.Get(authCodeFamiliesSet, family)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
 		}
+		return
 	}
 
-	return false
+	token := lookupAuthCodeToken(string(accessToken))
+	if token != nil && token.Meta.Family == family {
+		invalidateAuthCodeRefreshToken(token.Token.RefreshToken)
+	}
+
+	err = store.Delete(authCodeFamiliesSet, family)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
-// VerifyAuthCodeToken checks if the token exists in the Redis cache.
+// VerifyAuthCodeToken checks if the token exists in the store.
 // Returns true if token found, false otherwise.
 func VerifyAuthCodeToken(token string) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
-
-	_, err := redis.String(conn.Do("HGET", authCodeTokensSet, token))
-	return err == nil
+	exists, err := store.Exists(authCodeTokensSet, token)
+	return err == nil && exists
 }
 
 func removeAuthCodeGrant(code, redirectURI string) {
-	conn := NewConn()
-	defer CloseConn(conn)
-	_, err := conn.Do("HDEL", authCodeGrantSet, code+":"+redirectURI)
+	err := store.Delete(authCodeGrantSet, code+":"+redirectURI)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// Fetches the access token along with its metadata from the store.
+// Returns nil if not found.
+func lookupAuthCodeToken(accessToken string) *internalAuthCodeToken {
+	jsonBytes, err := store.Get(authCodeTokensSet, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	var token internalAuthCodeToken
+	err = json.Unmarshal(jsonBytes, &token)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &token
+}
+
 func invalidateAuthCodeToken(accessToken string) {
-	conn := NewConn()
-	defer CloseConn(conn)
-	_, err := conn.Do("HDEL", authCodeTokensSet, accessToken)
+	err := store.Delete(authCodeTokensSet, accessToken)
 	if err != nil {
 		log.Println(err)
 	}
@@ -226,7 +473,7 @@ func invalidateAuthCodeToken(accessToken string) {
 // the code, time of creation and a nonce.
 // Refresh token starts with the flow identifier "AUTHCODE" followed by a hex-encoded string of
 // the SHA-256 hash of the concatenate of time of creation and the same nonce as above.
-func generateAuthCodeToken(code string) (*AuthCodeToken, *authCodeTokenMeta) {
+func generateAuthCodeToken(code, clientID, scope, grantedScope string, expiresIn int) (*AuthCodeToken, *authCodeTokenMeta) {
 	nonce := generateNonce(16)
 	creationTime := time.Now()
 
@@ -236,62 +483,14 @@ func generateAuthCodeToken(code string) (*AuthCodeToken, *authCodeTokenMeta) {
 	return &AuthCodeToken{
 			AccessToken:  accessToken,
 			RefreshToken: refreshToken,
-			ExpiresIn:    3600,
+			ExpiresIn:    expiresIn,
+			Scope:        scope,
+			ClientID:     clientID,
 		}, &authCodeTokenMeta{
 			AuthGrant:    code,
+			ClientID:     clientID,
 			CreationTime: creationTime,
 			Nonce:        nonce,
+			GrantedScope: grantedScope,
 		}
 }
-
-// Housekeeping service for the Auth Code tokens set
-func authCodeTokenHousekeep(conn redis.Conn) {
-	var token internalAuthCodeToken
-	var err error
-	var diff time.Duration
-
-	items, err := redis.ByteSlices(conn.Do("HGETALL", authCodeTokensSet))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	for i := 1; i < len(items); i += 2 {
-		err = json.Unmarshal(items[i], &token)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-
-		diff = time.Now().Sub(token.Meta.CreationTime)
-		if diff >= time.Hour {
-			_, err := conn.Do("HDEL", authCodeTokensSet, items[i-1])
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-}
-
-// Housekeeping service for the Auth Code tokens set
-func authCodeGrantHousekeep(conn redis.Conn) {
-	var intTime int64
-	var issueTime time.Time
-
-	grants, err := redis.Strings(conn.Do("HGETALL", authCodeGrantSet))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	for i := 1; i < len(grants); i += 2 {
-		intTime, _ = strconv.ParseInt(grants[i], 10, 64)
-		issueTime = time.Unix(intTime, 0)
-		if time.Now().Sub(issueTime) >= time.Minute*10 {
-			_, err = conn.Do("HDEL", authCodeGrantSet, grants[i-1])
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-}
@@ -1,18 +1,23 @@
 package cache
 
-import "testing"
+import (
+	"sync"
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
 
 // TestAuthCodeFlow tests the entirely of the functions set of authCodeStore
 // as they would be used by the Authorization Code flow
 func TestAuthCodeFlow(t *testing.T) {
 	// Generating an authorization grant which would
 	// be generated after the user authorizes the client app.
-	code := NewAuthCodeGrant("https://oauth2bin.org")
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "", "", PKCEChallenge{}, config.DefaultLifetimes)
 	t.Logf("Generated authorization code grant: %s\n", code)
 
 	// Generating a token based on the grant which would
 	// be generated by invoking the token endpoint
-	token, err := NewAuthCodeToken(code, "", "https://oauth2bin.org")
+	token, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token:\n%s\n", err)
 	}
@@ -26,7 +31,7 @@ func TestAuthCodeFlow(t *testing.T) {
 	}
 
 	// Issue new token based on the previously issued refresh token
-	token, err = NewAuthCodeRefreshToken(token.RefreshToken)
+	token, err = NewAuthCodeRefreshToken(token.RefreshToken, "", false, config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token from refresh token\n")
 	}
@@ -37,8 +42,8 @@ func TestAuthCodeFlow(t *testing.T) {
 }
 
 func TestRefreshTokenExists(t *testing.T) {
-	code := NewAuthCodeGrant("https://oauth2bin.org")
-	token, err := NewAuthCodeToken(code, "", "https://oauth2bin.org")
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "", "", PKCEChallenge{}, config.DefaultLifetimes)
+	token, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -52,3 +57,72 @@ func TestRefreshTokenExists(t *testing.T) {
 		t.Fatal("failed to find refresh token")
 	}
 }
+
+func TestAuthCodePKCE(t *testing.T) {
+	pkce, err := NewPKCEChallenge(testChallenge, PKCES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "", "", pkce, config.DefaultLifetimes)
+
+	_, err = NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+	if err != ErrMissingCodeVerifier {
+		t.Fatalf("Expected ErrMissingCodeVerifier, got: %v", err)
+	}
+
+	_, err = NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", testVerifier[1:]+"A", config.DefaultLifetimes)
+	if err != ErrInvalidCodeVerifier {
+		t.Fatalf("Expected ErrInvalidCodeVerifier, got: %v", err)
+	}
+
+	token, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", testVerifier, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatalf("Could not generate token with valid code_verifier:\n%s\n", err)
+	}
+
+	invalidateAuthCodeToken(token.AccessToken)
+}
+
+func TestAuthCodeClientBinding(t *testing.T) {
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "", "", PKCEChallenge{}, config.DefaultLifetimes)
+
+	_, err := NewAuthCodeToken("otherClientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+	if err != ErrAuthCodeClientMismatch {
+		t.Fatalf("Expected ErrAuthCodeClientMismatch, got: %v", err)
+	}
+
+	// The grant is left for the client it was issued to
+	token, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatalf("Could not generate token for the client the grant was issued to:\n%s\n", err)
+	}
+
+	invalidateAuthCodeToken(token.AccessToken)
+}
+
+func TestAuthCodeConcurrentRedemption(t *testing.T) {
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "", "", PKCEChallenge{}, config.DefaultLifetimes)
+
+	// Only one of several concurrent token requests for the code is issued a token
+	tokens := make(chan *AuthCodeToken, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(tokens); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+			if err == nil {
+				tokens <- token
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	if len(tokens) != 1 {
+		t.Fatalf("Authorization code redeemed %d times", len(tokens))
+	}
+
+	invalidateAuthCodeToken((<-tokens).AccessToken)
+}
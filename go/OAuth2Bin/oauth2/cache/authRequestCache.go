@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// Hash which holds the authorization requests awaiting the user's decision
+	authRequestSet = "OA2B_AuthRequests"
+
+	// Time the user has to accept or deny an authorization request
+	authRequestLifetime = 10 * time.Minute
+)
+
+// ErrInvalidAuthorizationRequest is returned when an authorization request is unknown,
+// has expired or was already accepted or denied.
+var ErrInvalidAuthorizationRequest = errors.New("the authorization request has expired or was already answered")
+
+// AuthorizationRequest holds the parameters of an authorization request which were validated
+// by the authorization endpoint, until the user accepts or denies it on the authorization screen.
+// Keeping them in the store binds the response to the validated client and redirect URI,
+// since the authorization screen only carries the ID of the request.
+// Refer RFC 6749 Section 4.1.1 (https://tools.ietf.org/html/rfc6749#section-4.1.1)
+type AuthorizationRequest struct {
+	Flow         int           `json:"flow"`
+	ClientID     string        `json:"client_id"`
+	RedirectURI  string        `json:"redirect_uri"`
+	ResponseType string        `json:"response_type"`
+	Scope        string        `json:"scope"`
+	State        string        `json:"state"`
+	Nonce        string        `json:"nonce"`
+	PKCE         PKCEChallenge `json:"pkce"`
+	CreationTime time.Time     `json:"creation_time"`
+}
+
+// NewAuthorizationRequest adds the authorization request to the store
+// and returns the ID to be carried by the authorization screen.
+func NewAuthorizationRequest(request AuthorizationRequest) (string, error) {
+	request.CreationTime = time.Now()
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		panic(err)
+	}
+
+	// Generates a new ID if a duplicate is encountered
+	var id string
+	added := false
+	for !added {
+		id = hash(fmt.Sprintf("%s%s", request.CreationTime, generateNonce(16)))
+
+		added, err = store.SetNX(authRequestSet, id, jsonBytes, authRequestLifetime)
+		if err != nil {
+			log.Println(err)
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// ConsumeAuthorizationRequest returns the authorization request and removes it from the store,
+// so that the user can accept or deny it only once.
+// ErrInvalidAuthorizationRequest is returned if the request is not found or has expired.
+func ConsumeAuthorizationRequest(id string) (*AuthorizationRequest, error) {
+	jsonBytes, err := store.Get(authRequestSet, id)
+	if err == ErrNotFound {
+		return nil, ErrInvalidAuthorizationRequest
+	} else if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	err = store.Delete(authRequestSet, id)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var request AuthorizationRequest
+	err = json.Unmarshal(jsonBytes, &request)
+	if err != nil || time.Now().Sub(request.CreationTime) >= authRequestLifetime {
+		return nil, ErrInvalidAuthorizationRequest
+	}
+
+	return &request, nil
+}
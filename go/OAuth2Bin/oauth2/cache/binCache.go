@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Hash which holds the requests recorded in each bin, by bin ID
+	binSet = "OA2B_Bins"
+
+	// BinLifetime is how long a bin is kept after it was created or a request was last recorded in it
+	BinLifetime = 24 * time.Hour
+
+	// Number of requests kept in a bin, the oldest are dropped first
+	maxBinRequests = 50
+)
+
+// ErrUnknownBin is returned when a bin was never created or has expired
+var ErrUnknownBin = errors.New("unknown or expired bin")
+
+// Serializes the updates of the bins made by this server, each bin being read and written as a whole
+var binMut sync.Mutex
+
+// BinRequest is a request recorded in a bin along with the response it was sent,
+// with the secrets they carried redacted.
+type BinRequest struct {
+	Time            time.Time           `json:"time"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	RemoteAddr      string              `json:"remoteAddr"`
+	Headers         map[string][]string `json:"headers"`
+	Params          map[string][]string `json:"params,omitempty"`
+	Body            string              `json:"body,omitempty"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"responseHeaders"`
+	Response        string              `json:"response,omitempty"`
+	DurationMs      float64             `json:"durationMs"`
+}
+
+// NewBin creates an empty bin and returns its ID, to be sent along with
+// the requests which are to be recorded in it
+func NewBin() (string, error) {
+	// Generates a new ID if a duplicate is encountered
+	for {
+		id := generateNonce(16)
+		added, err := store.SetNX(binSet, id, []byte("[]"), BinLifetime)
+		if err != nil {
+			log.Println(err)
+			return "", err
+		}
+
+		if added {
+			return id, nil
+		}
+	}
+}
+
+// BinRequests returns the requests recorded in the bin, oldest first.
+// ErrUnknownBin is returned if the bin is not found.
+func BinRequests(binID string) ([]BinRequest, error) {
+	jsonBytes, err := store.Get(binSet, binID)
+	if err == ErrNotFound {
+		return nil, ErrUnknownBin
+	} else if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var requests []BinRequest
+	err = json.Unmarshal(jsonBytes, &requests)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// RecordBinRequest appends the request to the bin, dropping the oldest requests beyond
+// the number kept, and extends the lifetime of the bin.
+// ErrUnknownBin is returned if the bin is not found, since bins are only created by NewBin.
+func RecordBinRequest(binID string, request BinRequest) error {
+	binMut.Lock()
+	defer binMut.Unlock()
+
+	requests, err := BinRequests(binID)
+	if err != nil {
+		return err
+	}
+
+	requests = append(requests, request)
+	if len(requests) > maxBinRequests {
+		requests = requests[len(requests)-maxBinRequests:]
+	}
+
+	jsonBytes, err := json.Marshal(requests)
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(binSet, binID, jsonBytes, BinLifetime)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
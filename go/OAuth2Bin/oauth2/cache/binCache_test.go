@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBin(t *testing.T) {
+	binID, err := NewBin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err := BinRequests(binID)
+	if err != nil || len(requests) != 0 {
+		t.Fatalf("New bin not empty: %v %v", requests, err)
+	}
+
+	// The oldest requests are dropped beyond the number kept
+	for i := 0; i < maxBinRequests+5; i++ {
+		err = RecordBinRequest(binID, BinRequest{Method: "GET", Path: fmt.Sprintf("/echo/%d", i), Status: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests, err = BinRequests(binID)
+	if err != nil || len(requests) != maxBinRequests {
+		t.Fatalf("Unexpected number of requests: %d %v", len(requests), err)
+	}
+
+	if requests[0].Path != "/echo/5" || requests[maxBinRequests-1].Path != fmt.Sprintf("/echo/%d", maxBinRequests+4) {
+		t.Fatalf("Unexpected requests kept: %s to %s", requests[0].Path, requests[maxBinRequests-1].Path)
+	}
+
+	// Requests are only recorded in bins which were created
+	if RecordBinRequest("unknown", BinRequest{}) != ErrUnknownBin {
+		t.Fatal("Request recorded in an unknown bin")
+	}
+
+	if _, err := BinRequests("unknown"); err != ErrUnknownBin {
+		t.Fatalf("Expected ErrUnknownBin, got %v", err)
+	}
+}
@@ -1,10 +1,25 @@
 package cache
 
 import (
+	cryptorand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"math/rand"
+	"strings"
 	"time"
+
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is expired, revoked or was never issued.
+	ErrInvalidRefreshToken = errors.New("expired or invalid refresh token")
+
+	// ErrInvalidScope is returned when the scope requested on refresh
+	// exceeds the scope originally granted.
+	ErrInvalidScope = errors.New("requested scope exceeds the scope originally granted")
 )
 
 // Hashes the string using SHA-256
@@ -16,7 +31,8 @@ func hash(str string) string {
 
 const src = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-// Generates a string of given length filled with random bytes
+// Generates a string of given length filled with random bytes.
+// The string is predictable by anyone who can guess the seed, so it is not to be used for credentials.
 func generateNonce(n int) string {
 	if n < 1 {
 		return ""
@@ -32,7 +48,115 @@ func generateNonce(n int) string {
 	return string(b)
 }
 
+// Generates a string of given length filled with bytes from a cryptographically secure source,
+// for use as credentials and other values which must not be guessed
+func generateSecret(n int) string {
+	return generateSecretFrom(src, n)
+}
+
+// Generates a string of given length filled with characters of chars drawn from a cryptographically secure source
+func generateSecretFrom(chars string, n int) string {
+	if n < 1 {
+		return ""
+	}
+
+	b := make([]byte, n)
+
+	// Bytes beyond the largest multiple of the number of characters are dropped so that each character is equally likely
+	limit := 256 - 256%len(chars)
+	random := make([]byte, 1)
+	for i := range b {
+		for {
+			_, err := cryptorand.Read(random)
+			if err != nil {
+				panic(err)
+			}
+
+			if int(random[0]) < limit {
+				b[i] = chars[int(random[0])%len(chars)]
+				break
+			}
+		}
+	}
+
+	return string(b)
+}
+
+// Returns the scope for a token issued on refresh.
+// An empty requested scope is treated as equal to the scope originally granted.
+// Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
+func narrowRefreshScope(requested, granted string) (string, error) {
+	scopes := utils.ParseScope(requested)
+	if len(scopes) == 0 {
+		return granted, nil
+	}
+
+	if !utils.IsScopeSubset(scopes, utils.ParseScope(granted)) {
+		return "", ErrInvalidScope
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
 func init() {
 	// Seeding the random package
 	rand.Seed(time.Now().UnixNano())
 }
+
+// Returns the number of seconds as a duration
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// Returns the later of the two times
+func laterOf(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// Returns the time left until expiry, for use as the TTL of a record in the store.
+// A record past its expiry gets the shortest TTL possible, since a TTL of zero would keep it forever.
+func ttlUntil(expiry time.Time) time.Duration {
+	ttl := time.Until(expiry)
+	if ttl < time.Millisecond {
+		return time.Millisecond
+	}
+
+	return ttl
+}
+
+// Returns the TTL of an access token issued now which expires in 'expiresIn' seconds.
+// A token issued already expired is kept for the shortest TTL, since a TTL of zero means no expiry.
+func accessTokenTTL(expiresIn int) time.Duration {
+	return ttlUntil(time.Now().Add(seconds(expiresIn)))
+}
+
+// Returns when the refresh tokens of a family expire and when a refresh token issued at creationTime
+// in it expires unless used before. A zero familyExpiry starts a new family whose refresh tokens
+// expire after the absolute refresh token lifetime, while every refresh token expires after the
+// idle lifetime or with its family, whichever comes first.
+func refreshTokenExpiries(creationTime, familyExpiry time.Time, lifetimes config.Lifetimes) (time.Time, time.Time) {
+	if familyExpiry.IsZero() {
+		familyExpiry = creationTime.Add(seconds(lifetimes.RefreshToken))
+	}
+
+	idleExpiry := creationTime.Add(seconds(lifetimes.RefreshTokenIdle))
+	if idleExpiry.After(familyExpiry) {
+		return familyExpiry, familyExpiry
+	}
+
+	return familyExpiry, idleExpiry
+}
+
+// Returns when a refresh token issued at creationTime expires, given the expiry stored along with it.
+// Refresh tokens issued before their lifetime was configurable have no stored expiry.
+func refreshTokenExpiry(creationTime, expiry time.Time) time.Time {
+	if expiry.IsZero() {
+		return creationTime.Add(refreshTokenLifetime)
+	}
+
+	return expiry
+}
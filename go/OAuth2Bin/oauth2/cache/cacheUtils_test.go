@@ -2,7 +2,11 @@ package cache
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"oauth2bin/oauth2/config"
 )
 
 var strLen = 16
@@ -30,3 +34,71 @@ func testGenerateNonceFunc(n, i int) func(*testing.T) {
 		generated[i] = newStr
 	}
 }
+
+func TestGenerateSecret(t *testing.T) {
+	generated := make(map[string]bool)
+	for i := 0; i < iterations; i++ {
+		secret := generateSecret(strLen)
+		if len(secret) != strLen || strings.Trim(secret, src) != "" {
+			t.Fatalf("Unexpected secret generated: %q", secret)
+		}
+
+		if generated[secret] {
+			t.Fatalf("Duplicate secret on attempt #%d", i)
+		}
+		generated[secret] = true
+	}
+}
+
+func TestLifetimes(t *testing.T) {
+	short := config.Lifetimes{AccessToken: 1, RefreshToken: 1, RefreshTokenIdle: 60, AuthCode: 1}
+	idle := config.Lifetimes{AccessToken: 60, RefreshToken: 60, RefreshTokenIdle: 1, AuthCode: 60}
+
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "read", "", PKCEChallenge{}, short)
+	implicit, err := NewImplicitToken("clientID", "read", short)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if implicit.ExpiresIn != 1 {
+		t.Fatalf("Token issued with expires_in %d, expected 1", implicit.ExpiresIn)
+	}
+
+	first, err := NewROPCToken("clientID", "", "read", short)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing does not extend the absolute lifetime of the refresh token
+	refreshed, err := NewROPCRefreshToken(first.RefreshToken, "", true, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unused, err := NewROPCToken("clientID", "", "read", idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err = NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", short); err == nil {
+		t.Fatal("Token issued for an expired authorization grant")
+	}
+
+	if _, ok := VerifyAccessToken(implicit.AccessToken); ok {
+		t.Fatal("Expired access token accepted")
+	}
+
+	if _, err = NewROPCRefreshToken(refreshed.RefreshToken, "", false, short); err != ErrInvalidRefreshToken {
+		t.Fatalf("Refresh token accepted past its absolute lifetime: %v", err)
+	}
+
+	if _, err = NewROPCRefreshToken(unused.RefreshToken, "", false, idle); err != ErrInvalidRefreshToken {
+		t.Fatalf("Refresh token accepted past its idle lifetime: %v", err)
+	}
+
+	if _, ok := VerifyAccessToken(unused.AccessToken); !ok {
+		t.Fatal("Access token expired along with its refresh token")
+	}
+}
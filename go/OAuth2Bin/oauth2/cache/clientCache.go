@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+const (
+	// Hash which holds the clients registered through the Dynamic Client Registration endpoint
+	clientsSet = "OA2B_Clients"
+
+	// ClientIDPrefix is prepended to the IDs of dynamically registered clients
+	ClientIDPrefix = "DYNCLNT_"
+)
+
+// Client authentication methods at the token endpoint.
+// Refer RFC 7591 Section 2 (https://tools.ietf.org/html/rfc7591#section-2)
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+)
+
+// ErrInvalidRegistrationToken is returned when the registration access token is missing,
+// does not match the one issued to the client, or the client is not registered.
+var ErrInvalidRegistrationToken = errors.New("invalid registration access token or client_id")
+
+// ClientMetadata holds the metadata of a client sent in a registration request.
+// Refer RFC 7591 Section 2 (https://tools.ietf.org/html/rfc7591#section-2)
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	Contacts                []string `json:"contacts,omitempty"`
+}
+
+// ClientRegistration represents a registered client along with its credentials.
+// It is returned in response to registration and client read requests.
+// Refer RFC 7591 Section 3.2.1 (https://tools.ietf.org/html/rfc7591#section-3.2.1)
+// and RFC 7592 Section 3 (https://tools.ietf.org/html/rfc7592#section-3)
+//
+// RegistrationClientURI is left to be filled in by the server.
+type ClientRegistration struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   *int64 `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+	ClientMetadata
+}
+
+// IsPublic returns true if the client does not authenticate at the token endpoint
+func (m ClientMetadata) IsPublic() bool {
+	return m.TokenEndpointAuthMethod == AuthMethodNone
+}
+
+// Client returns the registered client as a member of the client registry
+func (c ClientRegistration) Client() config.Client {
+	return config.Client{
+		ID:           c.ClientID,
+		Secret:       c.ClientSecret,
+		GrantTypes:   c.GrantTypes,
+		RedirectURIs: c.RedirectURIs,
+		Scopes:       utils.ParseScope(c.Scope),
+		RequirePKCE:  c.IsPublic(),
+	}
+}
+
+// NewClientRegistration registers a new client with the given metadata.
+// A client secret is generated unless the client is public, along with
+// the registration access token through which the client manages its registration.
+// Refer RFC 7591 Section 3.2.1 (https://tools.ietf.org/html/rfc7591#section-3.2.1)
+func NewClientRegistration(metadata ClientMetadata) (*ClientRegistration, error) {
+	registration := ClientRegistration{
+		ClientIDIssuedAt:        time.Now().Unix(),
+		RegistrationAccessToken: hash(generateSecret(32)),
+		ClientMetadata:          metadata,
+	}
+
+	if !metadata.IsPublic() {
+		// Client secrets issued by the server do not expire
+		var expiresAt int64
+		registration.ClientSecret = hash(generateSecret(32))
+		registration.ClientSecretExpiresAt = &expiresAt
+	}
+
+	// Generates a new client ID if a duplicate is encountered.
+	// The IDs are alphanumeric, so they never contain the reserved config.ClientIDSeparator.
+	added := false
+	for !added {
+		registration.ClientID = ClientIDPrefix + generateSecret(24)
+
+		jsonBytes, err := json.Marshal(registration)
+		if err != nil {
+			panic(err)
+		}
+
+		added, err = store.SetNX(clientsSet, registration.ClientID, jsonBytes, 0)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	return &registration, nil
+}
+
+// LookupClientRegistration returns the registration of the dynamically registered client,
+// or nil if no such client is registered.
+func LookupClientRegistration(clientID string) *ClientRegistration {
+	jsonBytes, err := store.Get(clientsSet, clientID)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
+		}
+		return nil
+	}
+
+	var registration ClientRegistration
+	err = json.Unmarshal(jsonBytes, &registration)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &registration
+}
+
+// VerifyClientRegistration returns the registration of the client
+// if the registration access token is the one issued to it.
+// Else ErrInvalidRegistrationToken is returned.
+// Refer RFC 7592 Section 2 (https://tools.ietf.org/html/rfc7592#section-2)
+func VerifyClientRegistration(clientID, registrationAccessToken string) (*ClientRegistration, error) {
+	registration := LookupClientRegistration(clientID)
+	if registration == nil || registrationAccessToken == "" ||
+		subtle.ConstantTimeCompare([]byte(registration.RegistrationAccessToken), []byte(registrationAccessToken)) != 1 {
+		return nil, ErrInvalidRegistrationToken
+	}
+
+	return registration, nil
+}
+
+// UpdateClientRegistration replaces the metadata of the registered client.
+// The client ID, client secret and registration access token are kept as they were issued,
+// except that a secret is generated for a public client which becomes confidential and
+// removed from a confidential client which becomes public.
+// Refer RFC 7592 Section 2.2 (https://tools.ietf.org/html/rfc7592#section-2.2)
+func UpdateClientRegistration(clientID, registrationAccessToken string, metadata ClientMetadata) (*ClientRegistration, error) {
+	registration, err := VerifyClientRegistration(clientID, registrationAccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.IsPublic() {
+		registration.ClientSecret = ""
+		registration.ClientSecretExpiresAt = nil
+	} else if registration.ClientSecret == "" {
+		var expiresAt int64
+		registration.ClientSecret = hash(generateSecret(32))
+		registration.ClientSecretExpiresAt = &expiresAt
+	}
+
+	registration.ClientMetadata = metadata
+
+	jsonBytes, err := json.Marshal(registration)
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(clientsSet, clientID, jsonBytes, 0)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return registration, nil
+}
+
+// DeleteClientRegistration removes the registered client.
+// The tokens already issued to it remain valid until they expire.
+// Refer RFC 7592 Section 2.3 (https://tools.ietf.org/html/rfc7592#section-2.3)
+func DeleteClientRegistration(clientID, registrationAccessToken string) error {
+	_, err := VerifyClientRegistration(clientID, registrationAccessToken)
+	if err != nil {
+		return err
+	}
+
+	err = store.Delete(clientsSet, clientID)
+	if err != nil {
+		log.Println(err)
+		return fmt.Errorf("could not delete client: %s", err)
+	}
+
+	return nil
+}
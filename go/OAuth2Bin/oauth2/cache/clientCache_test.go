@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestClientRegistration(t *testing.T) {
+	registration, err := NewClientRegistration(ClientMetadata{
+		TokenEndpointAuthMethod: AuthMethodNone,
+		GrantTypes:              []string{"authorization_code"},
+		RedirectURIs:            []string{"https://oauth2bin.org/callback"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(registration.ClientID, config.ClientIDSeparator) {
+		t.Fatalf("Client ID %s contains the reserved separator", registration.ClientID)
+	}
+
+	if !strings.HasPrefix(registration.ClientID, ClientIDPrefix) || registration.ClientSecret != "" ||
+		registration.RegistrationAccessToken == "" {
+		t.Fatalf("Unexpected registration of a public client: %+v", registration)
+	}
+
+	_, err = VerifyClientRegistration(registration.ClientID, "wrong")
+	if err != ErrInvalidRegistrationToken {
+		t.Fatalf("Expected ErrInvalidRegistrationToken, got %v", err)
+	}
+
+	// A public client becoming confidential is issued a secret
+	updated, err := UpdateClientRegistration(registration.ClientID, registration.RegistrationAccessToken, ClientMetadata{
+		TokenEndpointAuthMethod: AuthMethodClientSecretBasic,
+		GrantTypes:              []string{"client_credentials"},
+		Scope:                   "read",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := LookupClientRegistration(registration.ClientID).Client()
+	if updated.ClientSecret == "" || client.Secret != updated.ClientSecret ||
+		!client.AllowsGrantType("client_credentials") || len(client.Scopes) != 1 {
+		t.Fatalf("Unexpected client after update: %+v", client)
+	}
+
+	err = DeleteClientRegistration(registration.ClientID, registration.RegistrationAccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if LookupClientRegistration(registration.ClientID) != nil {
+		t.Fatal("Client still registered after deletion")
+	}
+}
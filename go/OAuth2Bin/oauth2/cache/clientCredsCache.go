@@ -6,11 +6,11 @@ import (
 	"log"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"oauth2bin/oauth2/config"
 )
 
 const (
-	// Redis HSET which holds the issued tokens
+	// Hash which holds the issued tokens
 	clientCredsTokensSet = "OA2B_CC_Tokens"
 
 	// ClientCredsFlowID is prepended to access and refresh tokens issued by the Client Credentials flow
@@ -22,38 +22,37 @@ const (
 type ClientCredentialsToken struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
 }
 
 // Holds the meta data of an access token
 type clientCredsTokenMeta struct {
+	ClientID     string    `json:"client_id"`
 	CreationTime time.Time `json:"creation_time"`
 	Nonce        string    `json:"nonce"`
 }
 
 // Holds the token as well as its metadata.
-// It is the internal representation of the token inside the Redis cache.
+// It is the internal representation of the token inside the store.
 type internalClientCredsToken struct {
 	Token ClientCredentialsToken `json:"token"`
 	Meta  clientCredsTokenMeta   `json:"meta"`
 }
 
 // NewClientCredsToken issues new access tokens for the Client Credentials flow.
-// It generates and stores a token and stores it along with its meta data
-// in the Redis cache.
-func NewClientCredsToken() (*ClientCredentialsToken, error) {
-	conn := NewConn()
-	defer CloseConn(conn)
-
+// It generates a token with the given scope and the access token lifetime for the client
+// and stores it along with its meta data in the store.
+func NewClientCredsToken(clientID, scope string, lifetimes config.Lifetimes) (*ClientCredentialsToken, error) {
 	var token *ClientCredentialsToken
 	var meta *clientCredsTokenMeta
 	var err error
-	reply := 1
+	exists := true
 
 	// Generates a new key if a duplicate is encountered
-	for reply == 1 {
-		token, meta = generateClientCredsToken()
+	for exists {
+		token, meta = generateClientCredsToken(clientID, scope, lifetimes.AccessToken)
 
-		reply, err = redis.Int(conn.Do("HEXISTS", clientCredsTokensSet, token.AccessToken))
+		exists, err = store.Exists(clientCredsTokensSet, token.AccessToken)
 		if err != nil {
 			log.Println(err)
 			return nil, err
@@ -65,7 +64,8 @@ func NewClientCredsToken() (*ClientCredentialsToken, error) {
 		panic(err)
 	}
 
-	_, err = conn.Do("HSET", clientCredsTokensSet, token.AccessToken, string(jsonBytes))
+	// The token is removed from the store once it expires
+	err = store.Set(clientCredsTokensSet, token.AccessToken, jsonBytes, accessTokenTTL(token.ExpiresIn))
 	if err != nil {
 		return nil, err
 	}
@@ -73,20 +73,33 @@ func NewClientCredsToken() (*ClientCredentialsToken, error) {
 	return token, nil
 }
 
-// VerifyClientCredsToken checks if the token exists in the Redis cache.
+// VerifyClientCredsToken checks if the token exists in the store.
 // Returns true if token found, false otherwise.
 func VerifyClientCredsToken(token string) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
+	exists, err := store.Exists(clientCredsTokensSet, token)
+	return err == nil && exists
+}
 
-	_, err := redis.String(conn.Do("HGET", clientCredsTokensSet, token))
-	return err == nil
+// Fetches the access token along with its metadata from the store.
+// Returns nil if not found.
+func lookupClientCredsToken(accessToken string) *internalClientCredsToken {
+	jsonBytes, err := store.Get(clientCredsTokensSet, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	var token internalClientCredsToken
+	err = json.Unmarshal(jsonBytes, &token)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &token
 }
 
 func invalidateClientCredsToken(accessToken string) {
-	conn := NewConn()
-	defer CloseConn(conn)
-	_, err := conn.Do("HDEL", clientCredsTokensSet, accessToken)
+	err := store.Delete(clientCredsTokensSet, accessToken)
 	if err != nil {
 		log.Println(err)
 	}
@@ -95,7 +108,7 @@ func invalidateClientCredsToken(accessToken string) {
 // Generates an access token.
 // Access token is a hex-encoded string of the SHA-256 hash of the
 // concatenation of the time of creation and a nonce.
-func generateClientCredsToken() (*ClientCredentialsToken, *clientCredsTokenMeta) {
+func generateClientCredsToken(clientID, scope string, expiresIn int) (*ClientCredentialsToken, *clientCredsTokenMeta) {
 	nonce := generateNonce(16)
 	creationTime := time.Now()
 
@@ -103,38 +116,11 @@ func generateClientCredsToken() (*ClientCredentialsToken, *clientCredsTokenMeta)
 
 	return &ClientCredentialsToken{
 			AccessToken: accessToken,
-			ExpiresIn:   3600,
+			ExpiresIn:   expiresIn,
+			Scope:       scope,
 		}, &clientCredsTokenMeta{
+			ClientID:     clientID,
 			CreationTime: creationTime,
 			Nonce:        nonce,
 		}
 }
-
-// Housekeeping service for the Client Credentials tokens set
-func clientCredsTokenHousekeep(conn redis.Conn) {
-	var token internalClientCredsToken
-	var err error
-	var diff time.Duration
-
-	items, err := redis.ByteSlices(conn.Do("HGETALL", clientCredsTokensSet))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	for i := 1; i < len(items); i += 2 {
-		err = json.Unmarshal(items[i], &token)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-
-		diff = time.Now().Sub(token.Meta.CreationTime)
-		if diff >= time.Hour {
-			_, err = conn.Do("HDEL", clientCredsTokensSet, items[i-1])
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-}
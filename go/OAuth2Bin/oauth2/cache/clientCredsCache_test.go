@@ -1,20 +1,24 @@
 package cache
 
-import "testing"
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
 
 // TestClientCredsFlow tests the entirely of the functions set of authCodeStore
 // as they would be used by the Implicit Grant flow
 func TestClientCredsFlow(t *testing.T) {
 	// Generating a token which would be done once the user authorizes
 	// the client application
-	token, err := NewClientCredsToken()
+	token, err := NewClientCredsToken("clientID", "", config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token:\n%s\n", err)
 	}
 
 	t.Logf("Token generated: %s\n", token.AccessToken)
 
-	// Checks if token exists in the Redis cache
+	// Checks if token exists in the store
 	res := VerifyClientCredsToken(token.AccessToken)
 	if !res {
 		t.Fatalf("Client Credentials token verification failed\n")
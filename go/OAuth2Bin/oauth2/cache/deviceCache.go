@@ -0,0 +1,480 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"oauth2bin/oauth2/config"
+)
+
+const (
+	// Hash which holds the device authorization requests until a token is issued for them
+	deviceGrantSet = "OA2B_DC_Grants"
+
+	// Hash which maps user codes to the device codes of pending authorization requests
+	deviceUserCodeSet = "OA2B_DC_UserCodes"
+
+	// Hash which holds the user's decision on the authorization requests.
+	// It is kept apart from the requests so that a decision is set only once
+	// and is never overwritten by a device polling at the same time.
+	deviceDecisionSet = "OA2B_DC_Decisions"
+
+	// Hash which holds the polling interval and the time of the last poll of the pending requests
+	devicePollSet = "OA2B_DC_Polls"
+
+	// Hash which marks the approved requests a token was issued for,
+	// so that overlapping polls cannot both redeem the device code
+	deviceRedeemedSet = "OA2B_DC_Redeemed"
+
+	// Hash which holds the issued tokens
+	deviceTokensSet = "OA2B_DC_Tokens"
+
+	// DeviceFlowID is prepended to device codes and access tokens issued by the Device Authorization flow
+	DeviceFlowID = "DEVICECD"
+
+	// Lifetime of the device code and user code
+	deviceCodeLifetime = 10 * time.Minute
+
+	// Minimum number of seconds the client must wait between polling requests.
+	// It is increased by 5 seconds every time the client polls too fast.
+	// Refer RFC 8628 Section 3.5 (https://tools.ietf.org/html/rfc8628#section-3.5)
+	devicePollingInterval = 5
+
+	// Characters of the user code. Vowels are left out to avoid forming words
+	// and the code is case-insensitive so that it is easy to type on any device.
+	// Refer RFC 8628 Section 6.1 (https://tools.ietf.org/html/rfc8628#section-6.1)
+	userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// Status of a device authorization request
+const (
+	devicePending  = "pending"
+	deviceApproved = "approved"
+	deviceDenied   = "denied"
+)
+
+var (
+	// ErrAuthorizationPending is returned when the user has not yet approved or denied the request
+	ErrAuthorizationPending = errors.New("the user has not yet completed the authorization")
+
+	// ErrSlowDown is returned when the client polls more frequently than the interval allows
+	ErrSlowDown = errors.New("polling too frequently, the interval has been increased by 5 seconds")
+
+	// ErrExpiredToken is returned when the device code has expired
+	ErrExpiredToken = errors.New("the device code has expired")
+
+	// ErrAccessDenied is returned when the user denied the authorization request
+	ErrAccessDenied = errors.New("the user denied the authorization request")
+
+	// ErrInvalidDeviceCode is returned when the device code was never issued or was already used
+	ErrInvalidDeviceCode = errors.New("invalid device code")
+
+	// ErrInvalidUserCode is returned when the user code is unknown, expired or was already used
+	ErrInvalidUserCode = errors.New("invalid or expired user code")
+)
+
+// DeviceAuthorization represents the response to a device authorization request.
+// The verification URIs are left to be filled in by the server.
+// Refer RFC 8628 Section 3.2 (https://tools.ietf.org/html/rfc8628#section-3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceToken represents a token issued by the Device Authorization flow
+// Refer RFC 8628 Section 3.5 (https://tools.ietf.org/html/rfc8628#section-3.5)
+type DeviceToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// Holds the meta data of an access token
+type deviceTokenMeta struct {
+	ClientID     string    `json:"client_id"`
+	CreationTime time.Time `json:"creation_time"`
+	Nonce        string    `json:"nonce"`
+}
+
+// Holds the token as well as its metadata.
+// It is the internal representation of the token inside the store.
+type internalDeviceToken struct {
+	Token DeviceToken     `json:"token"`
+	Meta  deviceTokenMeta `json:"meta"`
+}
+
+// Holds the meta data of a device authorization request.
+// It is the internal representation of the request inside the store
+// and is not changed once stored.
+type deviceGrantMeta struct {
+	ClientID     string    `json:"client_id"`
+	UserCode     string    `json:"user_code"`
+	Scope        string    `json:"scope"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// Holds the polling state of a pending device authorization request
+type devicePollState struct {
+	Interval int       `json:"interval"`
+	LastPoll time.Time `json:"last_poll"`
+}
+
+// NewDeviceAuthorization issues a device code and a user code to the client for the given scope.
+// The request stays pending until the user approves or denies it on the verification page.
+// Refer RFC 8628 Section 3.1 (https://tools.ietf.org/html/rfc8628#section-3.1)
+func NewDeviceAuthorization(clientID, scope string) (*DeviceAuthorization, error) {
+	grant := deviceGrantMeta{
+		ClientID:     clientID,
+		Scope:        scope,
+		CreationTime: time.Now(),
+	}
+
+	deviceCode := DeviceFlowID + hash(fmt.Sprintf("%s%s", grant.CreationTime, generateSecret(32)))
+
+	// Generates a new user code if a duplicate is encountered
+	added := false
+	var err error
+	for !added {
+		grant.UserCode = generateUserCode()
+
+		added, err = store.SetNX(deviceUserCodeSet, grant.UserCode, []byte(deviceCode), deviceCodeLifetime)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	err = storeDeviceGrant(deviceCode, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   grant.UserCode,
+		ExpiresIn:  int(deviceCodeLifetime.Seconds()),
+		Interval:   devicePollingInterval,
+	}, nil
+}
+
+// LookupDeviceUserCode returns the scope requested by the device for presenting it on the verification page.
+// The user code is accepted in any case, with or without the separating dash.
+// ErrInvalidUserCode is returned if no pending request exists for it.
+func LookupDeviceUserCode(userCode string) (string, error) {
+	_, grant, err := findDeviceGrant(normalizeUserCode(userCode))
+	if err != nil {
+		return "", err
+	}
+
+	return grant.Scope, nil
+}
+
+// ResolveDeviceAuthorization records the user's decision on the request identified by the user code.
+// The user code cannot be used again once the decision is made.
+// ErrInvalidUserCode is returned if no pending request exists for it.
+func ResolveDeviceAuthorization(userCode string, approved bool) error {
+	userCode = normalizeUserCode(userCode)
+	deviceCode, grant, err := findDeviceGrant(userCode)
+	if err != nil {
+		return err
+	}
+
+	status := deviceDenied
+	if approved {
+		status = deviceApproved
+	}
+
+	// Only the first decision is recorded, should the user submit the page twice at once
+	decided, err := store.SetNX(deviceDecisionSet, deviceCode, []byte(status), deviceGrantTTL(grant))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if !decided {
+		return ErrInvalidUserCode
+	}
+
+	err = store.Delete(deviceUserCodeSet, userCode)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+// NewDeviceToken issues an access token to a device polling with its device code.
+// Until the user approves the request, ErrAuthorizationPending is returned, or ErrSlowDown
+// if the device polls before the interval has passed. ErrAccessDenied is returned if the user
+// denied the request and ErrExpiredToken once the device code expires.
+// The device code cannot be used again once a token is issued or the request was denied,
+// and is rejected with ErrInvalidDeviceCode if it was issued to another client.
+// The token is issued with the access token lifetime in lifetimes.
+// Refer RFC 8628 Section 3.5 (https://tools.ietf.org/html/rfc8628#section-3.5)
+func NewDeviceToken(clientID, deviceCode string, lifetimes config.Lifetimes) (*DeviceToken, error) {
+	grant, err := lookupDeviceGrant(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if grant.ClientID != clientID {
+		return nil, ErrInvalidDeviceCode
+	}
+
+	now := time.Now()
+	if now.Sub(grant.CreationTime) >= deviceCodeLifetime {
+		invalidateDeviceGrant(deviceCode, grant)
+		return nil, ErrExpiredToken
+	}
+
+	switch lookupDeviceStatus(deviceCode) {
+	case deviceDenied:
+		invalidateDeviceGrant(deviceCode, grant)
+		return nil, ErrAccessDenied
+	case devicePending:
+		return nil, pollDeviceGrant(deviceCode, grant, now)
+	}
+
+	// The device code is claimed before the token is issued, so that
+	// only one of several overlapping polls is issued a token for it
+	claimed, err := store.SetNX(deviceRedeemedSet, deviceCode, []byte(clientID), deviceGrantTTL(grant))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if !claimed {
+		return nil, ErrInvalidDeviceCode
+	}
+
+	invalidateDeviceGrant(deviceCode, grant)
+	return issueDeviceToken(grant.ClientID, grant.Scope, lifetimes.AccessToken)
+}
+
+// Records a poll of a pending request and returns ErrSlowDown if the device
+// polled before the interval had passed, or ErrAuthorizationPending otherwise.
+// Only the polling state is written, hence a concurrent decision of the user is never lost.
+func pollDeviceGrant(deviceCode string, grant *deviceGrantMeta, now time.Time) error {
+	poll := devicePollState{Interval: devicePollingInterval}
+	jsonBytes, err := store.Get(devicePollSet, deviceCode)
+	if err == nil {
+		err = json.Unmarshal(jsonBytes, &poll)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	status := ErrAuthorizationPending
+	if now.Sub(poll.LastPoll) < time.Duration(poll.Interval)*time.Second {
+		poll.Interval += devicePollingInterval
+		status = ErrSlowDown
+	}
+
+	poll.LastPoll = now
+	jsonBytes, err = json.Marshal(poll)
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(devicePollSet, deviceCode, jsonBytes, deviceGrantTTL(grant))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return status
+}
+
+// Generates a new access token with the given scope and lifetime for the client and stores it in the store
+func issueDeviceToken(clientID, scope string, expiresIn int) (*DeviceToken, error) {
+	var token *DeviceToken
+	var meta *deviceTokenMeta
+	var err error
+	exists := true
+
+	// Generates a new key if a duplicate is encountered
+	for exists {
+		token, meta = generateDeviceToken(clientID, scope, expiresIn)
+
+		exists, err = store.Exists(deviceTokensSet, token.AccessToken)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	jsonBytes, err := json.Marshal(internalDeviceToken{Token: *token, Meta: *meta})
+	if err != nil {
+		panic(err)
+	}
+
+	// The token is removed from the store once it expires
+	err = store.Set(deviceTokensSet, token.AccessToken, jsonBytes, accessTokenTTL(token.ExpiresIn))
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Fetches the pending device authorization request for the user code
+func findDeviceGrant(userCode string) (string, *deviceGrantMeta, error) {
+	deviceCode, err := store.Get(deviceUserCodeSet, userCode)
+	if err != nil {
+		return "", nil, ErrInvalidUserCode
+	}
+
+	grant, err := lookupDeviceGrant(string(deviceCode))
+	if err != nil || lookupDeviceStatus(string(deviceCode)) != devicePending ||
+		time.Now().Sub(grant.CreationTime) >= deviceCodeLifetime {
+		return "", nil, ErrInvalidUserCode
+	}
+
+	return string(deviceCode), grant, nil
+}
+
+// Fetches the device authorization request from the store.
+// Returns ErrInvalidDeviceCode if not found.
+func lookupDeviceGrant(deviceCode string) (*deviceGrantMeta, error) {
+	jsonBytes, err := store.Get(deviceGrantSet, deviceCode)
+	if err != nil {
+		return nil, ErrInvalidDeviceCode
+	}
+
+	var grant deviceGrantMeta
+	err = json.Unmarshal(jsonBytes, &grant)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInvalidDeviceCode
+	}
+
+	return &grant, nil
+}
+
+// Returns the status of the device authorization request, which is pending until the user decides on it
+func lookupDeviceStatus(deviceCode string) string {
+	status, err := store.Get(deviceDecisionSet, deviceCode)
+	if err != nil {
+		return devicePending
+	}
+
+	return string(status)
+}
+
+// Stores the device authorization request until its device code expires
+func storeDeviceGrant(deviceCode string, grant deviceGrantMeta) error {
+	jsonBytes, err := json.Marshal(grant)
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(deviceGrantSet, deviceCode, jsonBytes, deviceGrantTTL(&grant))
+	if err != nil {
+		log.Println(err)
+	}
+
+	return err
+}
+
+// Returns the time left until the device code of the request expires
+func deviceGrantTTL(grant *deviceGrantMeta) time.Duration {
+	return ttlUntil(grant.CreationTime.Add(deviceCodeLifetime))
+}
+
+// Removes the device authorization request along with its user code, decision and polling state.
+// The mark of a redeemed request is left to expire, so that the device code cannot be redeemed again.
+func invalidateDeviceGrant(deviceCode string, grant *deviceGrantMeta) {
+	err := store.Delete(deviceGrantSet, deviceCode)
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, hash := range []string{deviceDecisionSet, devicePollSet} {
+		err = store.Delete(hash, deviceCode)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	err = store.Delete(deviceUserCodeSet, grant.UserCode)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Fetches the access token along with its metadata from the store.
+// Returns nil if not found.
+func lookupDeviceToken(accessToken string) *internalDeviceToken {
+	jsonBytes, err := store.Get(deviceTokensSet, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	var token internalDeviceToken
+	err = json.Unmarshal(jsonBytes, &token)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &token
+}
+
+func invalidateDeviceToken(accessToken string) {
+	err := store.Delete(deviceTokensSet, accessToken)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Generates a user code of the form XXXX-XXXX.
+// It is drawn from a cryptographically secure source since it is all it takes to approve the grant.
+func generateUserCode() string {
+	userCode := generateSecretFrom(userCodeChars, 8)
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// Returns the user code in the form it was issued in.
+// Refer RFC 8628 Section 6.1 (https://tools.ietf.org/html/rfc8628#section-6.1)
+func normalizeUserCode(userCode string) string {
+	userCode = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+
+	if len(userCode) != 8 {
+		return userCode
+	}
+
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// Generates an access token.
+// Access token is a hex-encoded string of the SHA-256 hash of the
+// concatenation of the time of creation and a nonce.
+func generateDeviceToken(clientID, scope string, expiresIn int) (*DeviceToken, *deviceTokenMeta) {
+	nonce := generateNonce(16)
+	creationTime := time.Now()
+
+	accessToken := DeviceFlowID + hash(fmt.Sprintf("%s%s", creationTime, nonce))
+
+	return &DeviceToken{
+		AccessToken: accessToken,
+		ExpiresIn:   expiresIn,
+		Scope:       scope,
+	}, &deviceTokenMeta{
+		ClientID:     clientID,
+		CreationTime: creationTime,
+		Nonce:        nonce,
+	}
+}
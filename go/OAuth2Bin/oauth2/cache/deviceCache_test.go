@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	authorization, err := NewDeviceAuthorization("clientID", "read")
+	if err != nil {
+		t.Fatalf("Could not generate device code:\n%s\n", err)
+	}
+
+	if len(authorization.UserCode) != 9 || authorization.Interval != devicePollingInterval {
+		t.Fatalf("Unexpected device authorization: %+v", authorization)
+	}
+
+	// The user has not yet approved the request
+	_, err = NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != ErrAuthorizationPending {
+		t.Fatalf("Expected authorization_pending, got %v", err)
+	}
+
+	// Polling again without waiting for the interval
+	_, err = NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != ErrSlowDown {
+		t.Fatalf("Expected slow_down, got %v", err)
+	}
+
+	// The user code is accepted in lower case and without the dash
+	userCode := strings.ToLower(strings.Replace(authorization.UserCode, "-", "", 1))
+	scope, err := LookupDeviceUserCode(userCode)
+	if err != nil || scope != "read" {
+		t.Fatalf("User code lookup failed: %v", err)
+	}
+
+	err = ResolveDeviceAuthorization(userCode, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The user code cannot be used again
+	if ResolveDeviceAuthorization(userCode, false) != ErrInvalidUserCode {
+		t.Fatal("User code reused")
+	}
+
+	// The device code is bound to the client it was issued to
+	_, err = NewDeviceToken("otherClient", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != ErrInvalidDeviceCode {
+		t.Fatalf("Device code accepted from another client: %v", err)
+	}
+
+	token, err := NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatalf("Could not generate token:\n%s\n", err)
+	}
+
+	if !strings.HasPrefix(token.AccessToken, DeviceFlowID) || token.Scope != "read" {
+		t.Fatalf("Unexpected token: %+v", token)
+	}
+
+	// The device code cannot be used again
+	_, err = NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != ErrInvalidDeviceCode {
+		t.Fatalf("Device code reused: %v", err)
+	}
+
+	if !IntrospectToken(token.AccessToken, "").Active {
+		t.Fatal("Device token not active on introspection")
+	}
+
+	invalidateDeviceToken(token.AccessToken)
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	authorization, err := NewDeviceAuthorization("clientID", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ResolveDeviceAuthorization(authorization.UserCode, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+	if err != ErrAccessDenied {
+		t.Fatalf("Expected access_denied, got %v", err)
+	}
+}
+
+func TestDeviceFlowConcurrentPolls(t *testing.T) {
+	authorization, err := NewDeviceAuthorization("clientID", "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A poll which found the request pending before the user approved it must not undo the approval
+	grant, err := lookupDeviceGrant(authorization.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ResolveDeviceAuthorization(authorization.UserCode, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pollDeviceGrant(authorization.DeviceCode, grant, time.Now())
+	if lookupDeviceStatus(authorization.DeviceCode) != deviceApproved {
+		t.Fatal("Approval overwritten by a poll")
+	}
+
+	// Only one of several overlapping polls is issued a token
+	var wg sync.WaitGroup
+	tokens := make(chan *DeviceToken, 10)
+	for i := 0; i < cap(tokens); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := NewDeviceToken("clientID", authorization.DeviceCode, config.DefaultLifetimes)
+			if err == nil {
+				tokens <- token
+			} else if err != ErrInvalidDeviceCode {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	if len(tokens) != 1 {
+		t.Fatalf("Device code redeemed %d times", len(tokens))
+	}
+
+	invalidateDeviceToken((<-tokens).AccessToken)
+}
@@ -6,11 +6,11 @@ import (
 	"log"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"oauth2bin/oauth2/config"
 )
 
 const (
-	// Redis HSET which holds the issued tokens
+	// Hash which holds the issued tokens
 	implicitTokensSet = "OA2_IG_Tokens"
 
 	// ImplicitFlowID is prepended to access tokens issued by the Implicit Grant flow
@@ -22,38 +22,37 @@ const (
 type ImplicitToken struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
 }
 
 // Holds the meta data of an access token
 type implicitTokenMeta struct {
+	ClientID     string    `json:"client_id"`
 	CreationTime time.Time `json:"creation_time"`
 	Nonce        string    `json:"nonce"`
 }
 
 // Holds the tokens as well as its metadata.
-// It is the internal representation of the token inside the Redis cache.
+// It is the internal representation of the token inside the store.
 type internalImplicitToken struct {
 	Token ImplicitToken     `json:"token"`
 	Meta  implicitTokenMeta `json:"meta"`
 }
 
 // NewImplicitToken issues new access tokens for the Implicit Grant flow.
-// It generates and stores a token and stores it along with its meta data
-// in the Redis cache.
-func NewImplicitToken() (*ImplicitToken, error) {
-	conn := NewConn()
-	defer CloseConn(conn)
-
+// It generates a token with the given scope and the access token lifetime for the client
+// and stores it along with its meta data in the store.
+func NewImplicitToken(clientID, scope string, lifetimes config.Lifetimes) (*ImplicitToken, error) {
 	var token *ImplicitToken
 	var meta *implicitTokenMeta
 	var err error
-	reply := 1
+	exists := true
 
 	// Generates a new key if a duplicate is encountered
-	for reply == 1 {
-		token, meta = generateImplicitToken()
+	for exists {
+		token, meta = generateImplicitToken(clientID, scope, lifetimes.AccessToken)
 
-		reply, err = redis.Int(conn.Do("HEXISTS", implicitTokensSet, token.AccessToken))
+		exists, err = store.Exists(implicitTokensSet, token.AccessToken)
 		if err != nil {
 			log.Println(err)
 			return nil, err
@@ -65,7 +64,8 @@ func NewImplicitToken() (*ImplicitToken, error) {
 		panic(err)
 	}
 
-	_, err = conn.Do("HSET", implicitTokensSet, token.AccessToken, string(jsonBytes))
+	// The token is removed from the store once it expires
+	err = store.Set(implicitTokensSet, token.AccessToken, jsonBytes, accessTokenTTL(token.ExpiresIn))
 	if err != nil {
 		return nil, err
 	}
@@ -73,20 +73,33 @@ func NewImplicitToken() (*ImplicitToken, error) {
 	return token, nil
 }
 
-// VerifyImplicitToken checks if the token exists in the Redis cache.
+// VerifyImplicitToken checks if the token exists in the store.
 // Returns true if token found, false otherwise.
 func VerifyImplicitToken(token string) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
+	exists, err := store.Exists(implicitTokensSet, token)
+	return err == nil && exists
+}
 
-	_, err := redis.String(conn.Do("HGET", implicitTokensSet, token))
-	return err == nil
+// Fetches the access token along with its metadata from the store.
+// Returns nil if not found.
+func lookupImplicitToken(accessToken string) *internalImplicitToken {
+	jsonBytes, err := store.Get(implicitTokensSet, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	var token internalImplicitToken
+	err = json.Unmarshal(jsonBytes, &token)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &token
 }
 
 func invalidateImplicitToken(accessToken string) {
-	conn := NewConn()
-	defer CloseConn(conn)
-	_, err := conn.Do("HDEL", implicitTokensSet, accessToken)
+	err := store.Delete(implicitTokensSet, accessToken)
 	if err != nil {
 		log.Println(err)
 	}
@@ -95,7 +108,7 @@ func invalidateImplicitToken(accessToken string) {
 // Generates an access token.
 // Access token is a hex-encoded string of the SHA-256 hash of the
 // concatenation of the time of creation and a nonce.
-func generateImplicitToken() (*ImplicitToken, *implicitTokenMeta) {
+func generateImplicitToken(clientID, scope string, expiresIn int) (*ImplicitToken, *implicitTokenMeta) {
 	nonce := generateNonce(16)
 	creationTime := time.Now()
 
@@ -103,38 +116,11 @@ func generateImplicitToken() (*ImplicitToken, *implicitTokenMeta) {
 
 	return &ImplicitToken{
 			AccessToken: accessToken,
-			ExpiresIn:   3600,
+			ExpiresIn:   expiresIn,
+			Scope:       scope,
 		}, &implicitTokenMeta{
+			ClientID:     clientID,
 			CreationTime: creationTime,
 			Nonce:        nonce,
 		}
 }
-
-// Housekeeping service for the Implicit tokens set
-func implicitTokenHousekeep(conn redis.Conn) {
-	var token internalImplicitToken
-	var err error
-	var diff time.Duration
-
-	items, err := redis.ByteSlices(conn.Do("HGETALL", implicitTokensSet))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	for i := 1; i < len(items); i += 2 {
-		err = json.Unmarshal(items[i], &token)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-
-		diff = time.Now().Sub(token.Meta.CreationTime)
-		if diff >= time.Hour {
-			_, err = conn.Do("HDEL", implicitTokensSet, items[i-1])
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-}
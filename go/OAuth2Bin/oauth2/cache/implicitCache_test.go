@@ -1,20 +1,24 @@
 package cache
 
-import "testing"
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
 
 // TestImplicitFlow tests the entirely of the functions set of implicitStore
 // as they would be used by the Authorization Code Grant flow
 func TestImplicitFlow(t *testing.T) {
 	// Generating a token which would be done once the user authorizes
 	// the client application
-	token, err := NewImplicitToken()
+	token, err := NewImplicitToken("clientID", "", config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token:\n%s\n", err)
 	}
 
 	t.Logf("Token generated: %s\n", token.AccessToken)
 
-	// Check if token exists in the Redis cache
+	// Check if token exists in the store
 	res := VerifyImplicitToken(token.AccessToken)
 	if !res {
 		t.Fatalf("Implicit token verification failed\n")
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"time"
+
+	"oauth2bin/oauth2/metrics"
+)
+
+// instrumentedStore is a Store which measures the latency and counts the errors of
+// the operations of the Store it wraps. See metrics.StoreDuration and metrics.StoreErrors.
+type instrumentedStore struct {
+	Store
+}
+
+// Returns the store wrapped so that its operations are measured
+func instrumentStore(s Store) Store {
+	return instrumentedStore{Store: s}
+}
+
+// Returns the store wrapped by an instrumentedStore, if any, e.g. to check which interfaces it implements
+func baseStore(s Store) Store {
+	if instrumented, ok := s.(instrumentedStore); ok {
+		return instrumented.Store
+	}
+
+	return s
+}
+
+// Records the latency of the operation which started at start and whether it failed.
+// Returns the error of the operation.
+func observeStoreOperation(operation string, start time.Time, err error) error {
+	metrics.StoreDuration.ObserveSince(start, operation)
+	if err != nil && err != ErrNotFound {
+		metrics.StoreErrors.Inc(operation)
+	}
+
+	return err
+}
+
+// Get implements Store
+func (s instrumentedStore) Get(hash, field string) ([]byte, error) {
+	start := time.Now()
+	value, err := s.Store.Get(hash, field)
+	return value, observeStoreOperation("get", start, err)
+}
+
+// Set implements Store
+func (s instrumentedStore) Set(hash, field string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := s.Store.Set(hash, field, value, ttl)
+	return observeStoreOperation("set", start, err)
+}
+
+// SetNX implements Store
+func (s instrumentedStore) SetNX(hash, field string, value []byte, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	added, err := s.Store.SetNX(hash, field, value, ttl)
+	return added, observeStoreOperation("set_nx", start, err)
+}
+
+// Exists implements Store
+func (s instrumentedStore) Exists(hash, field string) (bool, error) {
+	start := time.Now()
+	exists, err := s.Store.Exists(hash, field)
+	return exists, observeStoreOperation("exists", start, err)
+}
+
+// Delete implements Store
+func (s instrumentedStore) Delete(hash, field string) error {
+	start := time.Now()
+	err := s.Store.Delete(hash, field)
+	return observeStoreOperation("delete", start, err)
+}
+
+// GetAll implements Store
+func (s instrumentedStore) GetAll(hash string) (map[string][]byte, error) {
+	start := time.Now()
+	values, err := s.Store.GetAll(hash)
+	return values, observeStoreOperation("get_all", start, err)
+}
+
+// FixedWindow implements Store
+func (s instrumentedStore) FixedWindow(key string, limit int, window time.Duration) (RateResult, error) {
+	start := time.Now()
+	result, err := s.Store.FixedWindow(key, limit, window)
+	return result, observeStoreOperation("fixed_window", start, err)
+}
+
+// SlidingLog implements Store
+func (s instrumentedStore) SlidingLog(key string, limit int, window time.Duration) (RateResult, error) {
+	start := time.Now()
+	result, err := s.Store.SlidingLog(key, limit, window)
+	return result, observeStoreOperation("sliding_log", start, err)
+}
+
+// TokenBucket implements Store
+func (s instrumentedStore) TokenBucket(key string, limit int, window time.Duration) (RateResult, error) {
+	start := time.Now()
+	result, err := s.Store.TokenBucket(key, limit, window)
+	return result, observeStoreOperation("token_bucket", start, err)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oauth2bin/oauth2/metrics"
+)
+
+// A MemoryStore which cannot set fields
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) Set(hash, field string, value []byte, ttl time.Duration) error {
+	return errors.New("read-only")
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	memory := NewMemoryStore()
+	defer memory.Close()
+	s := instrumentStore(failingStore{MemoryStore: memory})
+
+	gets, setErrors := metrics.StoreDuration.Count("get"), metrics.StoreErrors.Value("set")
+	getErrors := metrics.StoreErrors.Value("get")
+
+	// Missing fields are not errors
+	if _, err := s.Get("hash", "missing"); err != ErrNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if s.Set("hash", "field", []byte("value"), 0) == nil {
+		t.Fatal("Error of the wrapped store not returned")
+	}
+
+	if metrics.StoreDuration.Count("get") != gets+1 || metrics.StoreErrors.Value("get") != getErrors {
+		t.Fatal("Get not measured")
+	}
+
+	if metrics.StoreErrors.Value("set") != setErrors+1 {
+		t.Fatal("Failed Set not counted")
+	}
+
+	if _, ok := baseStore(s).(failingStore); !ok {
+		t.Fatal("Wrapped store not returned")
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// Flow names reported in TokenInfo, same as the grant_type used to obtain the token
+const (
+	AuthCodeFlowName    = "authorization_code"
+	ImplicitFlowName    = "implicit"
+	ROPCFlowName        = "password"
+	ClientCredsFlowName = "client_credentials"
+	DeviceFlowName      = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Token type hints as defined in RFC 7009 Section 2.1 (https://tools.ietf.org/html/rfc7009#section-2.1)
+const (
+	AccessTokenHint  = "access_token"
+	RefreshTokenHint = "refresh_token"
+)
+
+// Lifetime of the refresh tokens issued before their lifetime was configurable
+const refreshTokenLifetime = time.Hour
+
+// TokenInfo describes an issued token.
+// Refer RFC 7662 Section 2.2 (https://tools.ietf.org/html/rfc7662#section-2.2)
+//
+// TokenType is "bearer" for access tokens and "refresh_token" for refresh tokens.
+// Flow is the grant type through which the token was obtained.
+type TokenInfo struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Flow      string `json:"flow,omitempty"`
+}
+
+// IntrospectToken looks up an access or refresh token issued by any of the flows
+// and returns its information. The flow is identified by the token's prefix.
+// hint is the token_type_hint sent by the client; refresh tokens are looked up
+// first if it is "refresh_token".
+// An inactive TokenInfo is returned if the token is unknown or has expired.
+func IntrospectToken(token, hint string) TokenInfo {
+	var lookups []func(string) *TokenInfo
+
+	switch {
+	case strings.HasPrefix(token, AuthCodeFlowID):
+		lookups = []func(string) *TokenInfo{introspectAuthCodeToken, introspectAuthCodeRefreshToken}
+	case strings.HasPrefix(token, ROPCFlowID):
+		lookups = []func(string) *TokenInfo{introspectROPCToken, introspectROPCRefreshToken}
+	case strings.HasPrefix(token, ImplicitFlowID):
+		lookups = []func(string) *TokenInfo{introspectImplicitToken}
+	case strings.HasPrefix(token, ClientCredsFlowID):
+		lookups = []func(string) *TokenInfo{introspectClientCredsToken}
+	case strings.HasPrefix(token, DeviceFlowID):
+		lookups = []func(string) *TokenInfo{introspectDeviceToken}
+	}
+
+	if hint == RefreshTokenHint && len(lookups) == 2 {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		if info := lookup(token); info != nil {
+			if !info.Active {
+				return TokenInfo{}
+			}
+
+			return *info
+		}
+	}
+
+	return TokenInfo{}
+}
+
+// VerifyAccessToken checks if the access token issued by any of the flows is active.
+// The information of the token is returned along with the result, for checking its scope.
+// Refresh tokens are never accepted in place of access tokens.
+func VerifyAccessToken(token string) (TokenInfo, bool) {
+	info := IntrospectToken(token, AccessTokenHint)
+	if !info.Active || info.TokenType != "bearer" {
+		return TokenInfo{}, false
+	}
+
+	return info, true
+}
+
+// Builds the TokenInfo for a token created at 'creationTime' which is valid for 'lifetime'
+func newTokenInfo(flow, tokenType, clientID, scope string, creationTime time.Time, lifetime time.Duration) *TokenInfo {
+	exp := creationTime.Add(lifetime)
+	return &TokenInfo{
+		Active:    time.Now().Before(exp),
+		Scope:     scope,
+		ClientID:  clientID,
+		TokenType: tokenType,
+		Exp:       exp.Unix(),
+		Iat:       creationTime.Unix(),
+		Flow:      flow,
+	}
+}
+
+func introspectAuthCodeToken(accessToken string) *TokenInfo {
+	token := lookupAuthCodeToken(accessToken)
+	if token == nil {
+		return nil
+	}
+
+	lifetime := time.Duration(token.Token.ExpiresIn) * time.Second
+	return newTokenInfo(AuthCodeFlowName, "bearer", token.Meta.ClientID, token.Token.Scope, token.Meta.CreationTime, lifetime)
+}
+
+func introspectAuthCodeRefreshToken(refreshToken string) *TokenInfo {
+	token := findAuthCodeToken(refreshToken)
+	if token == nil {
+		return nil
+	}
+
+	return newTokenInfo(AuthCodeFlowName, RefreshTokenHint, token.Meta.ClientID, token.Meta.GrantedScope, token.Meta.CreationTime, token.Meta.refreshTokenExpiry().Sub(token.Meta.CreationTime))
+}
+
+func introspectImplicitToken(accessToken string) *TokenInfo {
+	token := lookupImplicitToken(accessToken)
+	if token == nil {
+		return nil
+	}
+
+	lifetime := time.Duration(token.Token.ExpiresIn) * time.Second
+	return newTokenInfo(ImplicitFlowName, "bearer", token.Meta.ClientID, token.Token.Scope, token.Meta.CreationTime, lifetime)
+}
+
+func introspectROPCToken(accessToken string) *TokenInfo {
+	token := lookupROPCToken(accessToken)
+	if token == nil {
+		return nil
+	}
+
+	lifetime := time.Duration(token.Token.ExpiresIn) * time.Second
+	return newTokenInfo(ROPCFlowName, "bearer", token.Meta.ClientID, token.Token.Scope, token.Meta.CreationTime, lifetime)
+}
+
+func introspectROPCRefreshToken(refreshToken string) *TokenInfo {
+	token := findROPCToken(refreshToken)
+	if token == nil {
+		return nil
+	}
+
+	return newTokenInfo(ROPCFlowName, RefreshTokenHint, token.Meta.ClientID, token.Meta.GrantedScope, token.Meta.CreationTime, token.Meta.refreshTokenExpiry().Sub(token.Meta.CreationTime))
+}
+
+func introspectClientCredsToken(accessToken string) *TokenInfo {
+	token := lookupClientCredsToken(accessToken)
+	if token == nil {
+		return nil
+	}
+
+	lifetime := time.Duration(token.Token.ExpiresIn) * time.Second
+	return newTokenInfo(ClientCredsFlowName, "bearer", token.Meta.ClientID, token.Token.Scope, token.Meta.CreationTime, lifetime)
+}
+
+func introspectDeviceToken(accessToken string) *TokenInfo {
+	token := lookupDeviceToken(accessToken)
+	if token == nil {
+		return nil
+	}
+
+	lifetime := time.Duration(token.Token.ExpiresIn) * time.Second
+	return newTokenInfo(DeviceFlowName, "bearer", token.Meta.ClientID, token.Token.Scope, token.Meta.CreationTime, lifetime)
+}
@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	token, err := NewROPCToken("clientID", "", "read write", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := IntrospectToken(token.AccessToken, "")
+	if !info.Active || info.TokenType != "bearer" || info.Flow != ROPCFlowName || info.Scope != "read write" || info.ClientID != "clientID" {
+		t.Fatalf("Unexpected info for access token: %+v", info)
+	}
+
+	if info.Exp-info.Iat != int64(token.ExpiresIn) {
+		t.Fatalf("Expected exp - iat to be %d, got %d", token.ExpiresIn, info.Exp-info.Iat)
+	}
+
+	info = IntrospectToken(token.RefreshToken, RefreshTokenHint)
+	if !info.Active || info.TokenType != RefreshTokenHint {
+		t.Fatalf("Unexpected info for refresh token: %+v", info)
+	}
+
+	invalidateROPCToken(token.AccessToken)
+
+	info = IntrospectToken(token.AccessToken, AccessTokenHint)
+	if info.Active {
+		t.Fatal("Invalidated token reported as active")
+	}
+
+	info = IntrospectToken("not-a-token", "")
+	if info.Active {
+		t.Fatal("Unknown token reported as active")
+	}
+}
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"oauth2bin/oauth2/metrics"
+)
+
+// How often the expired fields and rate limits are removed from a MemoryStore
+const memorySweepInterval = time.Minute
+
+// MemoryStore is a Store which keeps everything in the memory of the process.
+// Nothing survives a restart, which makes it suitable for tests and local demos.
+type MemoryStore struct {
+	mut        sync.Mutex
+	hashes     map[string]map[string]memoryEntry
+	rateLimits map[string]memoryRateLimit
+	done       chan struct{}
+	stop       sync.Once
+}
+
+// Value of a field along with the time it expires at, zero if it never expires
+type memoryEntry struct {
+	value  []byte
+	expiry time.Time
+}
+
+// Returns true if the entry has expired at the time
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
+}
+
+// State of a rate limit, which is forgotten once it expires.
+// Only the fields of the algorithm the rate limit is kept for are used:
+// hits for a fixed window, log for a sliding log, tokens and updated for a token bucket.
+type memoryRateLimit struct {
+	hits    int
+	log     []time.Time
+	tokens  float64
+	updated time.Time
+	expiry  time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store.
+// A background goroutine removes the expired fields and rate limits until the store is closed.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		hashes:     make(map[string]map[string]memoryEntry),
+		rateLimits: make(map[string]memoryRateLimit),
+		done:       make(chan struct{}),
+	}
+
+	go s.sweep()
+	return s
+}
+
+// Removes the expired fields and rate limits every memorySweepInterval.
+// Expired fields are never returned, so this only reclaims their memory.
+func (s *MemoryStore) sweep() {
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			start := time.Now()
+			fields, rateLimits := s.removeExpired(now)
+
+			metrics.HousekeepingDuration.ObserveSince(start)
+			metrics.HousekeepingRemoved.Add(float64(fields), "field")
+			metrics.HousekeepingRemoved.Add(float64(rateLimits), "rate_limit")
+		}
+	}
+}
+
+// Removes the fields and rate limits which have expired at the time.
+// Returns the number of fields and rate limits removed.
+func (s *MemoryStore) removeExpired(now time.Time) (fields int, rateLimits int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for hash, hashFields := range s.hashes {
+		for field, entry := range hashFields {
+			if entry.expired(now) {
+				delete(hashFields, field)
+				fields++
+			}
+		}
+
+		if len(hashFields) == 0 {
+			delete(s.hashes, hash)
+		}
+	}
+
+	for key, rateLimit := range s.rateLimits {
+		if !now.Before(rateLimit.expiry) {
+			delete(s.rateLimits, key)
+			rateLimits++
+		}
+	}
+
+	return fields, rateLimits
+}
+
+// Returns a copy of the value so that it cannot be modified outside the store
+func copyValue(value []byte) []byte {
+	return append([]byte(nil), value...)
+}
+
+// Returns the unexpired entry of the field in the hash
+func (s *MemoryStore) entry(hash, field string) (memoryEntry, bool) {
+	entry, found := s.hashes[hash][field]
+	if !found || entry.expired(time.Now()) {
+		return memoryEntry{}, false
+	}
+
+	return entry, true
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(hash, field string) ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	entry, found := s.entry(hash, field)
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return copyValue(entry.value), nil
+}
+
+// Set implements Store
+func (s *MemoryStore) Set(hash, field string, value []byte, ttl time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.set(hash, field, value, ttl)
+	return nil
+}
+
+func (s *MemoryStore) set(hash, field string, value []byte, ttl time.Duration) {
+	fields, found := s.hashes[hash]
+	if !found {
+		fields = make(map[string]memoryEntry)
+		s.hashes[hash] = fields
+	}
+
+	entry := memoryEntry{value: copyValue(value)}
+	if ttl > 0 {
+		entry.expiry = time.Now().Add(ttl)
+	}
+
+	fields[field] = entry
+}
+
+// SetNX implements Store
+func (s *MemoryStore) SetNX(hash, field string, value []byte, ttl time.Duration) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, found := s.entry(hash, field); found {
+		return false, nil
+	}
+
+	s.set(hash, field, value, ttl)
+	return true, nil
+}
+
+// Exists implements Store
+func (s *MemoryStore) Exists(hash, field string) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, found := s.entry(hash, field)
+	return found, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(hash, field string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.hashes[hash], field)
+	return nil
+}
+
+// GetAll implements Store
+func (s *MemoryStore) GetAll(hash string) (map[string][]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := time.Now()
+	values := make(map[string][]byte, len(s.hashes[hash]))
+	for field, entry := range s.hashes[hash] {
+		if !entry.expired(now) {
+			values[field] = copyValue(entry.value)
+		}
+	}
+
+	return values, nil
+}
+
+// Returns the unexpired state of the rate limit at key, which is empty if there is none
+func (s *MemoryStore) rateLimit(key string, now time.Time) memoryRateLimit {
+	rateLimit, found := s.rateLimits[key]
+	if !found || !now.Before(rateLimit.expiry) {
+		return memoryRateLimit{}
+	}
+
+	return rateLimit
+}
+
+// FixedWindow implements Store
+func (s *MemoryStore) FixedWindow(key string, limit int, window time.Duration) (RateResult, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := time.Now()
+	rateLimit := s.rateLimit(key, now)
+	if rateLimit.hits == 0 {
+		rateLimit.expiry = now.Add(window)
+	}
+
+	rateLimit.hits++
+	s.rateLimits[key] = rateLimit
+	return fixedWindowResult(rateLimit.hits, limit, rateLimit.expiry.Sub(now)), nil
+}
+
+// SlidingLog implements Store
+func (s *MemoryStore) SlidingLog(key string, limit int, window time.Duration) (RateResult, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := time.Now()
+	rateLimit := s.rateLimit(key, now)
+
+	// Drops the requests which have slid out of the window
+	log := rateLimit.log[:0]
+	for _, hit := range rateLimit.log {
+		if now.Sub(hit) < window {
+			log = append(log, hit)
+		}
+	}
+
+	allowed := len(log) < limit
+	if allowed {
+		log = append(log, now)
+	}
+
+	rateLimit.log = log
+	if len(log) == 0 {
+		delete(s.rateLimits, key)
+		return slidingLogResult(allowed, 0, limit, now, now.Add(-window), now, window), nil
+	}
+
+	oldest, newest := log[0], log[len(log)-1]
+	rateLimit.expiry = newest.Add(window)
+	s.rateLimits[key] = rateLimit
+	return slidingLogResult(allowed, len(log), limit, oldest, newest, now, window), nil
+}
+
+// TokenBucket implements Store
+func (s *MemoryStore) TokenBucket(key string, limit int, window time.Duration) (RateResult, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := time.Now()
+	rateLimit, found := s.rateLimits[key]
+	if !found || !now.Before(rateLimit.expiry) {
+		// A bucket is forgotten once it is full again
+		rateLimit = memoryRateLimit{tokens: float64(limit), updated: now}
+	}
+
+	tokens, result := takeBucketToken(rateLimit.tokens, rateLimit.updated, now, limit, window)
+	s.rateLimits[key] = memoryRateLimit{tokens: tokens, updated: now, expiry: now.Add(result.Reset)}
+	return result, nil
+}
+
+// Close implements Store by stopping the removal of expired fields and rate limits
+func (s *MemoryStore) Close() error {
+	s.stop.Do(func() { close(s.done) })
+	return nil
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	_, err := s.Get("hash", "field")
+	if err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	added, _ := s.SetNX("hash", "field", []byte("value"), 0)
+	if !added {
+		t.Fatal("Field not added")
+	}
+
+	added, _ = s.SetNX("hash", "field", []byte("other"), 0)
+	if added {
+		t.Fatal("Existing field overwritten by SetNX")
+	}
+
+	value, err := s.Get("hash", "field")
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Unexpected value: %s", value)
+	}
+
+	s.Set("hash", "other", []byte("other"), 0)
+	all, _ := s.GetAll("hash")
+	if len(all) != 2 || string(all["other"]) != "other" {
+		t.Fatalf("Unexpected fields: %v", all)
+	}
+
+	s.Delete("hash", "field")
+	if exists, _ := s.Exists("hash", "field"); exists {
+		t.Fatal("Field not deleted")
+	}
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	s.Set("hash", "expiring", []byte("value"), 50*time.Millisecond)
+	s.Set("hash", "permanent", []byte("value"), 0)
+
+	if exists, _ := s.Exists("hash", "expiring"); !exists {
+		t.Fatal("Field expired before its TTL")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := s.Get("hash", "expiring"); err != ErrNotFound {
+		t.Fatalf("Field did not expire: %v", err)
+	}
+
+	all, _ := s.GetAll("hash")
+	if len(all) != 1 || all["permanent"] == nil {
+		t.Fatalf("Unexpected fields: %v", all)
+	}
+
+	// An expired field may be set again
+	added, _ := s.SetNX("hash", "expiring", []byte("other"), 0)
+	if !added {
+		t.Fatal("Expired field not replaced by SetNX")
+	}
+}
+
+func TestMemoryStoreRemoveExpired(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	s.Set("hash", "expiring", []byte("value"), time.Minute)
+	s.Set("other", "expiring", []byte("value"), time.Minute)
+	s.Set("hash", "permanent", []byte("value"), 0)
+	s.FixedWindow("key", 1, time.Minute)
+
+	fields, rateLimits := s.removeExpired(time.Now())
+	if fields != 0 || rateLimits != 0 {
+		t.Fatalf("Unexpired entries removed: %d fields, %d rate limits", fields, rateLimits)
+	}
+
+	fields, rateLimits = s.removeExpired(time.Now().Add(2 * time.Minute))
+	if fields != 2 || rateLimits != 1 || len(s.hashes) != 1 {
+		t.Fatalf("Expired entries not removed: %d fields, %d rate limits", fields, rateLimits)
+	}
+
+	if exists, _ := s.Exists("hash", "permanent"); !exists {
+		t.Fatal("Permanent field removed")
+	}
+}
+
+func TestMemoryStoreFixedWindow(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	expected := []RateResult{
+		{Allowed: true, Remaining: 1},
+		{Allowed: true, Remaining: 0},
+		{Allowed: false, Remaining: 0},
+	}
+
+	for i, want := range expected {
+		result, _ := s.FixedWindow("key", 2, 50*time.Millisecond)
+		if result.Allowed != want.Allowed || result.Remaining != want.Remaining {
+			t.Fatalf("Request %d: unexpected result %+v", i+1, result)
+		}
+	}
+
+	// The window starts over once it expires
+	time.Sleep(60 * time.Millisecond)
+	result, _ := s.FixedWindow("key", 2, time.Minute)
+	if !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("Window did not expire: %+v", result)
+	}
+}
+
+func TestMemoryStoreSlidingLog(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	s.SlidingLog("key", 2, 100*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	s.SlidingLog("key", 2, 100*time.Millisecond)
+
+	result, _ := s.SlidingLog("key", 2, 100*time.Millisecond)
+	if result.Allowed {
+		t.Fatal("Request allowed beyond the limit")
+	}
+
+	// Retrying is possible once the first request slides out of the window
+	if result.RetryAfter <= 0 || result.RetryAfter > 40*time.Millisecond {
+		t.Fatalf("Unexpected retry after %s", result.RetryAfter)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	result, _ = s.SlidingLog("key", 2, 100*time.Millisecond)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Request not allowed after the oldest slid out: %+v", result)
+	}
+}
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	s := NewMemoryStore()
+	defer s.Close()
+
+	for i := 1; i <= 2; i++ {
+		result, _ := s.TokenBucket("key", 2, 100*time.Millisecond)
+		if !result.Allowed {
+			t.Fatalf("Request %d not allowed", i)
+		}
+	}
+
+	result, _ := s.TokenBucket("key", 2, 100*time.Millisecond)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 50*time.Millisecond {
+		t.Fatalf("Unexpected result for an empty bucket: %+v", result)
+	}
+
+	// A token is added every 50ms
+	time.Sleep(60 * time.Millisecond)
+	result, _ = s.TokenBucket("key", 2, 100*time.Millisecond)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Bucket not refilled: %+v", result)
+	}
+}
+
+func TestDefaultStoreClosed(t *testing.T) {
+	previous := store
+	defer SetStore(previous)
+
+	replacement := NewMemoryStore()
+	defer replacement.Close()
+
+	SetStore(replacement)
+	select {
+	case <-defaultStore.done:
+	default:
+		t.Fatal("Default store not closed once replaced")
+	}
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// legacyStore is implemented by stores which may hold hashes in the layout used before every field
+// was stored under a key of its own, i.e. as a single record holding every field of the hash.
+type legacyStore interface {
+	// LegacyHash returns the fields of the hash if it is stored in the legacy layout, nil otherwise
+	LegacyHash(hash string) (map[string][]byte, error)
+
+	// DeleteLegacyHash removes the hash stored in the legacy layout
+	DeleteLegacyHash(hash string) error
+}
+
+// Moves a field of a legacy hash to the current layout.
+// Fields which have expired or cannot be parsed are dropped, as housekeeping used to do.
+type legacyFieldMigrator func(field string, value []byte) error
+
+// The hashes to be migrated along with the migrator of their fields.
+// Device grants precede the user codes since the lifetime of a user code is that of its grant.
+var legacyHashes = []struct {
+	hash    string
+	migrate legacyFieldMigrator
+}{
+	{authCodeTokensSet, migrateAuthCodeToken},
+	{authCodeGrantSet, migrateAuthCodeGrant},
+	{implicitTokensSet, migrateImplicitToken},
+	{ropcTokensSet, migrateROPCToken},
+	{clientCredsTokensSet, migrateClientCredsToken},
+	{deviceGrantSet, migrateDeviceGrant},
+	{deviceUserCodeSet, migrateDeviceUserCode},
+	{deviceTokensSet, migrateDeviceToken},
+	{authRequestSet, migrateAuthRequest},
+	{rotatedRefreshTokensSet, migrateRotatedRefreshToken},
+	{clientsSet, migrateClient},
+}
+
+// MigrateStore moves the hashes kept in the legacy layout by a store, if any, to the current one.
+// Every field gets a TTL until it expires, and the refresh token and family indexes
+// are built for the tokens of the Authorization Code and ROPC flows.
+// A hash is removed only once all of its fields have been moved, so that a failed migration
+// can be run again. It must be called before the server starts handling requests.
+func MigrateStore() error {
+	legacy, ok := baseStore(store).(legacyStore)
+	if !ok {
+		return nil
+	}
+
+	for _, legacyHash := range legacyHashes {
+		fields, err := legacy.LegacyHash(legacyHash.hash)
+		if err != nil {
+			return err
+		}
+
+		if fields == nil {
+			continue
+		}
+
+		for field, value := range fields {
+			err = legacyHash.migrate(field, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = legacy.DeleteLegacyHash(legacyHash.hash)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Migrated %s, dropping its expired fields\n", legacyHash.hash)
+	}
+
+	return nil
+}
+
+// Stores the field of the hash until it expires, unless it has expired already
+func migrateField(hash, field string, value []byte, expiry time.Time) (bool, error) {
+	if !time.Now().Before(expiry) {
+		return false, nil
+	}
+
+	return true, store.Set(hash, field, value, ttlUntil(expiry))
+}
+
+// Logs a field which cannot be parsed before it is dropped
+func dropLegacyField(hash, field string, err error) error {
+	log.Printf("Dropping %s of %s: %s\n", field, hash, err)
+	return nil
+}
+
+func migrateAuthCodeToken(field string, value []byte) error {
+	var token internalAuthCodeToken
+	err := json.Unmarshal(value, &token)
+	if err != nil {
+		return dropLegacyField(authCodeTokensSet, field, err)
+	}
+
+	// Tokens issued before families were tracked start their own
+	if token.Meta.Family == "" {
+		token.Meta.Family = token.Token.RefreshToken
+	}
+
+	value, err = json.Marshal(token)
+	if err != nil {
+		panic(err)
+	}
+
+	accessTokenExpiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	migrated, err := migrateField(authCodeTokensSet, field, value, laterOf(accessTokenExpiry, token.Meta.refreshTokenExpiry()))
+	if !migrated || err != nil {
+		return err
+	}
+
+	return indexAuthCodeToken(token)
+}
+
+func migrateAuthCodeGrant(field string, value []byte) error {
+	var grant authCodeGrantMeta
+	err := json.Unmarshal(value, &grant)
+	if err != nil {
+		return dropLegacyField(authCodeGrantSet, field, err)
+	}
+
+	_, err = migrateField(authCodeGrantSet, field, value, grant.expiry())
+	return err
+}
+
+func migrateImplicitToken(field string, value []byte) error {
+	var token internalImplicitToken
+	err := json.Unmarshal(value, &token)
+	if err != nil {
+		return dropLegacyField(implicitTokensSet, field, err)
+	}
+
+	_, err = migrateField(implicitTokensSet, field, value, token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn)))
+	return err
+}
+
+func migrateROPCToken(field string, value []byte) error {
+	var token internalROPCToken
+	err := json.Unmarshal(value, &token)
+	if err != nil {
+		return dropLegacyField(ropcTokensSet, field, err)
+	}
+
+	// Tokens issued before families were tracked start their own
+	if token.Meta.Family == "" {
+		token.Meta.Family = token.Token.RefreshToken
+	}
+
+	value, err = json.Marshal(token)
+	if err != nil {
+		panic(err)
+	}
+
+	accessTokenExpiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	migrated, err := migrateField(ropcTokensSet, field, value, laterOf(accessTokenExpiry, token.Meta.refreshTokenExpiry()))
+	if !migrated || err != nil {
+		return err
+	}
+
+	return indexROPCToken(token)
+}
+
+func migrateClientCredsToken(field string, value []byte) error {
+	var token internalClientCredsToken
+	err := json.Unmarshal(value, &token)
+	if err != nil {
+		return dropLegacyField(clientCredsTokensSet, field, err)
+	}
+
+	_, err = migrateField(clientCredsTokensSet, field, value, token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn)))
+	return err
+}
+
+// Legacy device grants held the user's decision, which is now kept apart from the grant
+func migrateDeviceGrant(field string, value []byte) error {
+	var grant struct {
+		deviceGrantMeta
+		Status string `json:"status"`
+	}
+	err := json.Unmarshal(value, &grant)
+	if err != nil {
+		return dropLegacyField(deviceGrantSet, field, err)
+	}
+
+	value, err = json.Marshal(grant.deviceGrantMeta)
+	if err != nil {
+		panic(err)
+	}
+
+	expiry := grant.CreationTime.Add(deviceCodeLifetime)
+	migrated, err := migrateField(deviceGrantSet, field, value, expiry)
+	if !migrated || err != nil || grant.Status == devicePending || grant.Status == "" {
+		return err
+	}
+
+	_, err = migrateField(deviceDecisionSet, field, []byte(grant.Status), expiry)
+	return err
+}
+
+// User codes are kept only while the device grant they point to is pending
+func migrateDeviceUserCode(field string, value []byte) error {
+	grant, err := lookupDeviceGrant(string(value))
+	if err != nil || lookupDeviceStatus(string(value)) != devicePending {
+		return nil
+	}
+
+	_, err = migrateField(deviceUserCodeSet, field, value, grant.CreationTime.Add(deviceCodeLifetime))
+	return err
+}
+
+func migrateDeviceToken(field string, value []byte) error {
+	var token internalDeviceToken
+	err := json.Unmarshal(value, &token)
+	if err != nil {
+		return dropLegacyField(deviceTokensSet, field, err)
+	}
+
+	_, err = migrateField(deviceTokensSet, field, value, token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn)))
+	return err
+}
+
+func migrateAuthRequest(field string, value []byte) error {
+	var request AuthorizationRequest
+	err := json.Unmarshal(value, &request)
+	if err != nil {
+		return dropLegacyField(authRequestSet, field, err)
+	}
+
+	_, err = migrateField(authRequestSet, field, value, request.CreationTime.Add(authRequestLifetime))
+	return err
+}
+
+func migrateRotatedRefreshToken(field string, value []byte) error {
+	var rotated rotatedRefreshToken
+	err := json.Unmarshal(value, &rotated)
+	if err != nil {
+		return dropLegacyField(rotatedRefreshTokensSet, field, err)
+	}
+
+	_, err = migrateField(rotatedRefreshTokensSet, field, value, rotated.RotationTime.Add(refreshTokenLifetime))
+	return err
+}
+
+// Registered clients never expire
+func migrateClient(field string, value []byte) error {
+	return store.Set(clientsSet, field, value, 0)
+}
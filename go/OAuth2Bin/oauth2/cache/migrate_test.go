@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A MemoryStore which also holds hashes in the legacy layout
+type legacyMemoryStore struct {
+	*MemoryStore
+	legacy map[string]map[string][]byte
+}
+
+func (s *legacyMemoryStore) LegacyHash(hash string) (map[string][]byte, error) {
+	return s.legacy[hash], nil
+}
+
+func (s *legacyMemoryStore) DeleteLegacyHash(hash string) error {
+	delete(s.legacy, hash)
+	return nil
+}
+
+func TestMigrateStore(t *testing.T) {
+	previous := store
+	defer SetStore(previous)
+
+	marshal := func(v interface{}) []byte {
+		jsonBytes, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jsonBytes
+	}
+
+	current, currentMeta := generateROPCToken("clientID", "read", "read", 3600)
+	expired, expiredMeta := generateImplicitToken("clientID", "read", 3600)
+	expiredMeta.CreationTime = time.Now().Add(-2 * time.Hour)
+
+	legacy := &legacyMemoryStore{
+		MemoryStore: NewMemoryStore(),
+		legacy: map[string]map[string][]byte{
+			ropcTokensSet: {
+				current.AccessToken: marshal(internalROPCToken{Token: *current, Meta: *currentMeta}),
+			},
+			implicitTokensSet: {
+				expired.AccessToken: marshal(internalImplicitToken{Token: *expired, Meta: *expiredMeta}),
+			},
+			clientsSet: {
+				"client": marshal(ClientRegistration{ClientID: "client"}),
+			},
+			deviceGrantSet: {
+				"deviceCode": marshal(map[string]interface{}{
+					"client_id":     "clientID",
+					"user_code":     "BCDF-GHJK",
+					"status":        deviceApproved,
+					"creation_time": time.Now(),
+				}),
+			},
+		},
+	}
+	defer legacy.Close()
+	SetStore(instrumentStore(legacy))
+
+	err := MigrateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(legacy.legacy) != 0 {
+		t.Fatalf("Legacy hashes not removed: %v", legacy.legacy)
+	}
+
+	// Tokens issued before families were tracked are indexed as their own family
+	token := findROPCToken(current.RefreshToken)
+	if token == nil || token.Token.AccessToken != current.AccessToken {
+		t.Fatal("Migrated token not indexed by its refresh token")
+	}
+
+	revokeROPCTokenFamily(current.RefreshToken)
+	if VerifyROPCToken(current.AccessToken) {
+		t.Fatal("Migrated token not indexed by its family")
+	}
+
+	if VerifyImplicitToken(expired.AccessToken) {
+		t.Fatal("Expired token migrated")
+	}
+
+	if LookupClientRegistration("client") == nil {
+		t.Fatal("Client not migrated")
+	}
+
+	// The decision held by a legacy device grant is kept apart from it
+	if lookupDeviceStatus("deviceCode") != deviceApproved {
+		t.Fatal("Decision on the device grant not migrated")
+	}
+}
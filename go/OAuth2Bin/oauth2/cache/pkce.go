@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// PKCEPlain is the code challenge method where the challenge is the verifier itself
+	PKCEPlain = "plain"
+
+	// PKCES256 is the code challenge method where the challenge is BASE64URL(SHA256(verifier))
+	PKCES256 = "S256"
+)
+
+var (
+	// ErrMissingCodeVerifier is returned when a grant issued with a code challenge
+	// is redeemed without a code_verifier.
+	ErrMissingCodeVerifier = errors.New("code_verifier is required")
+
+	// ErrInvalidCodeVerifier is returned when the code_verifier does not match
+	// the code challenge stored with the grant.
+	ErrInvalidCodeVerifier = errors.New("code_verifier does not match the code_challenge")
+)
+
+// Code verifiers and challenges are 43 to 128 characters from the unreserved set.
+// Refer RFC 7636 Section 4.1 (https://tools.ietf.org/html/rfc7636#section-4.1)
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// PKCEChallenge holds the code challenge sent with an authorization request.
+// Refer RFC 7636 Section 4.3 (https://tools.ietf.org/html/rfc7636#section-4.3)
+type PKCEChallenge struct {
+	Challenge string `json:"code_challenge,omitempty"`
+	Method    string `json:"code_challenge_method,omitempty"`
+}
+
+// NewPKCEChallenge validates the code_challenge and code_challenge_method parameters
+// of an authorization request. The method defaults to "plain" if absent.
+// An empty challenge is valid and indicates that the client does not use PKCE.
+func NewPKCEChallenge(challenge, method string) (PKCEChallenge, error) {
+	if challenge == "" {
+		if method != "" {
+			return PKCEChallenge{}, fmt.Errorf("code_challenge is required with code_challenge_method")
+		}
+
+		return PKCEChallenge{}, nil
+	}
+
+	if method == "" {
+		method = PKCEPlain
+	}
+
+	if method != PKCEPlain && method != PKCES256 {
+		return PKCEChallenge{}, fmt.Errorf("transform algorithm not supported: %s", method)
+	}
+
+	if !pkceValuePattern.MatchString(challenge) {
+		return PKCEChallenge{}, fmt.Errorf("code_challenge must be 43-128 unreserved characters")
+	}
+
+	return PKCEChallenge{Challenge: challenge, Method: method}, nil
+}
+
+// IsEmpty returns true if no code challenge was sent with the authorization request
+func (c PKCEChallenge) IsEmpty() bool {
+	return c.Challenge == ""
+}
+
+// Verify checks the code_verifier sent in the token request against the challenge.
+// Returns nil if the challenge is empty since the client did not use PKCE.
+// Refer RFC 7636 Section 4.6 (https://tools.ietf.org/html/rfc7636#section-4.6)
+func (c PKCEChallenge) Verify(verifier string) error {
+	if c.IsEmpty() {
+		return nil
+	}
+
+	if verifier == "" {
+		return ErrMissingCodeVerifier
+	}
+
+	if !pkceValuePattern.MatchString(verifier) {
+		return ErrInvalidCodeVerifier
+	}
+
+	computed := verifier
+	if c.Method == PKCES256 {
+		sum := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(c.Challenge)) != 1 {
+		return ErrInvalidCodeVerifier
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package cache
+
+import "testing"
+
+// Test vector from RFC 7636 Appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestNewPKCEChallenge(t *testing.T) {
+	pkce, err := NewPKCEChallenge("", "")
+	if err != nil || !pkce.IsEmpty() {
+		t.Fatal("Absent code_challenge must be accepted as no PKCE")
+	}
+
+	pkce, err = NewPKCEChallenge(testVerifier, "")
+	if err != nil || pkce.Method != PKCEPlain {
+		t.Fatal("code_challenge_method must default to plain")
+	}
+
+	if _, err = NewPKCEChallenge(testChallenge, "S512"); err == nil {
+		t.Fatal("Unsupported code_challenge_method accepted")
+	}
+
+	if _, err = NewPKCEChallenge("tooshort", PKCEPlain); err == nil {
+		t.Fatal("code_challenge shorter than 43 characters accepted")
+	}
+
+	if _, err = NewPKCEChallenge("", PKCES256); err == nil {
+		t.Fatal("code_challenge_method without code_challenge accepted")
+	}
+}
+
+func TestPKCEVerify(t *testing.T) {
+	s256 := PKCEChallenge{Challenge: testChallenge, Method: PKCES256}
+	if err := s256.Verify(testVerifier); err != nil {
+		t.Fatalf("S256 verification failed: %s", err)
+	}
+
+	plain := PKCEChallenge{Challenge: testVerifier, Method: PKCEPlain}
+	if err := plain.Verify(testVerifier); err != nil {
+		t.Fatalf("plain verification failed: %s", err)
+	}
+
+	if err := s256.Verify(""); err != ErrMissingCodeVerifier {
+		t.Fatalf("Expected ErrMissingCodeVerifier, got: %v", err)
+	}
+
+	if err := s256.Verify(testChallenge); err != ErrInvalidCodeVerifier {
+		t.Fatalf("Expected ErrInvalidCodeVerifier, got: %v", err)
+	}
+
+	if err := (PKCEChallenge{}).Verify(""); err != nil {
+		t.Fatal("Grant without code challenge must not require a code_verifier")
+	}
+}
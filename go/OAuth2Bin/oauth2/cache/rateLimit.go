@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"math"
+	"time"
+)
+
+// RateResult is the outcome of counting a request against a rate limit
+//
+// Allowed: true if the request is within the limit
+// Remaining: the number of requests which may still be made right away
+// Reset: the time until the whole limit is available again
+// RetryAfter: the time until a request is allowed again, zero if this one was
+type RateResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Returns the outcome of a fixed window in which 'hits' requests were counted so far,
+// for a window which ends after 'ttl'
+func fixedWindowResult(hits, limit int, ttl time.Duration) RateResult {
+	result := RateResult{
+		Allowed:   hits <= limit,
+		Remaining: limit - hits,
+		Reset:     ttl,
+	}
+
+	if !result.Allowed {
+		result.Remaining = 0
+		result.RetryAfter = ttl
+	}
+
+	return result
+}
+
+// Returns the outcome of a sliding log holding 'hits' requests of the window, the oldest and newest
+// of which were made at the given times. The request was logged only if it was allowed.
+func slidingLogResult(allowed bool, hits, limit int, oldest, newest, now time.Time, window time.Duration) RateResult {
+	result := RateResult{
+		Allowed:   allowed,
+		Remaining: limit - hits,
+		Reset:     newest.Add(window).Sub(now),
+	}
+
+	if !allowed {
+		result.Remaining = 0
+		result.RetryAfter = oldest.Add(window).Sub(now)
+	}
+
+	return result
+}
+
+// Refills a token bucket holding 'tokens' at time 'updated' up to 'now', at the rate of
+// 'limit' tokens per window, and takes a token for the request if one is available.
+// Returns the tokens left in the bucket along with the outcome.
+func takeBucketToken(tokens float64, updated, now time.Time, limit int, window time.Duration) (float64, RateResult) {
+	rate := float64(limit) / float64(window)
+	tokens = math.Min(float64(limit), tokens+float64(now.Sub(updated))*rate)
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+
+	return tokens, tokenBucketResult(allowed, tokens, limit, window)
+}
+
+// Returns the outcome of a token bucket holding 'tokens' after the request was counted
+func tokenBucketResult(allowed bool, tokens float64, limit int, window time.Duration) RateResult {
+	rate := float64(limit) / float64(window)
+	result := RateResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit) - tokens) / rate),
+	}
+
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate)
+	}
+
+	return result
+}
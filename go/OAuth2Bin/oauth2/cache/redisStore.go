@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisStore is a Store backed by a Redis server.
+// Every field of a hash is stored as a string key named "<hash>:<field>" which carries
+// the TTL of the field, and rate limits are keys with a TTL updated by Lua scripts.
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore returns a store backed by the Redis server at the URL.
+// An error is returned if the server cannot be reached.
+func NewRedisStore(url string) (*RedisStore, error) {
+	s := &RedisStore{
+		pool: &redis.Pool{
+			MaxActive: 30,
+			MaxIdle:   10,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+		},
+	}
+
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	_, err := conn.Do("PING")
+	if err != nil {
+		s.pool.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Returns the URL of the Redis server to connect to based on certain environment variables.
+//
+// If:
+// - DOCKER is defined, connects to a Redis container.
+// - REDIS_HOST, REDIS_PASS and REDIS_PORT are defined, connects to that server.
+// - none of these are defined, connects to a local Redis server.
+func redisURL() string {
+	if os.Getenv("DOCKER") != "" {
+		// Uses the Redis container if running within Docker
+		log.Println("Redis Server: Docker")
+		return "redis://redis:6379"
+	} else if os.Getenv("REDIS_HOST") == "" && os.Getenv("REDIS_PASS") == "" && os.Getenv("REDIS_PORT") == "" {
+		// Else defaults to a local Redis server
+		log.Println("Redis Server: Local")
+		return "redis://localhost:6379"
+	}
+
+	log.Println("Redis Server: " + os.Getenv("REDIS_HOST"))
+	return fmt.Sprintf("redis://:%s@%s:%s", os.Getenv("REDIS_PASS"), os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT"))
+}
+
+// Closes a Redis connection.
+// Also captures the error, if any, and logs it.
+func closeConn(conn redis.Conn) {
+	err := conn.Close()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Returns the Redis key under which the field of the hash is stored
+func redisKey(hash, field string) string {
+	return hash + ":" + field
+}
+
+// Returns the arguments of a SET command storing the value at key with the TTL, if any
+func redisSetArgs(key string, value []byte, ttl time.Duration) redis.Args {
+	args := redis.Args{key, value}
+	if ttl > 0 {
+		args = args.Add("PX", ttl.Milliseconds())
+	}
+
+	return args
+}
+
+// Get implements Store
+func (s *RedisStore) Get(hash, field string) ([]byte, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	value, err := redis.Bytes(conn.Do("GET", redisKey(hash, field)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+// Set implements Store
+func (s *RedisStore) Set(hash, field string, value []byte, ttl time.Duration) error {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	_, err := conn.Do("SET", redisSetArgs(redisKey(hash, field), value, ttl)...)
+	return err
+}
+
+// SetNX implements Store
+func (s *RedisStore) SetNX(hash, field string, value []byte, ttl time.Duration) (bool, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	// SET ... NX replies with nil if the key exists
+	_, err := redis.String(conn.Do("SET", redisSetArgs(redisKey(hash, field), value, ttl).Add("NX")...))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Exists implements Store
+func (s *RedisStore) Exists(hash, field string) (bool, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	return redis.Bool(conn.Do("EXISTS", redisKey(hash, field)))
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(hash, field string) error {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	_, err := conn.Do("DEL", redisKey(hash, field))
+	return err
+}
+
+// GetAll implements Store by scanning the keys of the fields of the hash
+func (s *RedisStore) GetAll(hash string) (map[string][]byte, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	prefix := redisKey(hash, "")
+	values := make(map[string][]byte)
+	cursor := 0
+
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		_, err = redis.Scan(reply, &cursor, &keys)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			value, err := redis.Bytes(conn.Do("GET", key))
+			if err == redis.ErrNil {
+				// Expired or deleted since the scan
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			values[strings.TrimPrefix(key, prefix)] = value
+		}
+
+		if cursor == 0 {
+			return values, nil
+		}
+	}
+}
+
+// LegacyHash returns the fields of a hash stored as a Redis hash, the layout used before every
+// field was stored under a key of its own. Returns nil if no such hash exists.
+func (s *RedisStore) LegacyHash(hash string) (map[string][]byte, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	keyType, err := redis.String(conn.Do("TYPE", hash))
+	if err != nil || keyType != "hash" {
+		return nil, err
+	}
+
+	items, err := redis.ByteSlices(conn.Do("HGETALL", hash))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(items)/2)
+	for i := 1; i < len(items); i += 2 {
+		values[string(items[i-1])] = items[i]
+	}
+
+	return values, nil
+}
+
+// DeleteLegacyHash removes a hash stored as a Redis hash once its fields have been migrated
+func (s *RedisStore) DeleteLegacyHash(hash string) error {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	_, err := conn.Do("DEL", hash)
+	return err
+}
+
+// Scripts which update the state of a rate limit atomically.
+// The current time is passed in milliseconds by the caller, since the scripts write to the store.
+var (
+	// Counts the request in the window and returns the count along with the time left in the window
+	fixedWindowScript = redis.NewScript(1, `
+local hits = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {hits, ttl}`)
+
+	// Logs the request in a sorted set scored by time if it is within the limit and returns
+	// whether it was allowed, the number of logged requests and the times of the oldest and newest
+	slidingLogScript = redis.NewScript(1, `
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local hits = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if hits < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	hits = hits + 1
+	allowed = 1
+end
+if hits == 0 then
+	return {allowed, 0, now, now - window}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIRE', KEYS[1], tonumber(newest[2]) + window - now)
+return {allowed, hits, oldest[2], newest[2]}`)
+
+	// Refills the bucket, takes a token if one is available and returns whether it was taken
+	// along with the tokens left. The bucket is removed once it would be full again.
+	tokenBucketScript = redis.NewScript(1, `
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local rate = limit / window
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens, updated = tonumber(state[1]), tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens, updated = limit, now
+end
+tokens = math.min(limit, tokens + (now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.max(1, math.ceil((limit - tokens) / rate)))
+return {allowed, tostring(tokens)}`)
+)
+
+// Returns the time in milliseconds since the Unix epoch
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Returns the time of milliseconds since the Unix epoch
+func fromUnixMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// FixedWindow implements Store
+func (s *RedisStore) FixedWindow(key string, limit int, window time.Duration) (RateResult, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	reply, err := redis.Int64s(fixedWindowScript.Do(conn, key, window.Milliseconds()))
+	if err != nil {
+		return RateResult{}, err
+	}
+
+	return fixedWindowResult(int(reply[0]), limit, time.Duration(reply[1])*time.Millisecond), nil
+}
+
+// SlidingLog implements Store
+func (s *RedisStore) SlidingLog(key string, limit int, window time.Duration) (RateResult, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	now := time.Now()
+	nowMillis := unixMillis(now)
+
+	// Requests made within the same millisecond are told apart by a nonce
+	member := fmt.Sprintf("%d:%s", nowMillis, generateNonce(8))
+	reply, err := redis.Int64s(slidingLogScript.Do(conn, key, nowMillis, window.Milliseconds(), limit, member))
+	if err != nil {
+		return RateResult{}, err
+	}
+
+	return slidingLogResult(reply[0] == 1, int(reply[1]), limit, fromUnixMillis(reply[2]), fromUnixMillis(reply[3]), fromUnixMillis(nowMillis), window), nil
+}
+
+// TokenBucket implements Store
+func (s *RedisStore) TokenBucket(key string, limit int, window time.Duration) (RateResult, error) {
+	conn := s.pool.Get()
+	defer closeConn(conn)
+
+	reply, err := redis.Values(tokenBucketScript.Do(conn, key, unixMillis(time.Now()), window.Milliseconds(), limit))
+	if err != nil {
+		return RateResult{}, err
+	}
+
+	var allowed int
+	var tokens float64
+	_, err = redis.Scan(reply, &allowed, &tokens)
+	if err != nil {
+		return RateResult{}, err
+	}
+
+	return tokenBucketResult(allowed == 1, tokens, limit, window), nil
+}
+
+// Close implements Store by closing the pool of Redis connections
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
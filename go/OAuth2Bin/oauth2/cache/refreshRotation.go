@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// Hash which holds the refresh tokens replaced by refresh token rotation,
+	// along with the family they belonged to, for detecting their reuse.
+	rotatedRefreshTokensSet = "OA2B_RotatedRefreshTokens"
+
+	// Hash which marks the access tokens replaced through a refresh until their refresh token expires,
+	// so that concurrent requests presenting the same refresh token cannot both be issued tokens
+	refreshedTokensSet = "OA2B_RefreshedTokens"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token which was already rotated is presented again.
+// Since either the client or an attacker holds a stolen token, the whole token family is revoked.
+var ErrRefreshTokenReused = errors.New("refresh token was already used, all tokens issued for the grant have been revoked")
+
+// Holds the family of a rotated refresh token and the time it was rotated at.
+// A family consists of the tokens issued for the same grant through successive refreshes,
+// identified by the first refresh token issued for it.
+type rotatedRefreshToken struct {
+	Family       string    `json:"family"`
+	RotationTime time.Time `json:"rotation_time"`
+}
+
+// Claims the access token issued with the refresh token presented in a refresh request,
+// before it is replaced by a new one. Returns false if another request claimed it first.
+func claimRefreshedToken(accessToken, clientID string, refreshTokenExpiry time.Time) (bool, error) {
+	claimed, err := store.SetNX(refreshedTokensSet, accessToken, []byte(clientID), ttlUntil(refreshTokenExpiry))
+	if err != nil {
+		log.Println("claimRefreshedToken: " + err.Error())
+	}
+
+	return claimed, err
+}
+
+// Records that the refresh token was replaced by a new one in the family.
+// Rotated tokens are kept for as long as the tokens of their family may be refreshed.
+func recordRotatedRefreshToken(refreshToken, family string, familyExpiry time.Time) {
+	jsonBytes, err := json.Marshal(rotatedRefreshToken{Family: family, RotationTime: time.Now()})
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(rotatedRefreshTokensSet, refreshToken, jsonBytes, ttlUntil(familyExpiry))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// RevokeReusedRefreshToken checks if the refresh token was already rotated and if so,
+// revokes every token of its family, i.e. the current access and refresh tokens of the grant.
+// Returns true if the refresh token was reused.
+// Refer OAuth 2.0 Security Best Current Practice Section 4.14.2
+// (https://tools.ietf.org/html/draft-ietf-oauth-security-topics#section-4.14.2)
+func RevokeReusedRefreshToken(refreshToken string) bool {
+	jsonBytes, err := store.Get(rotatedRefreshTokensSet, refreshToken)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
+		}
+		return false
+	}
+
+	var rotated rotatedRefreshToken
+	err = json.Unmarshal(jsonBytes, &rotated)
+	if err != nil || rotated.Family == "" {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(refreshToken, AuthCodeFlowID):
+		revokeAuthCodeTokenFamily(rotated.Family)
+	case strings.HasPrefix(refreshToken, ROPCFlowID):
+		revokeROPCTokenFamily(rotated.Family)
+	}
+
+	return true
+}
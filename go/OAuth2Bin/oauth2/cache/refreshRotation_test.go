@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "read", "", PKCEChallenge{}, config.DefaultLifetimes)
+	first, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewAuthCodeRefreshToken(first.RefreshToken, "", true, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatalf("Could not rotate refresh token: %s", err)
+	}
+
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("Refresh token was not rotated")
+	}
+
+	third, err := NewAuthCodeRefreshToken(second.RefreshToken, "", true, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatalf("Could not rotate refresh token: %s", err)
+	}
+
+	// Tokens of other families are not affected by reuse
+	other, err := NewROPCToken("clientID", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAuthCodeRefreshToken(first.RefreshToken, "", true, config.DefaultLifetimes)
+	if err != ErrInvalidRefreshToken {
+		t.Fatalf("Rotated refresh token accepted: %v", err)
+	}
+
+	if !RevokeReusedRefreshToken(first.RefreshToken) {
+		t.Fatal("Reuse of a rotated refresh token not detected")
+	}
+
+	if VerifyAuthCodeToken(third.AccessToken) || IntrospectToken(third.RefreshToken, RefreshTokenHint).Active {
+		t.Fatal("Token family not revoked on reuse")
+	}
+
+	if !VerifyROPCToken(other.AccessToken) {
+		t.Fatal("Token of another family revoked")
+	}
+
+	if RevokeReusedRefreshToken(other.RefreshToken) {
+		t.Fatal("Current refresh token reported as reused")
+	}
+}
+
+func TestROPCRefreshTokenRotation(t *testing.T) {
+	first, err := NewROPCToken("clientID", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewROPCRefreshToken(first.RefreshToken, "", true, config.DefaultLifetimes)
+	if err != nil || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("Refresh token was not rotated: %v", err)
+	}
+
+	if !RevokeReusedRefreshToken(first.RefreshToken) || VerifyROPCToken(second.AccessToken) {
+		t.Fatal("Token family not revoked on reuse")
+	}
+}
+
+// A store which is slow to return the values it reads,
+// so that concurrent requests all read a value before any of them changes it
+type delayedStore struct {
+	Store
+}
+
+func (s delayedStore) Get(hash, field string) ([]byte, error) {
+	value, err := s.Store.Get(hash, field)
+	time.Sleep(10 * time.Millisecond)
+	return value, err
+}
+
+// Sends concurrent refresh requests and returns the access tokens issued
+func concurrentRefreshes(refresh func() (string, error)) []string {
+	issued := make(chan string, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(issued); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if accessToken, err := refresh(); err == nil {
+				issued <- accessToken
+			}
+		}()
+	}
+	wg.Wait()
+	close(issued)
+
+	var accessTokens []string
+	for accessToken := range issued {
+		accessTokens = append(accessTokens, accessToken)
+	}
+
+	return accessTokens
+}
+
+// Returns the number of the access tokens which are still valid
+func countValidTokens(accessTokens []string, verify func(string) bool) int {
+	valid := 0
+	for _, accessToken := range accessTokens {
+		if verify(accessToken) {
+			valid++
+		}
+	}
+
+	return valid
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	previous := store
+	defer SetStore(previous)
+	SetStore(delayedStore{previous})
+
+	code := NewAuthCodeGrant("clientID", "https://oauth2bin.org", "read", "", PKCEChallenge{}, config.DefaultLifetimes)
+	authCodeToken, err := NewAuthCodeToken("clientID", code, "", "https://oauth2bin.org", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ropcToken, err := NewROPCToken("clientID", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshAuthCode := func(rotate bool) func() (string, error) {
+		return func() (string, error) {
+			token, err := NewAuthCodeRefreshToken(authCodeToken.RefreshToken, "", rotate, config.DefaultLifetimes)
+			if err != nil {
+				return "", err
+			}
+			return token.AccessToken, nil
+		}
+	}
+
+	refreshROPC := func(rotate bool) func() (string, error) {
+		return func() (string, error) {
+			token, err := NewROPCRefreshToken(ropcToken.RefreshToken, "", rotate, config.DefaultLifetimes)
+			if err != nil {
+				return "", err
+			}
+			return token.AccessToken, nil
+		}
+	}
+
+	// A refresh token which is kept may be presented again once refreshed,
+	// but it is only ever held by a single valid token
+	if valid := countValidTokens(concurrentRefreshes(refreshAuthCode(false)), VerifyAuthCodeToken); valid != 1 {
+		t.Fatalf("Authorization code refresh token forked into %d tokens", valid)
+	}
+
+	if valid := countValidTokens(concurrentRefreshes(refreshROPC(false)), VerifyROPCToken); valid != 1 {
+		t.Fatalf("ROPC refresh token forked into %d tokens", valid)
+	}
+
+	// A refresh token which is rotated is redeemed once however many requests present it at once
+	if issued := concurrentRefreshes(refreshAuthCode(true)); len(issued) != 1 {
+		t.Fatalf("Authorization code refresh token redeemed %d times", len(issued))
+	}
+
+	if issued := concurrentRefreshes(refreshROPC(true)); len(issued) != 1 {
+		t.Fatalf("ROPC refresh token redeemed %d times", len(issued))
+	}
+
+	if !RevokeReusedRefreshToken(authCodeToken.RefreshToken) || !RevokeReusedRefreshToken(ropcToken.RefreshToken) {
+		t.Fatal("Reuse of a rotated refresh token not detected")
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import "strings"
+
+// RevokeToken revokes an access or refresh token issued by any of the flows.
+// The flow is identified by the token's prefix. hint is the token_type_hint
+// sent by the client; refresh tokens are looked up first if it is "refresh_token".
+//
+// Revoking a refresh token also revokes the access tokens issued for it.
+// Since a refresh token is stored along with its access token, revoking an
+// access token revokes its refresh token as well, as permitted by RFC 7009 Section 2.1.
+// Returns true if a token was found and revoked.
+// Refer RFC 7009 Section 2.1 (https://tools.ietf.org/html/rfc7009#section-2.1)
+func RevokeToken(token, hint string) bool {
+	var revokers []func(string) bool
+
+	switch {
+	case strings.HasPrefix(token, AuthCodeFlowID):
+		revokers = []func(string) bool{revokeAuthCodeToken, invalidateAuthCodeRefreshToken}
+	case strings.HasPrefix(token, ROPCFlowID):
+		revokers = []func(string) bool{revokeROPCToken, invalidateROPCRefreshToken}
+	case strings.HasPrefix(token, ImplicitFlowID):
+		revokers = []func(string) bool{revokeImplicitToken}
+	case strings.HasPrefix(token, ClientCredsFlowID):
+		revokers = []func(string) bool{revokeClientCredsToken}
+	case strings.HasPrefix(token, DeviceFlowID):
+		revokers = []func(string) bool{revokeDeviceToken}
+	}
+
+	if hint == RefreshTokenHint && len(revokers) == 2 {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+
+	for _, revoke := range revokers {
+		if revoke(token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func revokeAuthCodeToken(accessToken string) bool {
+	if lookupAuthCodeToken(accessToken) == nil {
+		return false
+	}
+
+	invalidateAuthCodeToken(accessToken)
+	return true
+}
+
+func revokeImplicitToken(accessToken string) bool {
+	if lookupImplicitToken(accessToken) == nil {
+		return false
+	}
+
+	invalidateImplicitToken(accessToken)
+	return true
+}
+
+func revokeROPCToken(accessToken string) bool {
+	if lookupROPCToken(accessToken) == nil {
+		return false
+	}
+
+	invalidateROPCToken(accessToken)
+	return true
+}
+
+func revokeClientCredsToken(accessToken string) bool {
+	if lookupClientCredsToken(accessToken) == nil {
+		return false
+	}
+
+	invalidateClientCredsToken(accessToken)
+	return true
+}
+
+func revokeDeviceToken(accessToken string) bool {
+	if lookupDeviceToken(accessToken) == nil {
+		return false
+	}
+
+	invalidateDeviceToken(accessToken)
+	return true
+}
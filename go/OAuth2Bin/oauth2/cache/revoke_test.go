@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestRevokeRefreshToken(t *testing.T) {
+	token, err := NewROPCToken("clientID", "", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := NewROPCRefreshToken(token.RefreshToken, "", false, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !RevokeToken(token.RefreshToken, RefreshTokenHint) {
+		t.Fatal("Refresh token not found for revocation")
+	}
+
+	if VerifyROPCToken(refreshed.AccessToken) {
+		t.Fatal("Access token issued from a revoked refresh token is still valid")
+	}
+
+	if ROPCRefreshTokenExists(token.RefreshToken, false) {
+		t.Fatal("Revoked refresh token still exists")
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	token, err := NewImplicitToken("clientID", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hint must not prevent the token from being found
+	if !RevokeToken(token.AccessToken, RefreshTokenHint) {
+		t.Fatal("Access token not found for revocation")
+	}
+
+	if VerifyImplicitToken(token.AccessToken) {
+		t.Fatal("Revoked access token is still valid")
+	}
+
+	if RevokeToken(token.AccessToken, "") {
+		t.Fatal("Revoked access token found again")
+	}
+}
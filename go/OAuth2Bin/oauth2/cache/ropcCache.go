@@ -6,13 +6,19 @@ import (
 	"log"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"oauth2bin/oauth2/config"
 )
 
 const (
-	// Redis HSET which holds the issued tokens
+	// Hash which holds the issued tokens
 	ropcTokensSet = "OA2B_ROPC_Tokens"
 
+	// Hash which indexes the issued tokens by their refresh token
+	ropcRefreshTokensSet = "OA2B_ROPC_RefreshTokens"
+
+	// Hash which indexes the issued tokens by their family
+	ropcFamiliesSet = "OA2B_ROPC_Families"
+
 	// ROPCFlowID is prepended to access and refresh tokens issued by the ROPC flow
 	ROPCFlowID = "PASSCRED"
 )
@@ -23,36 +29,60 @@ type ROPCToken struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+
+	// The client the token was issued to
+	ClientID string `json:"-"`
 }
 
-// Holds the meta data of an access token
+// Holds the meta data of an access token.
+// GrantedScope is the scope originally granted by the resource owner,
+// which bounds the scope that may be requested on refresh.
+// Family is the first refresh token issued to the client, shared by the tokens of later refreshes.
+// FamilyExpiry is when the refresh tokens of the family expire, however often they are used,
+// and RefreshTokenExpiry is when the refresh token of this token expires unless used before.
 type ropcTokenMeta struct {
-	CreationTime time.Time `json:"creation_time"`
-	Nonce        string    `json:"nonce"`
+	ClientID           string    `json:"client_id"`
+	CreationTime       time.Time `json:"creation_time"`
+	Nonce              string    `json:"nonce"`
+	GrantedScope       string    `json:"granted_scope"`
+	Family             string    `json:"family"`
+	FamilyExpiry       time.Time `json:"family_expiry"`
+	RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
+}
+
+// Returns when the refresh token expires
+func (m ropcTokenMeta) refreshTokenExpiry() time.Time {
+	return refreshTokenExpiry(m.CreationTime, m.RefreshTokenExpiry)
 }
 
 // Holds the token as well as its metadata.
-// It is the internal representation of the token inside the Redis cache.
+// It is the internal representation of the token inside the store.
 type internalROPCToken struct {
 	Token ROPCToken     `json:"token"`
 	Meta  ropcTokenMeta `json:"meta"`
 }
 
 // NewROPCToken issues new access and refresh tokens for the ROPC flow.
-// It generates and stores a token and stores it along with its meta data
-// in the Redis cache.
-func NewROPCToken(refreshToken string) (*ROPCToken, error) {
-	conn := NewConn()
-	defer CloseConn(conn)
+// It generates a token with the lifetimes and stores it along with its meta data
+// in the store.
+func NewROPCToken(clientID, refreshToken, scope string, lifetimes config.Lifetimes) (*ROPCToken, error) {
+	return issueROPCToken(clientID, refreshToken, "", time.Time{}, scope, scope, lifetimes)
+}
 
+// Generates a token with the given scope and stores it in the store.
+// grantedScope is recorded in the token's metadata for validating future refresh requests.
+// family is that of the refresh token being refreshed, along with the time its refresh tokens expire.
+// If empty, the token starts a new family whose refresh tokens expire after the refresh token lifetime.
+func issueROPCToken(clientID, refreshToken, family string, familyExpiry time.Time, scope, grantedScope string, lifetimes config.Lifetimes) (*ROPCToken, error) {
 	var token *ROPCToken
 	var meta *ropcTokenMeta
 	var err error
-	reply := 1
+	exists := true
 
 	// Generates a new key if a duplicate is encountered
-	for reply == 1 {
-		token, meta = generateROPCToken()
+	for exists {
+		token, meta = generateROPCToken(clientID, scope, grantedScope, lifetimes.AccessToken)
 
 		// Replace newly generated refresh token with function parameter 'refreshToken'
 		// if it is of length 72 since SHA-256 generates a string of length 64 and we
@@ -61,85 +91,229 @@ func NewROPCToken(refreshToken string) (*ROPCToken, error) {
 			token.RefreshToken = refreshToken
 		}
 
-		reply, err = redis.Int(conn.Do("HEXISTS", ropcTokensSet, token.AccessToken))
+		meta.Family = family
+		if family == "" {
+			meta.Family = token.RefreshToken
+		}
+
+		meta.FamilyExpiry, meta.RefreshTokenExpiry = refreshTokenExpiries(meta.CreationTime, familyExpiry, lifetimes)
+
+		exists, err = store.Exists(ropcTokensSet, token.AccessToken)
 		if err != nil {
 			log.Println(err)
 			return nil, err
 		}
 	}
 
-	jsonBytes, err := json.Marshal(internalROPCToken{Token: *token, Meta: *meta})
+	err = storeROPCToken(internalROPCToken{Token: *token, Meta: *meta})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Stores the token until both the access and refresh tokens expire
+// and indexes it by its refresh token and family.
+// The indexes point to the access token last issued, replacing the one issued before it.
+func storeROPCToken(token internalROPCToken) error {
+	jsonBytes, err := json.Marshal(token)
 	if err != nil {
 		panic(err)
 	}
 
-	_, err = conn.Do("HSET", ropcTokensSet, token.AccessToken, string(jsonBytes))
+	accessTokenExpiry := token.Meta.CreationTime.Add(seconds(token.Token.ExpiresIn))
+	ttl := ttlUntil(laterOf(accessTokenExpiry, token.Meta.refreshTokenExpiry()))
+
+	err = store.Set(ropcTokensSet, token.Token.AccessToken, jsonBytes, ttl)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return token, nil
+	return indexROPCToken(token)
+}
+
+// Points the refresh token and family indexes to the token until its refresh token expires
+func indexROPCToken(token internalROPCToken) error {
+	accessToken := []byte(token.Token.AccessToken)
+	ttl := ttlUntil(token.Meta.refreshTokenExpiry())
+
+	err := store.Set(ropcRefreshTokensSet, token.Token.RefreshToken, accessToken, ttl)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(ropcFamiliesSet, token.Meta.Family, accessToken, ttl)
 }
 
 // NewROPCRefreshToken returns new token for the previously issued refresh token
-// The refresh token is kept intact and can be used or future requests.
-func NewROPCRefreshToken(refreshToken string) (*ROPCToken, error) {
-	token, err := NewROPCToken(refreshToken)
+// The refresh token is kept intact and can be used or future requests, unless rotate is true.
+// The previously issued access token is invalidated.
+//
+// If rotate is true, a new refresh token is issued in place of the one presented,
+// which is recorded so that its reuse revokes the whole token family.
+// Refer RFC 6749 Section 10.4 (https://tools.ietf.org/html/rfc6749#section-10.4)
+//
+// If scope is empty, the new token is issued with the scope originally granted.
+// Else it must be a subset of the originally granted scope, or ErrInvalidScope is returned.
+// Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
+//
+// The new token is issued with the lifetimes, except that its refresh token expires
+// no later than the refresh tokens issued before it to the client.
+func NewROPCRefreshToken(refreshToken, scope string, rotate bool, lifetimes config.Lifetimes) (*ROPCToken, error) {
+	previous := findROPCToken(refreshToken)
+	if previous == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	scope, err := narrowRefreshScope(scope, previous.Meta.GrantedScope)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is claimed before it is replaced, so that the refresh token
+	// issues a single new token however many requests present it at once
+	claimed, err := claimRefreshedToken(previous.Token.AccessToken, previous.Meta.ClientID, previous.Meta.refreshTokenExpiry())
+	if err != nil {
+		return nil, err
+	}
+
+	if !claimed {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	invalidateROPCToken(previous.Token.AccessToken)
+
+	// Tokens issued before families and their expiry were tracked start their own
+	family, familyExpiry := previous.Meta.Family, previous.Meta.FamilyExpiry
+	if family == "" {
+		family = refreshToken
+	}
+
+	if familyExpiry.IsZero() {
+		familyExpiry = time.Now().Add(seconds(lifetimes.RefreshToken))
+	}
+
+	if !rotate {
+		return issueROPCToken(previous.Meta.ClientID, refreshToken, family, familyExpiry, scope, previous.Meta.GrantedScope, lifetimes)
+	}
+
+	token, err := issueROPCToken(previous.Meta.ClientID, "", family, familyExpiry, scope, previous.Meta.GrantedScope, lifetimes)
 	if err != nil {
 		return nil, err
 	}
 
+	recordRotatedRefreshToken(refreshToken, family, familyExpiry)
 	return token, nil
 }
 
-// ROPCRefreshTokenExists checks if the refresh token exists in the Redis cache
+// ROPCRefreshTokenExists checks if the refresh token exists in the store
 // and returns the appropriate boolean values.
 // Params:
 // refreshToken: the token to look for in the cache
 // invalidateIfFound: if true, the token is invalidated if found
 func ROPCRefreshTokenExists(refreshToken string, invalidateIfFound bool) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
+	token := findROPCToken(refreshToken)
+	if token == nil {
+		return false
+	}
 
-	var token internalROPCToken
-	items, err := redis.ByteSlices(conn.Do("HGETALL", ropcTokensSet))
-	if err != nil {
-		log.Println(err)
+	if invalidateIfFound {
+		invalidateROPCToken(token.Token.AccessToken)
 	}
 
-	for i := 1; i < len(items); i += 2 {
-		err := json.Unmarshal(items[i], &token)
-		if err != nil {
+	return true
+}
+
+// Looks up the token last issued with the given refresh token through the refresh token index.
+// Returns nil if not found.
+func findROPCToken(refreshToken string) *internalROPCToken {
+	accessToken, err := store.Get(ropcRefreshTokensSet, refreshToken)
+	if err != nil {
+		if err != ErrNotFound {
 			log.Println(err)
-			break
 		}
+		return nil
+	}
 
-		if refreshToken == token.Token.RefreshToken {
-			if invalidateIfFound {
-				invalidateROPCToken(token.Token.AccessToken)
-			}
+	// The access token may have been revoked since it was indexed,
+	// and the refresh token may have expired before the store removed it
+	token := lookupROPCToken(string(accessToken))
+	if token == nil || token.Token.RefreshToken != refreshToken || !time.Now().Before(token.Meta.refreshTokenExpiry()) {
+		return nil
+	}
+
+	return token
+}
 
-			return true
+// Removes the token issued with the given refresh token from the store,
+// thereby revoking the refresh token along with its access token.
+// Returns true if a token was removed.
+func invalidateROPCRefreshToken(refreshToken string) bool {
+	token := findROPCToken(refreshToken)
+	if token == nil {
+		return false
+	}
+
+	invalidateROPCToken(token.Token.AccessToken)
+
+	err := store.Delete(ropcRefreshTokensSet, refreshToken)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return true
+}
+
+// Removes the token last issued in the family from the store, along with its refresh token.
+// Since every refresh invalidates the access token it replaces, it is the only one left.
+func revokeROPCTokenFamily(family string) {
+	accessToken, err := store.Get(ropcFamiliesSet, family)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
 		}
+		return
 	}
 
-	return false
+	token := lookupROPCToken(string(accessToken))
+	if token != nil && token.Meta.Family == family {
+		invalidateROPCRefreshToken(token.Token.RefreshToken)
+	}
+
+	err = store.Delete(ropcFamiliesSet, family)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
-// VerifyROPCToken checks if the token exists in the Redis cache.
+// VerifyROPCToken checks if the token exists in the store.
 // Returns true if token found, false otherwise.
 func VerifyROPCToken(token string) bool {
-	conn := NewConn()
-	defer CloseConn(conn)
+	exists, err := store.Exists(ropcTokensSet, token)
+	return err == nil && exists
+}
 
-	_, err := redis.String(conn.Do("HGET", ropcTokensSet, token))
-	return err == nil
+// Fetches the access token along with its metadata from the store.
+// Returns nil if not found.
+func lookupROPCToken(accessToken string) *internalROPCToken {
+	jsonBytes, err := store.Get(ropcTokensSet, accessToken)
+	if err != nil {
+		return nil
+	}
+
+	var token internalROPCToken
+	err = json.Unmarshal(jsonBytes, &token)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &token
 }
 
 func invalidateROPCToken(accessToken string) {
-	conn := NewConn()
-	defer CloseConn(conn)
-	_, err := conn.Do("HDEL", ropcTokensSet, accessToken)
+	err := store.Delete(ropcTokensSet, accessToken)
 	if err != nil {
 		log.Println(err)
 	}
@@ -151,7 +325,7 @@ func invalidateROPCToken(accessToken string) {
 // Refresh token starts with the flow identifier "PASSCRED" followed by the hex-encoded
 // string of the SHA-256 hash of the concatenation of the access token, the time of
 // creation and the same nonce.
-func generateROPCToken() (*ROPCToken, *ropcTokenMeta) {
+func generateROPCToken(clientID, scope, grantedScope string, expiresIn int) (*ROPCToken, *ropcTokenMeta) {
 	nonce := generateNonce(16)
 	creationTime := time.Now()
 
@@ -161,38 +335,13 @@ func generateROPCToken() (*ROPCToken, *ropcTokenMeta) {
 	return &ROPCToken{
 			AccessToken:  accessToken,
 			RefreshToken: refreshToken,
-			ExpiresIn:    3600,
+			ExpiresIn:    expiresIn,
+			Scope:        scope,
+			ClientID:     clientID,
 		}, &ropcTokenMeta{
+			ClientID:     clientID,
 			CreationTime: creationTime,
 			Nonce:        nonce,
+			GrantedScope: grantedScope,
 		}
 }
-
-// Housekeeping service for the ROPC tokens set
-func ropcTokenHousekeep(conn redis.Conn) {
-	var token internalROPCToken
-	var err error
-	var diff time.Duration
-
-	items, err := redis.ByteSlices(conn.Do("HGETALL", ropcTokensSet))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	for i := 1; i < len(items); i += 2 {
-		err = json.Unmarshal(items[i], &token)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-
-		diff = time.Now().Sub(token.Meta.CreationTime)
-		if diff >= time.Hour {
-			_, err = conn.Do("HDEL", ropcTokensSet, items[i-1])
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-}
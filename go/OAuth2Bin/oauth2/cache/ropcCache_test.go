@@ -1,13 +1,17 @@
 package cache
 
-import "testing"
+import (
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
 
 // TestROPCFlow tests the entirely of the functions set of ropcStore
 // as they would be used by the Resource Owner Password Credentials flow
 func TestROPCFlow(t *testing.T) {
 	// Generating a token based on the grant which
 	// would be generated by invoking the token endpoint
-	token, err := NewROPCToken("")
+	token, err := NewROPCToken("clientID", "", "", config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token:\n%s\n", err)
 	}
@@ -21,7 +25,7 @@ func TestROPCFlow(t *testing.T) {
 	}
 
 	// Issue new token based on the previously issued refresh token
-	token, err = NewROPCRefreshToken(token.RefreshToken)
+	token, err = NewROPCRefreshToken(token.RefreshToken, "", false, config.DefaultLifetimes)
 	if err != nil {
 		t.Fatalf("Could not generate token from refresh token\n")
 	}
@@ -37,3 +41,41 @@ func TestROPCRefreshTokenExists(t *testing.T) {
 		t.Fatalf("Empty refresh token should not exist")
 	}
 }
+
+// TestROPCRefreshScope checks that the scope may only be narrowed on refresh
+func TestROPCRefreshScope(t *testing.T) {
+	token, err := NewROPCToken("clientID", "", "read write", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Scope != "read write" {
+		t.Fatalf("Expected scope \"read write\", got %q", token.Scope)
+	}
+
+	_, err = NewROPCRefreshToken(token.RefreshToken, "read admin", false, config.DefaultLifetimes)
+	if err != ErrInvalidScope {
+		t.Fatalf("Expected ErrInvalidScope on widening the scope, got: %v", err)
+	}
+
+	narrowed, err := NewROPCRefreshToken(token.RefreshToken, "read", false, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if narrowed.Scope != "read" {
+		t.Fatalf("Expected narrowed scope \"read\", got %q", narrowed.Scope)
+	}
+
+	// The refresh token retains the scope originally granted
+	restored, err := NewROPCRefreshToken(token.RefreshToken, "", false, config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.Scope != "read write" {
+		t.Fatalf("Expected original scope \"read write\", got %q", restored.Scope)
+	}
+
+	invalidateROPCToken(restored.AccessToken)
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"oauth2bin/oauth2/utils"
+)
+
+// Kinds of stores which can be selected through the configuration or the STORE environment variable
+const (
+	RedisStoreKind  = "redis"
+	MemoryStoreKind = "memory"
+)
+
+// ErrNotFound is returned by a Store when the requested field does not exist
+var ErrNotFound = errors.New("not found")
+
+// Store persists the grants and tokens issued by the flows as well as the state of the rate limits.
+//
+// Grants and tokens are kept as fields of named hashes, each holding a JSON-encoded value.
+// Every field is stored under a key of its own, so that it can be looked up in constant time
+// and expire on its own after the TTL it was set with, without the hash being scanned.
+// The state of a rate limit is kept under a key of its own and updated atomically,
+// so that concurrent requests, possibly to different servers, are counted exactly once.
+type Store interface {
+	// Get returns the value of the field in the hash, or ErrNotFound
+	Get(hash, field string) ([]byte, error)
+
+	// Set sets the value of the field in the hash, overwriting it if it exists.
+	// The field expires after ttl, or never if ttl is zero.
+	Set(hash, field string, value []byte, ttl time.Duration) error
+
+	// SetNX sets the value of the field in the hash only if it does not exist.
+	// The field expires after ttl, or never if ttl is zero.
+	// Returns true if the field was set.
+	SetNX(hash, field string, value []byte, ttl time.Duration) (bool, error)
+
+	// Exists returns true if the field exists in the hash
+	Exists(hash, field string) (bool, error)
+
+	// Delete removes the field from the hash. Deleting a missing field is not an error.
+	Delete(hash, field string) error
+
+	// GetAll returns all the fields of the hash along with their values.
+	// Its cost grows with the size of the store, hence it is not meant for serving requests.
+	GetAll(hash string) (map[string][]byte, error)
+
+	// FixedWindow counts a request against the limit of requests per window at key.
+	// The window starts with the first request counted in it.
+	FixedWindow(key string, limit int, window time.Duration) (RateResult, error)
+
+	// SlidingLog counts a request against the limit of requests made over the last window at key.
+	// Only the requests which are allowed are logged.
+	SlidingLog(key string, limit int, window time.Duration) (RateResult, error)
+
+	// TokenBucket takes a token for a request from the bucket at key, which holds up to limit tokens
+	// and is refilled at the rate of limit tokens per window. The bucket starts full.
+	TokenBucket(key string, limit int, window time.Duration) (RateResult, error)
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// The in-memory store the flows use until another is set, so that the package
+// can be used without any further configuration. Since nothing else holds it,
+// it is closed once replaced, stopping its background goroutine.
+var defaultStore = NewMemoryStore()
+
+// The store used by the flows
+var store Store = defaultStore
+
+// SetStore replaces the store used by the flows, closing the default store if it is the one replaced.
+// It must be called before the server starts handling requests.
+func SetStore(s Store) {
+	if store == Store(defaultStore) && s != Store(defaultStore) {
+		defaultStore.Close()
+	}
+
+	store = s
+}
+
+// CurrentStore returns the store used by the flows
+func CurrentStore() Store {
+	return store
+}
+
+// NewStore returns a store of the given kind, either "redis" or "memory", whose operations are measured.
+// If kind is empty, a Redis store is used if a Redis server is configured through
+// the environment (see redisURL), else an in-memory store is used.
+func NewStore(kind string) (Store, error) {
+	if kind == "" {
+		kind = MemoryStoreKind
+		if os.Getenv("DOCKER") != "" || os.Getenv("REDIS_HOST") != "" {
+			kind = RedisStoreKind
+		}
+	}
+
+	switch kind {
+	case RedisStoreKind:
+		s, err := NewRedisStore(redisURL())
+		if err != nil {
+			return nil, err
+		}
+		return instrumentStore(s), nil
+	case MemoryStoreKind:
+		log.Println("Store: in-memory")
+		return instrumentStore(NewMemoryStore()), nil
+	}
+
+	return nil, fmt.Errorf("unknown store: %s", kind)
+}
+
+// CloseStore closes the store used by the flows.
+// Also captures the error, if any, and logs it.
+func CloseStore() {
+	utils.Clearln() // Remove the '^C' generated by SIGINT
+	log.Println("Closing store")
+	err := store.Close()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Println("Store closed")
+}
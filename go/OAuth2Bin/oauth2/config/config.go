@@ -6,38 +6,338 @@ const (
 	Implicit    = 2
 	ROPC        = 3
 	ClientCreds = 4
+	Device      = 5
 )
 
+// Grant types a client may be allowed to use
+const (
+	AuthCodeGrant     = "authorization_code"
+	ImplicitGrant     = "implicit"
+	ROPCGrant         = "password"
+	ClientCredsGrant  = "client_credentials"
+	DeviceGrant       = "urn:ietf:params:oauth:grant-type:device_code"
+	RefreshTokenGrant = "refresh_token"
+)
+
+// Formats of the access tokens issued to a client
+const (
+	OpaqueAccessToken = "opaque"
+	JWTAccessToken    = "jwt"
+)
+
+// ClientIDSeparator is reserved in client IDs. It separates the ID of a client
+// from the faults requested through it, see middleware.ParseFaultClientID.
+const ClientIDSeparator = ";"
+
+// Lifetimes defines how long the grants and tokens issued by a flow or to a client remain valid, in seconds.
+// A field left zero takes the value set for the flow, or else the default in DefaultLifetimes.
+//
+// AccessToken: the lifetime of access tokens, sent to the client as expires_in.
+// RefreshToken: the absolute lifetime of refresh tokens, counted from the authorization of the grant.
+// Refreshing, with or without rotation, never extends it.
+// RefreshTokenIdle: the time a refresh token stays valid without being used. Every refresh renews it.
+// AuthCode: the lifetime of authorization codes.
+type Lifetimes struct {
+	AccessToken      int `json:"accessToken"`
+	RefreshToken     int `json:"refreshToken"`
+	RefreshTokenIdle int `json:"refreshTokenIdle"`
+	AuthCode         int `json:"authCode"`
+}
+
+// DefaultLifetimes holds the lifetimes which apply unless configured otherwise
+var DefaultLifetimes = Lifetimes{
+	AccessToken:      3600,
+	RefreshToken:     86400,
+	RefreshTokenIdle: 3600,
+	AuthCode:         600,
+}
+
+// Returns the lifetimes with the fields left zero taken from fallback
+func (l Lifetimes) withFallback(fallback Lifetimes) Lifetimes {
+	if l.AccessToken == 0 {
+		l.AccessToken = fallback.AccessToken
+	}
+
+	if l.RefreshToken == 0 {
+		l.RefreshToken = fallback.RefreshToken
+	}
+
+	if l.RefreshTokenIdle == 0 {
+		l.RefreshTokenIdle = fallback.RefreshTokenIdle
+	}
+
+	if l.AuthCode == 0 {
+		l.AuthCode = fallback.AuthCode
+	}
+
+	return l
+}
+
 // AuthCodeConfig defines the variables required in the OAuth 2.0 Authorization Code flow
+//
+// RequirePKCE: if true, public clients must send a PKCE code challenge (RFC 7636)
+// in the authorization request. A client is public if it has no client secret.
+// RedirectURIs: the redirection endpoints of the client. Authorization requests are refused without one.
+// Scopes: the scopes the client may request. If empty, any scope is allowed.
+// AccessTokenFormat: "jwt" or "opaque". If empty, the global JWT setting applies.
+// Lifetimes: the lifetimes of the grants and tokens issued by the flow. See Lifetimes.
 type AuthCodeConfig struct {
-	ClientID     string `json:"clientID"`
-	ClientSecret string `json:"clientSecret"`
+	ClientID          string    `json:"clientID"`
+	ClientSecret      string    `json:"clientSecret"`
+	RedirectURIs      []string  `json:"redirectURIs"`
+	RequirePKCE       bool      `json:"requirePKCE"`
+	Scopes            []string  `json:"scopes"`
+	AccessTokenFormat string    `json:"accessTokenFormat"`
+	Lifetimes         Lifetimes `json:"lifetimes"`
 }
 
-// ImplicitConfig defines the variables required in the OAuth 2.0 Implicit flow
+// IsPublicClient returns true if the client has no client secret
+// and thus cannot authenticate itself at the token endpoint.
+func (c AuthCodeConfig) IsPublicClient() bool {
+	return c.ClientSecret == ""
+}
+
+// ImplicitConfig defines the variables required in the OAuth 2.0 Implicit flow.
+// Authorization requests are refused unless the client has registered RedirectURIs.
 type ImplicitConfig struct {
-	ClientID string `json:"clientID"`
+	ClientID          string    `json:"clientID"`
+	RedirectURIs      []string  `json:"redirectURIs"`
+	Scopes            []string  `json:"scopes"`
+	AccessTokenFormat string    `json:"accessTokenFormat"`
+	Lifetimes         Lifetimes `json:"lifetimes"`
 }
 
 // ROPCConfig defines the variables required in the OAuth 2.0 Resource Owner Password Credentials flow
+// The user is also the one signed in on the authorization screen, whose Name and Email
+// are served at the OpenID Connect UserInfo endpoint.
 type ROPCConfig struct {
-	Username     string `json:"username"`
-	Password     string `json:"password"`
-	ClientID     string `json:"clientID"`
-	ClientSecret string `json:"clientSecret"`
+	Username          string    `json:"username"`
+	Password          string    `json:"password"`
+	Name              string    `json:"name"`
+	Email             string    `json:"email"`
+	ClientID          string    `json:"clientID"`
+	ClientSecret      string    `json:"clientSecret"`
+	Scopes            []string  `json:"scopes"`
+	AccessTokenFormat string    `json:"accessTokenFormat"`
+	Lifetimes         Lifetimes `json:"lifetimes"`
 }
 
 // ClientCredsConfig defines the variables required in the OAuth 2.0 Client Credentials flow
 type ClientCredsConfig struct {
-	ClientID     string `json:"clientID"`
-	ClientSecret string `json:"clientSecret"`
+	ClientID          string    `json:"clientID"`
+	ClientSecret      string    `json:"clientSecret"`
+	Scopes            []string  `json:"scopes"`
+	AccessTokenFormat string    `json:"accessTokenFormat"`
+	Lifetimes         Lifetimes `json:"lifetimes"`
+}
+
+// DeviceConfig defines the variables required in the OAuth 2.0 Device Authorization flow (RFC 8628).
+// The client is public since devices cannot keep a client secret.
+type DeviceConfig struct {
+	ClientID          string    `json:"clientID"`
+	Scopes            []string  `json:"scopes"`
+	AccessTokenFormat string    `json:"accessTokenFormat"`
+	Lifetimes         Lifetimes `json:"lifetimes"`
+}
+
+// Client defines a client registered with the server
+//
+// Secret: if empty, the client is public and cannot authenticate itself.
+// GrantTypes: the grant types the client may use, e.g. "authorization_code" or "refresh_token".
+// RedirectURIs: the redirection endpoints of the client. If empty, authorization requests are refused.
+// Scopes: the scopes the client may request. If empty, any scope is allowed.
+// RequirePKCE: if true and the client is public, it must send a PKCE code challenge (RFC 7636).
+// AccessTokenFormat: "jwt" or "opaque". If empty, the global JWT setting applies.
+// RotateRefreshTokens: if true, a new refresh token is issued on every refresh. See OA2Config.
+// Lifetimes: the lifetimes of the grants and tokens issued to the client, overriding those of the flow.
+type Client struct {
+	ID                  string    `json:"clientID"`
+	Secret              string    `json:"clientSecret"`
+	GrantTypes          []string  `json:"grantTypes"`
+	RedirectURIs        []string  `json:"redirectURIs"`
+	Scopes              []string  `json:"scopes"`
+	RequirePKCE         bool      `json:"requirePKCE"`
+	AccessTokenFormat   string    `json:"accessTokenFormat"`
+	RotateRefreshTokens bool      `json:"rotateRefreshTokens"`
+	Lifetimes           Lifetimes `json:"lifetimes"`
+}
+
+// IsPublic returns true if the client has no client secret
+func (c Client) IsPublic() bool {
+	return c.Secret == ""
+}
+
+// Authenticate returns true if the secret is that of a confidential client,
+// or if it is empty for a public client.
+func (c Client) Authenticate(secret string) bool {
+	return c.Secret == secret
+}
+
+// AllowsGrantType returns true if the client may use the grant type
+func (c Client) AllowsGrantType(grantType string) bool {
+	for _, allowed := range c.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsRedirectURI returns true if the URI is one of the client's redirection endpoints.
+// A client which has not registered any is not allowed any, lest the server redirect anywhere.
+func (c Client) AllowsRedirectURI(redirectURI string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JWTConfig defines how JWT access tokens (RFC 9068) are signed and issued
+//
+// Enabled: if true, JWT access tokens are issued to every client that does not override it
+// Algorithm: "RS256" (default) or "ES256", used when the key is generated at startup
+// KeyFile: path to a PEM-encoded private key. If empty, a key is generated at startup.
+// Audience: the "aud" claim of the access tokens. Defaults to the base URL.
+type JWTConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Algorithm string `json:"algorithm"`
+	KeyFile   string `json:"keyFile"`
+	Audience  string `json:"audience"`
+}
+
+// AdminConfig defines the credentials of the admin API, presented with HTTP Basic authentication.
+// The admin API is disabled unless the password is set. The ADMIN_PASSWORD environment variable
+// overrides the password set here, so that it need not be kept along with the configuration.
+type AdminConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // OA2Config defines the configurations for all the flows in OAuth 2.0
+//
+// Clients: the clients registered with the server. The client configured for each flow
+// is registered as well, see RegisteredClients.
+// Store: where grants and tokens are kept, "redis" or "memory". It can be overridden by
+// the STORE environment variable. If neither is set, Redis is used if configured
+// through the environment, else the in-memory store.
+// RotateRefreshTokens: if true, a new refresh token is issued to every client on every refresh,
+// and presenting a rotated refresh token again revokes all the tokens issued for the grant.
+// TrustedProxies: the IP addresses or CIDR ranges of the proxies whose X-Forwarded-For header
+// is trusted by the rate policies keyed by the forwarded address.
+// Admin: the credentials of the admin API. See AdminConfig.
 type OA2Config struct {
-	BaseURL         string            `json:"baseURL"`
-	AuthCodeCnfg    AuthCodeConfig    `json:"authCode"`
-	ImplicitCnfg    ImplicitConfig    `json:"implicit"`
-	ROPCCnfg        ROPCConfig        `json:"ropc"`
-	ClientCredsCnfg ClientCredsConfig `json:"clientCreds"`
+	BaseURL             string            `json:"baseURL"`
+	Store               string            `json:"store"`
+	RotateRefreshTokens bool              `json:"rotateRefreshTokens"`
+	AuthCodeCnfg        AuthCodeConfig    `json:"authCode"`
+	ImplicitCnfg        ImplicitConfig    `json:"implicit"`
+	ROPCCnfg            ROPCConfig        `json:"ropc"`
+	ClientCredsCnfg     ClientCredsConfig `json:"clientCreds"`
+	DeviceCnfg          DeviceConfig      `json:"device"`
+	JWTCnfg             JWTConfig         `json:"jwt"`
+	Clients             []Client          `json:"clients"`
+	TrustedProxies      []string          `json:"trustedProxies"`
+	Admin               AdminConfig       `json:"admin"`
+}
+
+// RegisteredClients returns the clients listed in the configuration followed by the
+// clients configured for each flow, so that the per-flow layout keeps working.
+// A per-flow client is allowed only the grant types of its flow, along with refresh
+// tokens where the flow issues them.
+func (c OA2Config) RegisteredClients() []Client {
+	clients := append([]Client{}, c.Clients...)
+
+	if c.AuthCodeCnfg.ClientID != "" {
+		clients = append(clients, Client{
+			ID:                c.AuthCodeCnfg.ClientID,
+			Secret:            c.AuthCodeCnfg.ClientSecret,
+			GrantTypes:        []string{AuthCodeGrant, RefreshTokenGrant},
+			RedirectURIs:      c.AuthCodeCnfg.RedirectURIs,
+			Scopes:            c.AuthCodeCnfg.Scopes,
+			RequirePKCE:       c.AuthCodeCnfg.RequirePKCE,
+			AccessTokenFormat: c.AuthCodeCnfg.AccessTokenFormat,
+		})
+	}
+
+	if c.ImplicitCnfg.ClientID != "" {
+		clients = append(clients, Client{
+			ID:                c.ImplicitCnfg.ClientID,
+			GrantTypes:        []string{ImplicitGrant},
+			RedirectURIs:      c.ImplicitCnfg.RedirectURIs,
+			Scopes:            c.ImplicitCnfg.Scopes,
+			AccessTokenFormat: c.ImplicitCnfg.AccessTokenFormat,
+		})
+	}
+
+	if c.ROPCCnfg.ClientID != "" {
+		clients = append(clients, Client{
+			ID:                c.ROPCCnfg.ClientID,
+			Secret:            c.ROPCCnfg.ClientSecret,
+			GrantTypes:        []string{ROPCGrant, RefreshTokenGrant},
+			Scopes:            c.ROPCCnfg.Scopes,
+			AccessTokenFormat: c.ROPCCnfg.AccessTokenFormat,
+		})
+	}
+
+	if c.ClientCredsCnfg.ClientID != "" {
+		clients = append(clients, Client{
+			ID:                c.ClientCredsCnfg.ClientID,
+			Secret:            c.ClientCredsCnfg.ClientSecret,
+			GrantTypes:        []string{ClientCredsGrant},
+			Scopes:            c.ClientCredsCnfg.Scopes,
+			AccessTokenFormat: c.ClientCredsCnfg.AccessTokenFormat,
+		})
+	}
+
+	if c.DeviceCnfg.ClientID != "" {
+		clients = append(clients, Client{
+			ID:                c.DeviceCnfg.ClientID,
+			GrantTypes:        []string{DeviceGrant},
+			Scopes:            c.DeviceCnfg.Scopes,
+			AccessTokenFormat: c.DeviceCnfg.AccessTokenFormat,
+		})
+	}
+
+	return clients
+}
+
+// IssuesJWTAccessTokens returns true if the client is to be issued JWT access tokens,
+// either as configured for the client or by the global JWT setting.
+func (c OA2Config) IssuesJWTAccessTokens(client Client) bool {
+	if client.AccessTokenFormat == "" {
+		return c.JWTCnfg.Enabled
+	}
+
+	return client.AccessTokenFormat == JWTAccessToken
+}
+
+// RotatesRefreshTokens returns true if a new refresh token is to be issued to the client on every refresh,
+// either as configured for the client or by the global setting.
+func (c OA2Config) RotatesRefreshTokens(client Client) bool {
+	return c.RotateRefreshTokens || client.RotateRefreshTokens
+}
+
+// LifetimesFor returns the lifetimes of the grants and tokens issued to the client by the flow.
+// Those configured for the client take precedence over those of the flow, which take
+// precedence over DefaultLifetimes.
+func (c OA2Config) LifetimesFor(client Client, flow int) Lifetimes {
+	var flowLifetimes Lifetimes
+	switch flow {
+	case AuthCode:
+		flowLifetimes = c.AuthCodeCnfg.Lifetimes
+	case Implicit:
+		flowLifetimes = c.ImplicitCnfg.Lifetimes
+	case ROPC:
+		flowLifetimes = c.ROPCCnfg.Lifetimes
+	case ClientCreds:
+		flowLifetimes = c.ClientCredsCnfg.Lifetimes
+	case Device:
+		flowLifetimes = c.DeviceCnfg.Lifetimes
+	}
+
+	return client.Lifetimes.withFallback(flowLifetimes.withFallback(DefaultLifetimes))
 }
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := OA2Config{
+		BaseURL:        "https://oauth2bin.org",
+		Clients:        []Client{{ID: "client", GrantTypes: []string{AuthCodeGrant}}},
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Valid configuration rejected: %s", err)
+	}
+
+	invalid := map[string]func(c *OA2Config){
+		"base URL":         func(c *OA2Config) { c.BaseURL = "" },
+		"client ID":        func(c *OA2Config) { c.Clients = []Client{{}} },
+		"duplicate client": func(c *OA2Config) { c.Clients = []Client{{ID: "client"}, {ID: "client"}} },
+		"client separator": func(c *OA2Config) { c.Clients = []Client{{ID: "client;latency=10"}} },
+		"flow client ID":   func(c *OA2Config) { c.AuthCodeCnfg.ClientID = "client;status=500" },
+		"grant type":       func(c *OA2Config) { c.Clients = []Client{{ID: "client", GrantTypes: []string{"magic"}}} },
+		"token format":     func(c *OA2Config) { c.ClientCredsCnfg = ClientCredsConfig{ClientID: "cc", AccessTokenFormat: "xml"} },
+		"flow lifetimes":   func(c *OA2Config) { c.ROPCCnfg.Lifetimes.AccessToken = -1 },
+		"client lifetimes": func(c *OA2Config) { c.Clients = []Client{{ID: "client", Lifetimes: Lifetimes{AuthCode: -1}}} },
+		"trusted proxy":    func(c *OA2Config) { c.TrustedProxies = []string{"proxy.local"} },
+	}
+
+	for name, invalidate := range invalid {
+		c := valid
+		invalidate(&c)
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Configuration with invalid %s accepted", name)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := OA2Config{
+		BaseURL:      "https://oauth2bin.org",
+		AuthCodeCnfg: AuthCodeConfig{ClientID: "clientID", ClientSecret: "old-secret"},
+		Clients:      []Client{{ID: "spa", Scopes: []string{"read"}}},
+	}
+
+	updated := old
+	updated.AuthCodeCnfg.ClientSecret = "new-secret"
+	updated.AuthCodeCnfg.Lifetimes.AccessToken = 60
+	updated.Clients = []Client{{ID: "spa", Scopes: []string{"read", "write"}}, {ID: "service", Secret: "service-secret"}}
+
+	changes := Diff(old, updated)
+	expected := []string{
+		"authCode.clientSecret changed",
+		"authCode.lifetimes.accessToken: 0 -> 60",
+		`clients[0].scopes[1] added: "write"`,
+		`clients[1] added: {`,
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Unexpected changes: %q", changes)
+	}
+
+	for i := range expected {
+		if !strings.HasPrefix(changes[i], expected[i]) {
+			t.Fatalf("Expected %s, got %s", expected[i], changes[i])
+		}
+	}
+
+	// Secrets are never logged
+	for _, change := range changes {
+		if strings.Contains(change, "secret\"") || strings.Contains(change, "-secret") {
+			t.Fatalf("Secret written in %s", change)
+		}
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Fatalf("Unexpected changes: %q", changes)
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Settings whose values are never written to the logs
+var secretSettings = map[string]bool{
+	"clientSecret": true,
+	"password":     true,
+}
+
+// Diff returns the settings which differ between the configurations, one per line, named by their
+// path in the JSON configuration and followed by their old and new values.
+// The values of secrets are never included.
+func Diff(old, updated OA2Config) []string {
+	var changes []string
+	diffJSON("", jsonValue(old), jsonValue(updated), &changes)
+	return changes
+}
+
+// Returns the configuration as decoded from its JSON encoding
+func jsonValue(c OA2Config) interface{} {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	var value interface{}
+	json.Unmarshal(data, &value)
+	return value
+}
+
+// Appends the differences between the old and new values found at the path to the changes
+func diffJSON(path string, old, updated interface{}, changes *[]string) {
+	oldObject, oldIsObject := old.(map[string]interface{})
+	updatedObject, updatedIsObject := updated.(map[string]interface{})
+	if oldIsObject && updatedIsObject {
+		keys := make([]string, 0, len(oldObject)+len(updatedObject))
+		for key := range oldObject {
+			keys = append(keys, key)
+		}
+		for key := range updatedObject {
+			if _, found := oldObject[key]; !found {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if secretSettings[key] {
+				if !reflect.DeepEqual(oldObject[key], updatedObject[key]) {
+					*changes = append(*changes, joinPath(path, key)+" changed")
+				}
+				continue
+			}
+
+			diffJSON(joinPath(path, key), oldObject[key], updatedObject[key], changes)
+		}
+		return
+	}
+
+	oldArray, oldIsArray := old.([]interface{})
+	updatedArray, updatedIsArray := updated.([]interface{})
+	if oldIsArray && updatedIsArray && len(oldArray) > 0 && len(updatedArray) > 0 {
+		for i := 0; i < len(oldArray) || i < len(updatedArray); i++ {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldArray):
+				*changes = append(*changes, fmt.Sprintf("%s added: %s", elementPath, formatJSON(updatedArray[i])))
+			case i >= len(updatedArray):
+				*changes = append(*changes, fmt.Sprintf("%s removed: %s", elementPath, formatJSON(oldArray[i])))
+			default:
+				diffJSON(elementPath, oldArray[i], updatedArray[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, updated) {
+		*changes = append(*changes, fmt.Sprintf("%s: %s -> %s", path, formatJSON(old), formatJSON(updated)))
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// Replaces the values of the secrets found in the value by a mask
+func maskSecrets(value interface{}) interface{} {
+	if object, ok := value.(map[string]interface{}); ok {
+		masked := make(map[string]interface{}, len(object))
+		for k, v := range object {
+			masked[k] = maskSecrets(v)
+			if secretSettings[k] && v != "" {
+				masked[k] = "********"
+			}
+		}
+		return masked
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		masked := make([]interface{}, len(array))
+		for i, v := range array {
+			masked[i] = maskSecrets(v)
+		}
+		return masked
+	}
+
+	return value
+}
+
+// Returns the value as JSON, with its secrets masked
+func formatJSON(value interface{}) string {
+	data, _ := json.Marshal(maskSecrets(value))
+	return string(data)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Validate checks that the configuration can be served, so that an invalid one may be
+// rejected before it replaces the configuration in use.
+func (c OA2Config) Validate() error {
+	if c.BaseURL == "" {
+		return fmt.Errorf("baseURL must be set")
+	}
+
+	flowLifetimes := map[string]Lifetimes{
+		"authCode":    c.AuthCodeCnfg.Lifetimes,
+		"implicit":    c.ImplicitCnfg.Lifetimes,
+		"ropc":        c.ROPCCnfg.Lifetimes,
+		"clientCreds": c.ClientCredsCnfg.Lifetimes,
+		"device":      c.DeviceCnfg.Lifetimes,
+	}
+
+	for flow, lifetimes := range flowLifetimes {
+		if err := lifetimes.validate(); err != nil {
+			return fmt.Errorf("%s: %s", flow, err)
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, client := range c.Clients {
+		if client.ID == "" {
+			return fmt.Errorf("clients: clientID must be set")
+		}
+
+		if ids[client.ID] {
+			return fmt.Errorf("clients: %s is registered more than once", client.ID)
+		}
+		ids[client.ID] = true
+	}
+
+	for _, client := range c.RegisteredClients() {
+		if err := client.validate(); err != nil {
+			return fmt.Errorf("client %s: %s", client.ID, err)
+		}
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("trustedProxies: %q is neither an IP address nor a CIDR range", proxy)
+		}
+	}
+
+	return nil
+}
+
+// Checks that no lifetime is negative. Those left zero take the default.
+func (l Lifetimes) validate() error {
+	if l.AccessToken < 0 || l.RefreshToken < 0 || l.RefreshTokenIdle < 0 || l.AuthCode < 0 {
+		return fmt.Errorf("lifetimes must not be negative")
+	}
+
+	return nil
+}
+
+// Checks that the client ID is free of the reserved separator and that the client uses known grant types and access token formats
+func (c Client) validate() error {
+	if strings.Contains(c.ID, ClientIDSeparator) {
+		return fmt.Errorf("client ID must not contain the reserved %q", ClientIDSeparator)
+	}
+
+	for _, grantType := range c.GrantTypes {
+		switch grantType {
+		case AuthCodeGrant, ImplicitGrant, ROPCGrant, ClientCredsGrant, DeviceGrant, RefreshTokenGrant:
+		default:
+			return fmt.Errorf("unknown grant type %q", grantType)
+		}
+	}
+
+	switch c.AccessTokenFormat {
+	case "", OpaqueAccessToken, JWTAccessToken:
+	default:
+		return fmt.Errorf("unknown access token format %q", c.AccessTokenFormat)
+	}
+
+	return c.Lifetimes.validate()
+}
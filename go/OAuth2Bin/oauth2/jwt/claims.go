@@ -0,0 +1,43 @@
+package jwt
+
+import "crypto/sha256"
+
+// Values of the "typ" header parameter
+const (
+	AccessTokenType = "at+jwt"
+	IDTokenType     = "JWT"
+)
+
+// AccessTokenClaims holds the claims of a JWT access token.
+// Refer RFC 9068 Section 2.2 (https://www.rfc-editor.org/rfc/rfc9068#section-2.2)
+type AccessTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	Expiry   int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	JWTID    string `json:"jti"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// IDTokenClaims holds the claims of an OpenID Connect ID token.
+// Refer OpenID Connect Core 1.0 Section 2 (https://openid.net/specs/openid-connect-core-1_0.html#IDToken)
+type IDTokenClaims struct {
+	Issuer          string `json:"iss"`
+	Subject         string `json:"sub"`
+	Audience        string `json:"aud"`
+	Expiry          int64  `json:"exp"`
+	IssuedAt        int64  `json:"iat"`
+	AuthTime        int64  `json:"auth_time,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+	AccessTokenHash string `json:"at_hash,omitempty"`
+}
+
+// HalfHash returns the base64url encoding of the left-most half of the SHA-256 hash of the value,
+// as used for the "at_hash" claim by the SHA-256 based algorithms RS256 and ES256.
+// Refer OpenID Connect Core 1.0 Section 3.2.2.9 (https://openid.net/specs/openid-connect-core-1_0.html#ImplicitIDToken)
+func HalfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return encode(sum[:len(sum)/2])
+}
@@ -0,0 +1,61 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"math/big"
+)
+
+// JWK represents a public JSON Web Key.
+// The members are in lexicographic order so that the JSON serialization of
+// the required members can be used to compute the JWK thumbprint.
+// Refer RFC 7517 (https://tools.ietf.org/html/rfc7517) and RFC 7638 Section 3.2
+type JWK struct {
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Kty string `json:"kty"`
+	N   string `json:"n,omitempty"`
+	Use string `json:"use,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set as served at the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the key set containing the public key of the Signer
+func (s *Signer) JWKS() JWKSet {
+	jwk := publicJWK(s.key.Public())
+	jwk.Alg = s.alg
+	jwk.Kid = s.kid
+	jwk.Use = "sig"
+
+	return JWKSet{Keys: []JWK{jwk}}
+}
+
+// Returns the JWK with only the members required for the key type
+func publicJWK(key crypto.PublicKey) JWK {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encode(key.N.Bytes()),
+			E:   encode(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   encode(key.X.FillBytes(make([]byte, size))),
+			Y:   encode(key.Y.FillBytes(make([]byte, size))),
+		}
+	}
+
+	return JWK{}
+}
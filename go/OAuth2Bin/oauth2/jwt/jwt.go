@@ -0,0 +1,257 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported JWS signing algorithms
+// Refer RFC 7518 Section 3.1 (https://tools.ietf.org/html/rfc7518#section-3.1)
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	// ErrMalformed is returned when a token is not a compact-serialized JWS
+	ErrMalformed = errors.New("malformed token")
+
+	// ErrInvalidSignature is returned when the signature or the algorithm of a token does not match the key
+	ErrInvalidSignature = errors.New("invalid token signature")
+
+	// ErrExpired is returned when the exp claim of a token is in the past
+	ErrExpired = errors.New("token has expired")
+)
+
+// Signer signs and verifies JWTs with a single private key.
+type Signer struct {
+	alg string
+	kid string
+	key crypto.Signer
+}
+
+// NewSigner generates a new key for the given algorithm and returns a Signer for it.
+// RS256 uses a 2048-bit RSA key and ES256 uses a P-256 key.
+func NewSigner(alg string) (*Signer, error) {
+	var key crypto.Signer
+	var err error
+
+	switch alg {
+	case RS256, "":
+		alg = RS256
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigner(alg, key)
+}
+
+// LoadSigner reads a PEM-encoded private key from the specified path and returns a Signer for it.
+// PKCS #1 and SEC 1 keys as well as PKCS #8 keys of either kind are supported.
+// The algorithm is derived from the type of the key.
+func LoadSigner(keyPath string) (*Signer, error) {
+	pemBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", keyPath)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return newSigner(RS256, key)
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+		}
+
+		return newSigner(ES256, key)
+	}
+
+	return nil, fmt.Errorf("unsupported key type in %s", keyPath)
+}
+
+func newSigner(alg string, key crypto.Signer) (*Signer, error) {
+	thumbprint, err := json.Marshal(publicJWK(key.Public()))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(thumbprint)
+	return &Signer{
+		alg: alg,
+		kid: base64.RawURLEncoding.EncodeToString(sum[:]),
+		key: key,
+	}, nil
+}
+
+// Algorithm returns the JWS algorithm used by the Signer
+func (s *Signer) Algorithm() string {
+	return s.alg
+}
+
+// KeyID returns the key ID of the Signer, which is the JWK thumbprint of its public key.
+// Refer RFC 7638 (https://tools.ietf.org/html/rfc7638)
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Sign serializes the claims as JSON and returns them as a compact-serialized JWS.
+// typ is set as the "typ" header parameter, eg: "at+jwt" for access tokens.
+func (s *Signer) Sign(claims interface{}, typ string) (string, error) {
+	headerBytes, err := json.Marshal(header{Alg: s.alg, Typ: typ, Kid: s.kid})
+	if err != nil {
+		return "", err
+	}
+
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerBytes) + "." + encode(claimsBytes)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, sig *big.Int
+		r, sig, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			// JWS uses the fixed-width concatenation of R and S
+			// Refer RFC 7518 Section 3.4 (https://tools.ietf.org/html/rfc7518#section-3.4)
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			sig.FillBytes(signature[32:])
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Verify checks the signature of the token and its exp claim, if present,
+// and unmarshals its claims into 'claims'.
+func (s *Signer) Verify(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+
+	headerBytes, err := decode(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	var h header
+	if json.Unmarshal(headerBytes, &h) != nil {
+		return ErrMalformed
+	}
+
+	if h.Alg != s.alg {
+		return ErrInvalidSignature
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		sig := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, sig) {
+			return ErrInvalidSignature
+		}
+	}
+
+	claimsBytes, err := decode(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	var expiry struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(claimsBytes, &expiry) != nil {
+		return ErrMalformed
+	}
+
+	if expiry.Exp != 0 && time.Now().Unix() >= expiry.Exp {
+		return ErrExpired
+	}
+
+	return json.Unmarshal(claimsBytes, claims)
+}
+
+// IsJWT returns true if the token has the shape of a compact-serialized JWS
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decode(str string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(str)
+}
@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range []string{RS256, ES256} {
+		t.Run(alg, func(t *testing.T) {
+			signer, err := NewSigner(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := AccessTokenClaims{
+				Issuer:   "https://oauth2bin.org",
+				Subject:  "oa2buser",
+				Expiry:   time.Now().Add(time.Hour).Unix(),
+				JWTID:    "AUTHCODE1234",
+				ClientID: "clientID",
+			}
+
+			token, err := signer.Sign(claims, AccessTokenType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var verified AccessTokenClaims
+			err = signer.Verify(token, &verified)
+			if err != nil {
+				t.Fatalf("Verification failed: %s", err)
+			}
+
+			if verified != claims {
+				t.Fatalf("Claims changed on verification: %+v", verified)
+			}
+
+			// Flipping a character of the signature must fail the verification
+			flipped := "A"
+			if token[len(token)-2] == 'A' {
+				flipped = "B"
+			}
+
+			tampered := token[:len(token)-2] + flipped + token[len(token)-1:]
+			if signer.Verify(tampered, &verified) != ErrInvalidSignature {
+				t.Fatal("Tampered token verified")
+			}
+
+			claims.Expiry = time.Now().Add(-time.Minute).Unix()
+			expired, _ := signer.Sign(claims, AccessTokenType)
+			if signer.Verify(expired, &verified) != ErrExpired {
+				t.Fatal("Expired token verified")
+			}
+		})
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "oa2b-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signer.Algorithm() != ES256 {
+		t.Fatalf("Expected ES256 for a P-256 key, got %s", signer.Algorithm())
+	}
+
+	jwks := signer.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != signer.KeyID() || jwks.Keys[0].Crv != "P-256" {
+		t.Fatalf("Unexpected key set: %+v", jwks)
+	}
+}
@@ -0,0 +1,48 @@
+package metrics
+
+// Default is the registry of the metrics below, which Prometheus scrapes from /metrics.
+// Only counters and histograms are needed, hence they are written by hand rather than with the Prometheus client.
+var Default = NewRegistry()
+
+// Upper bounds in seconds of the buckets of the latency of the requests
+var requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Upper bounds in seconds of the buckets of the latency of the store, which is mostly local
+var storeBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+// TokensIssued counts the access tokens issued by flow and grant type.
+// The flow is one of the flow names of the cache package, and the grant type
+// that of the token request, or "implicit" for tokens issued by the authorization endpoint.
+var TokensIssued = Default.NewCounter("oauth2bin_tokens_issued_total",
+	"Access tokens issued, by flow and grant type.", "flow", "grant_type")
+
+// TokenErrors counts the error responses of the token endpoint by their error code
+var TokenErrors = Default.NewCounter("oauth2bin_token_errors_total",
+	"Error responses of the token endpoint, by error code.", "error")
+
+// RateLimited counts the requests rejected by the rate limiter by the route of their policy
+var RateLimited = Default.NewCounter("oauth2bin_rate_limited_requests_total",
+	"Requests rejected by the rate limiter, by the route of the policy.", "route")
+
+// RequestDuration observes the latency of the requests by the route of their handler and status code
+var RequestDuration = Default.NewHistogram("oauth2bin_http_request_duration_seconds",
+	"Latency of the requests, by handler and status code.", requestBuckets, "handler", "code")
+
+// StoreDuration observes the latency of the operations of the store by operation
+var StoreDuration = Default.NewHistogram("oauth2bin_store_operation_duration_seconds",
+	"Latency of the operations of the store, by operation.", storeBuckets, "operation")
+
+// StoreErrors counts the operations of the store which failed by operation.
+// Looking up a missing field is not an error.
+var StoreErrors = Default.NewCounter("oauth2bin_store_errors_total",
+	"Operations of the store which failed, by operation.", "operation")
+
+// HousekeepingDuration observes how long the in-memory store takes to remove the expired entries.
+// Redis expires its keys by itself, hence there is no housekeeping with Redis.
+var HousekeepingDuration = Default.NewHistogram("oauth2bin_housekeeping_duration_seconds",
+	"Duration of the runs removing the expired entries of the in-memory store.", storeBuckets)
+
+// HousekeepingRemoved counts the expired entries removed by housekeeping,
+// either "field" for the fields of the flows or "rate_limit" for the rate limits
+var HousekeepingRemoved = Default.NewCounter("oauth2bin_housekeeping_removed_total",
+	"Expired entries removed from the in-memory store, by kind.", "kind")
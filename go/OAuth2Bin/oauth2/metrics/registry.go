@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content type of the Prometheus text exposition format.
+// Refer https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metrics and exposes them in the Prometheus text format
+type Registry struct {
+	mut     sync.Mutex
+	metrics []metric
+}
+
+// A metric writes its HELP and TYPE lines followed by its samples
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a counter with the name, help and label names
+func (reg *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: newDesc(name, help, "counter", labels), series: make(map[string]*counterSeries)}
+	if len(labels) == 0 {
+		c.get()
+	}
+
+	reg.register(c)
+	return c
+}
+
+// NewHistogram registers a histogram with the name, help, upper bounds of its buckets and label names.
+// The +Inf bucket is always added.
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    newDesc(name, help, "histogram", labels),
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*histogramSeries),
+	}
+	sort.Float64s(h.buckets)
+	if len(labels) == 0 {
+		h.get()
+	}
+
+	reg.register(h)
+	return h
+}
+
+func (reg *Registry) register(m metric) {
+	reg.mut.Lock()
+	defer reg.mut.Unlock()
+
+	reg.metrics = append(reg.metrics, m)
+}
+
+// Write writes every metric of the registry in the order they were registered
+func (reg *Registry) Write(w io.Writer) error {
+	reg.mut.Lock()
+	metrics := append([]metric(nil), reg.metrics...)
+	reg.mut.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+
+	return buffered.Flush()
+}
+
+// ServeHTTP serves the metrics of the registry to Prometheus
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	reg.Write(w)
+}
+
+// Name, help and label names of a metric
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func newDesc(name, help, kind string, labels []string) desc {
+	return desc{name: name, help: help, kind: kind, labels: labels}
+}
+
+// Returns the key of the series with the label values, checking that there is a value for every label
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// Writes a sample of the metric, whose name is suffixed, with the label values followed by any extra label
+func (d desc) writeSample(w io.Writer, suffix string, values []string, value float64, extra ...string) {
+	var labels []string
+	for i, name := range d.labels {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i+1])))
+	}
+
+	name := d.name + suffix
+	if len(labels) > 0 {
+		name += "{" + strings.Join(labels, ",") + "}"
+	}
+
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+// Counter is a metric whose value only goes up, e.g. the number of requests served
+type Counter struct {
+	desc
+	mut    sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Inc increments the series of the counter with the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the series of the counter with the label values
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.get(values...).value += delta
+}
+
+// Value returns the value of the series of the counter with the label values
+func (c *Counter) Value(values ...string) float64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	series, found := c.series[c.key(values)]
+	if !found {
+		return 0
+	}
+
+	return series.value
+}
+
+// Returns the series with the label values, creating it if needed. The lock must be held.
+func (c *Counter) get(values ...string) *counterSeries {
+	key := c.key(values)
+	series, found := c.series[key]
+	if !found {
+		series = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = series
+	}
+
+	return series
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.writeHeader(w)
+	for _, key := range keys {
+		series := c.series[key]
+		c.writeSample(w, "", series.values, series.value)
+	}
+}
+
+// Histogram is a metric which counts observations, e.g. request durations, in buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mut     sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // Observations per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe adds the value to the series of the histogram with the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	series := h.get(values...)
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.count++
+	series.sum += value
+}
+
+// ObserveSince adds the seconds elapsed since start to the series of the histogram with the label values
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns the number of observations of the series of the histogram with the label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	series, found := h.series[h.key(values)]
+	if !found {
+		return 0
+	}
+
+	return series.count
+}
+
+// Returns the series with the label values, creating it if needed. The lock must be held.
+func (h *Histogram) get(values ...string) *histogramSeries {
+	key := h.key(values)
+	series, found := h.series[key]
+	if !found {
+		series = &histogramSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+
+	return series
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(w)
+	for _, key := range keys {
+		series := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			h.writeSample(w, "_bucket", series.values, float64(cumulative), "le", formatFloat(bound))
+		}
+
+		h.writeSample(w, "_bucket", series.values, float64(series.count), "le", "+Inf")
+		h.writeSample(w, "_sum", series.values, series.sum)
+		h.writeSample(w, "_count", series.values, float64(series.count))
+	}
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("test_requests_total", "Requests.\nBy route.", "route", "code")
+	runs := reg.NewCounter("test_runs_total", "Runs.")
+	latency := reg.NewHistogram("test_latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+
+	requests.Inc("/token", "200")
+	requests.Add(2, `/a"b\`, "400")
+	latency.Observe(0.05, "/token")
+	latency.Observe(0.1, "/token")
+	latency.Observe(5, "/token")
+
+	var buf bytes.Buffer
+	err := reg.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_requests_total Requests.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b\\",code="400"} 2
+test_requests_total{route="/token",code="200"} 1
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 0
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/token",le="0.1"} 2
+test_latency_seconds_bucket{route="/token",le="1"} 2
+test_latency_seconds_bucket{route="/token",le="+Inf"} 3
+test_latency_seconds_sum{route="/token"} 5.15
+test_latency_seconds_count{route="/token"} 3
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected metrics:\n%s", buf.String())
+	}
+
+	if requests.Value("/token", "200") != 1 || runs.Value() != 0 || latency.Count("/token") != 3 {
+		t.Fatal("Unexpected values")
+	}
+
+	recorder := httptest.NewRecorder()
+	reg.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") || recorder.Body.String() != expected {
+		t.Fatalf("Unexpected response: %s", recorder.Body.String())
+	}
+}
+
+func TestCounterLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Missing label value accepted")
+		}
+	}()
+
+	NewRegistry().NewCounter("test_total", "Test.", "route").Inc()
+}
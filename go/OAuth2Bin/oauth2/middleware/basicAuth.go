@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"oauth2bin/oauth2/utils"
+)
+
+// BasicAuth is an implementation of Middleware which protects a route with HTTP Basic authentication.
+// Refer RFC 7617 (https://tools.ietf.org/html/rfc7617)
+//
+// Realm: the realm sent in the WWW-Authenticate challenge
+// Credentials: returns the username and password to be presented with the request, read on every request so that
+// they can be changed while the server runs. If the password is empty, the route is disabled.
+type BasicAuth struct {
+	Realm       string
+	Credentials func(r *http.Request) (username, password string)
+}
+
+// NewBasicAuth returns a new instance of BasicAuth
+func NewBasicAuth(realm string, credentials func(*http.Request) (string, string)) BasicAuth {
+	return BasicAuth{Realm: realm, Credentials: credentials}
+}
+
+// Handle implements the Middleware interface.
+// Requests without the credentials are challenged with HTTP 401, and requests to a disabled route
+// are answered with HTTP 404 as if it did not exist.
+func (ba BasicAuth) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expectedUsername, expectedPassword := ba.Credentials(r)
+		if expectedPassword == "" {
+			utils.ShowJSONError(w, r, http.StatusNotFound, utils.RequestError{
+				Error: "Not Found",
+				Desc:  "this route is disabled",
+			})
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) == 1
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1
+		if !ok || !usernameMatches || !passwordMatches {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", ba.Realm))
+			utils.ShowJSONError(w, r, http.StatusUnauthorized, utils.RequestError{
+				Error: "unauthorized",
+				Desc:  "valid credentials are required",
+			})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	password := ""
+	auth := NewBasicAuth("test", func(*http.Request) (string, string) { return "admin", password })
+	handler := auth.Handle(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Sends the request with the credentials, if any, and checks the status
+	check := func(username, pass string, status int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin", nil)
+		if username != "" {
+			req.SetBasicAuth(username, pass)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		if recorder.Code != status {
+			t.Fatalf("%s:%s: expected HTTP %d, got HTTP %d", username, pass, status, recorder.Code)
+		}
+		return recorder
+	}
+
+	// The route is disabled until the password is set
+	check("admin", "", http.StatusNotFound)
+
+	password = "secret"
+	challenge := check("", "", http.StatusUnauthorized).Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, `Basic realm="test"`) {
+		t.Fatalf("Unexpected challenge: %s", challenge)
+	}
+
+	check("admin", "wrong", http.StatusUnauthorized)
+	check("other", "secret", http.StatusUnauthorized)
+	check("admin", "secret", http.StatusOK)
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/utils"
+)
+
+// Key under which BearerAuth stores the information of the verified access token in the request context
+type tokenInfoKey struct{}
+
+// BearerAuth is an implementation of Middleware which protects a resource with OAuth 2.0 bearer tokens.
+// Refer RFC 6750 (https://tools.ietf.org/html/rfc6750)
+//
+// Realm: the realm sent in the WWW-Authenticate challenge
+// ResolveToken: maps the presented token to the one in the store, e.g. the ID of a JWT access token.
+// If nil, the token is looked up as presented.
+// RequiredScope: returns the scope the token must have been granted to access the requested resource.
+// If nil, any valid token is accepted.
+type BearerAuth struct {
+	Realm         string
+	ResolveToken  func(r *http.Request, token string) string
+	RequiredScope func(r *http.Request) []string
+}
+
+// NewBearerAuth returns a new instance of BearerAuth
+func NewBearerAuth(realm string, resolveToken func(*http.Request, string) string, requiredScope func(*http.Request) []string) BearerAuth {
+	return BearerAuth{Realm: realm, ResolveToken: resolveToken, RequiredScope: requiredScope}
+}
+
+// TokenInfoFromContext returns the information of the access token verified by BearerAuth
+func TokenInfoFromContext(ctx context.Context) (cache.TokenInfo, bool) {
+	info, ok := ctx.Value(tokenInfoKey{}).(cache.TokenInfo)
+	return info, ok
+}
+
+// Handle implements the Middleware interface.
+// The access token is read from the Authorization header, the form-encoded body or the
+// access_token query parameter, and verified against the store along with its scope.
+// The information of the token is passed on to the handler in the request context.
+func (ba BearerAuth) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := extractBearerToken(r)
+		if err != nil {
+			ba.challenge(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+
+		// Refer RFC 6750 Section 3.1: no error code is sent if the request lacks authentication,
+		// as is the case when the Authorization header uses another scheme
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", ba.Realm))
+			utils.ShowJSONError(w, r, http.StatusUnauthorized, "access token is required")
+			return
+		}
+
+		if ba.ResolveToken != nil {
+			token = ba.ResolveToken(r, token)
+		}
+
+		info, ok := cache.VerifyAccessToken(token)
+		if !ok {
+			ba.challenge(w, r, http.StatusUnauthorized, "invalid_token", "access token expired, revoked or invalid", nil)
+			return
+		}
+
+		if ba.RequiredScope != nil {
+			scope := ba.RequiredScope(r)
+			if !utils.IsScopeSubset(scope, utils.ParseScope(info.Scope)) {
+				ba.challenge(w, r, http.StatusForbidden, "insufficient_scope", "access token was not granted the required scope", scope)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenInfoKey{}, info)))
+	}
+}
+
+// Presents the error as JSON along with the WWW-Authenticate challenge carrying it.
+// The scope, if any, is the scope required to access the resource.
+// Refer RFC 6750 Section 3 (https://tools.ietf.org/html/rfc6750#section-3)
+func (ba BearerAuth) challenge(w http.ResponseWriter, r *http.Request, status int, errorCode, desc string, scope []string) {
+	challenge := fmt.Sprintf("Bearer realm=%q, error=%q, error_description=%q", ba.Realm, errorCode, desc)
+	if len(scope) > 0 {
+		challenge += fmt.Sprintf(", scope=%q", strings.Join(scope, " "))
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	utils.ShowJSONError(w, r, status, utils.RequestError{
+		Error: errorCode,
+		Desc:  desc,
+	})
+}
+
+// Returns the access token sent with the request, or an empty string if none was sent.
+// The token may be sent in exactly one of the Authorization header, the form-encoded body
+// of a request other than GET, or the access_token query parameter.
+// An Authorization header using another scheme carries no access token.
+// Refer RFC 6750 Section 2 (https://tools.ietf.org/html/rfc6750#section-2)
+func extractBearerToken(r *http.Request) (string, error) {
+	var tokens []string
+
+	// The scheme is case-insensitive. Refer RFC 7235 Section 2.1 (https://tools.ietf.org/html/rfc7235#section-2.1)
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		tokens = append(tokens, strings.TrimSpace(authorization[len("Bearer "):]))
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		r.ParseForm()
+		tokens = append(tokens, r.PostForm["access_token"]...)
+	}
+
+	tokens = append(tokens, r.URL.Query()["access_token"]...)
+
+	switch len(tokens) {
+	case 0:
+		return "", nil
+	case 1:
+		return tokens[0], nil
+	default:
+		return "", fmt.Errorf("access token must be sent using exactly one method")
+	}
+}
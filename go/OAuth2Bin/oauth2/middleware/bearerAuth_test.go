@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+)
+
+func TestBearerAuth(t *testing.T) {
+	token, err := cache.NewROPCToken("clientID", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewBearerAuth("test", nil, func(r *http.Request) []string {
+		return []string{strings.TrimPrefix(r.URL.Path, "/")}
+	})
+
+	handler := auth.Handle(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := TokenInfoFromContext(r.Context())
+		if !ok || info.ClientID != "clientID" {
+			t.Errorf("Token info not passed on to the handler: %+v", info)
+		}
+	})
+
+	// Sends the request and checks the status and the error in the WWW-Authenticate challenge
+	check := func(req *http.Request, status int, errorCode string) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+
+		challenge := recorder.Header().Get("WWW-Authenticate")
+		if recorder.Code != status || (errorCode != "" && !strings.Contains(challenge, `error="`+errorCode+`"`)) {
+			t.Fatalf("%s %s: expected HTTP %d %s, got HTTP %d with challenge: %s",
+				req.Method, req.URL, status, errorCode, recorder.Code, challenge)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/read", nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	check(req, http.StatusOK, "")
+
+	req = httptest.NewRequest(http.MethodGet, "/read?access_token="+token.AccessToken, nil)
+	check(req, http.StatusOK, "")
+
+	req = httptest.NewRequest(http.MethodPost, "/read", strings.NewReader("access_token="+token.AccessToken))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	check(req, http.StatusOK, "")
+
+	req = httptest.NewRequest(http.MethodGet, "/write?access_token="+token.AccessToken, nil)
+	check(req, http.StatusForbidden, "insufficient_scope")
+
+	req = httptest.NewRequest(http.MethodGet, "/read?access_token=unknown", nil)
+	check(req, http.StatusUnauthorized, "invalid_token")
+
+	// Refresh tokens may not be used to access resources
+	req = httptest.NewRequest(http.MethodGet, "/read?access_token="+token.RefreshToken, nil)
+	check(req, http.StatusUnauthorized, "invalid_token")
+
+	// The token may be sent using only one method
+	req = httptest.NewRequest(http.MethodGet, "/read?access_token="+token.AccessToken, nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	check(req, http.StatusBadRequest, "invalid_request")
+
+	req = httptest.NewRequest(http.MethodGet, "/read", nil)
+	req.Header.Set("Authorization", "bearer "+token.AccessToken)
+	check(req, http.StatusOK, "")
+
+	// Requests without a token, or with credentials of another scheme, are challenged without an error code
+	withBasic := httptest.NewRequest(http.MethodGet, "/read", nil)
+	withBasic.SetBasicAuth("clientID", "clientSecret")
+
+	for _, req := range []*http.Request{httptest.NewRequest(http.MethodGet, "/read", nil), withBasic} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `Bearer realm="test"` {
+			t.Fatalf("HTTP %d: unexpected response to a request without a token: %s", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+		}
+
+		if strings.Contains(recorder.Body.String(), `"error"`) {
+			t.Fatalf("Error code sent to a request without a token: %s", recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+// Separates the client ID from the faults requested through it, see ParseFaultClientID.
+// The configuration rejects client IDs containing it.
+const faultSeparator = config.ClientIDSeparator
+
+// Longest latency in milliseconds a fault may add. Faults are requested by anyone
+// through client IDs, so the time they may hold a request for is bounded.
+const maxFaultLatency = 30000
+
+// Prefix of the store keys under which the requests matching the faults requested by client IDs are counted
+const requestedFaultsKey = "OA2B_RequestedFaults"
+
+// Period over which the requests matching a fault requested by a client ID are counted.
+// The counts expire from the store, so the client IDs sent by anonymous callers do not pile up.
+const requestedFaultWindow = time.Hour
+
+// Key under which FaultInjector stores the fault applied to a request in the request context
+type faultKey struct{}
+
+// Fault describes a failure injected on purpose, to test how clients cope with it.
+// The requests it applies to are selected by Route, ClientID and GrantType, and among those by
+// FailAfter and Probability.
+//
+// Route: the routes the fault applies to, matched like the routes of rate policies. If empty, every route.
+// ClientID: the client whose requests the fault applies to. If empty, every client.
+// GrantType: the grant type of the token requests the fault applies to, e.g. "refresh_token". If empty, every request.
+// FailAfter: the number of matching requests which are let through before the fault applies
+// Probability: the probability, between 0 and 1, of the fault applying to a request. If zero, it always applies.
+// Latency: the delay in milliseconds added before the request is handled, at most 30000
+// Status: the HTTP status of the error response sent in place of handling the request.
+// If zero and Error is set, 503 for "temporarily_unavailable", 500 for "server_error" and 400 otherwise.
+// Error: the OAuth 2.0 error code of the error response. If empty and Status is set, "server_error".
+// MalformedJSON: if true, the response is cut off halfway so that it cannot be parsed
+// ExpiredTokens: if true, the access tokens issued are already expired. See FaultFromContext.
+type Fault struct {
+	Route         string  `json:"route,omitempty"`
+	ClientID      string  `json:"clientID,omitempty"`
+	GrantType     string  `json:"grantType,omitempty"`
+	FailAfter     int     `json:"failAfter,omitempty"`
+	Probability   float64 `json:"probability,omitempty"`
+	Latency       int     `json:"latency,omitempty"`
+	Status        int     `json:"status,omitempty"`
+	Error         string  `json:"error,omitempty"`
+	MalformedJSON bool    `json:"malformedJSON,omitempty"`
+	ExpiredTokens bool    `json:"expiredTokens,omitempty"`
+}
+
+// Validate checks that the fault injects a failure which can be sent
+func (f Fault) Validate() error {
+	if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+		return fmt.Errorf("status %d is not an error status", f.Status)
+	}
+
+	if f.FailAfter < 0 || f.Latency < 0 {
+		return fmt.Errorf("failAfter and latency must not be negative")
+	}
+
+	if f.Latency > maxFaultLatency {
+		return fmt.Errorf("latency must not exceed %d milliseconds", maxFaultLatency)
+	}
+
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("probability %g is not between 0 and 1", f.Probability)
+	}
+
+	if f.Latency == 0 && f.Status == 0 && f.Error == "" && !f.MalformedJSON && !f.ExpiredTokens {
+		return fmt.Errorf("fault injects no failure")
+	}
+
+	return nil
+}
+
+// Returns the status and error code of the error response sent in place of handling the request
+func (f Fault) errorResponse() (int, string) {
+	status, code := f.Status, f.Error
+	if code == "" {
+		code = "server_error"
+	}
+
+	if status == 0 {
+		switch code {
+		case "temporarily_unavailable":
+			status = http.StatusServiceUnavailable
+		case "server_error":
+			status = http.StatusInternalServerError
+		default:
+			status = http.StatusBadRequest
+		}
+	}
+
+	return status, code
+}
+
+// Checks if the fault applies to the request made by the client to the route using the grant type
+func (f Fault) matches(route, clientID, grantType string) bool {
+	if f.Route != "" && !matchRoute(f.Route, route) {
+		return false
+	}
+
+	if f.ClientID != "" && f.ClientID != clientID {
+		return false
+	}
+
+	return f.GrantType == "" || f.GrantType == grantType
+}
+
+// ParseFaultClientID splits a client ID into the ID of the registered client and the fault
+// requested through it, if any. The fault is requested by appending directives to the client ID,
+// each preceded by a semicolon, so that a client may script the failures it is to be tested against.
+// e.g. "clientID;grant_type=refresh_token;fail_after=2;error=invalid_grant"
+//
+// The directives are route, grant_type, fail_after, probability, latency, status, error,
+// malformed_json and expired_tokens, setting the respective fields of Fault.
+// The fault is nil if the client ID requests none. An error is returned if the directives are invalid.
+func ParseFaultClientID(clientID string) (string, *Fault, error) {
+	parts := strings.Split(clientID, faultSeparator)
+	if len(parts) == 1 {
+		return clientID, nil, nil
+	}
+
+	fault := &Fault{}
+	for _, directive := range parts[1:] {
+		name, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, value = directive[:i], directive[i+1:]
+		}
+
+		var err error
+		switch name {
+		case "route":
+			fault.Route = value
+		case "grant_type":
+			fault.GrantType = value
+		case "fail_after":
+			fault.FailAfter, err = strconv.Atoi(value)
+		case "probability":
+			fault.Probability, err = strconv.ParseFloat(value, 64)
+		case "latency":
+			fault.Latency, err = strconv.Atoi(value)
+		case "status":
+			fault.Status, err = strconv.Atoi(value)
+		case "error":
+			fault.Error = value
+		case "malformed_json":
+			fault.MalformedJSON = true
+		case "expired_tokens":
+			fault.ExpiredTokens = true
+		default:
+			return "", nil, fmt.Errorf("unknown fault directive: %s", name)
+		}
+
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid value for fault directive %s: %s", name, value)
+		}
+	}
+
+	err := fault.Validate()
+	if err != nil {
+		return "", nil, err
+	}
+
+	fault.ClientID = parts[0]
+	return parts[0], fault, nil
+}
+
+// FaultFromContext returns the fault FaultInjector applied to the request, if it is to be applied by the handler.
+// That is the case of ExpiredTokens, which the handlers issuing tokens must honour.
+func FaultFromContext(ctx context.Context) (Fault, bool) {
+	fault, ok := ctx.Value(faultKey{}).(Fault)
+	return fault, ok
+}
+
+// A fault along with the number of requests it has matched
+type faultState struct {
+	fault Fault
+	hits  int
+}
+
+// FaultInjector is an implementation of Middleware which injects the faults set through SetFaults,
+// along with those requested by the client ID of each request. See ParseFaultClientID.
+// The client ID of a request which requests a fault is replaced by that of the registered client
+// before the request is handled.
+//
+// Store: where the requests matching the faults requested by client IDs are counted.
+// If nil, the store used by the flows is used.
+type FaultInjector struct {
+	Store cache.Store
+
+	mut    sync.Mutex
+	faults []*faultState
+
+	// Incremented by SetFaults so that the counts of requested faults start over
+	generation int
+}
+
+// NewFaultInjector returns a new instance of FaultInjector which injects no faults but those
+// requested by client IDs
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// Faults returns the faults set through SetFaults
+func (fi *FaultInjector) Faults() []Fault {
+	fi.mut.Lock()
+	defer fi.mut.Unlock()
+
+	faults := make([]Fault, len(fi.faults))
+	for i, state := range fi.faults {
+		faults[i] = state.fault
+	}
+
+	return faults
+}
+
+// SetFaults replaces the faults injected, starting over the counts of requests for FailAfter.
+// The first of the faults which applies to a request is injected.
+func (fi *FaultInjector) SetFaults(faults []Fault) error {
+	states := make([]*faultState, len(faults))
+	for i, fault := range faults {
+		err := fault.Validate()
+		if err != nil {
+			return err
+		}
+
+		states[i] = &faultState{fault: fault}
+	}
+
+	fi.mut.Lock()
+	defer fi.mut.Unlock()
+
+	fi.faults = states
+	fi.generation++
+	return nil
+}
+
+// Handle implements the Middleware interface.
+// The latency of the fault is added first, then either the error response is sent,
+// or the request is handled with its response cut off or its tokens expired as set by the fault.
+func (fi *FaultInjector) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fault, err := fi.match(r)
+		if err != nil {
+			utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+				Error: "invalid_request",
+				Desc:  err.Error(),
+			})
+			return
+		}
+
+		if fault == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(time.Duration(fault.Latency) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fault.Status != 0 || fault.Error != "" {
+			status, code := fault.errorResponse()
+			utils.ShowJSONError(w, r, status, utils.RequestError{
+				Error: code,
+				Desc:  "fault injected by OAuth 2.0 Bin",
+			})
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), faultKey{}, *fault))
+		if !fault.MalformedJSON {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		buffer := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		handler.ServeHTTP(buffer, r)
+
+		body := buffer.body.Bytes()
+		w.Header().Del("Content-Length")
+		w.WriteHeader(buffer.status)
+		w.Write(body[:len(body)/2])
+	}
+}
+
+// Returns the fault to be applied to the request, if any
+func (fi *FaultInjector) match(r *http.Request) (*Fault, error) {
+	requestedID := requestClientID(r)
+	clientID, requested, err := ParseFaultClientID(requestedID)
+	if err != nil {
+		return nil, err
+	}
+
+	if requested != nil {
+		setRequestClientID(r, requestedID, clientID)
+	}
+
+	grantType := requestGrantType(r)
+	if requested != nil && requested.matches(r.URL.Path, clientID, grantType) && fi.requestedApplies(requestedID, r.URL.Path, requested) {
+		return requested, nil
+	}
+
+	fi.mut.Lock()
+	defer fi.mut.Unlock()
+
+	for _, state := range fi.faults {
+		if state.applies(r.URL.Path, clientID, grantType) {
+			return &state.fault, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Checks if the fault applies to the request, counting the request if it matches
+func (s *faultState) applies(route, clientID, grantType string) bool {
+	if !s.fault.matches(route, clientID, grantType) {
+		return false
+	}
+
+	s.hits++
+	if s.hits <= s.fault.FailAfter {
+		return false
+	}
+
+	return s.fault.occurs()
+}
+
+// Checks if the fault requested by the client ID applies to a request to the route it matches,
+// counting the request in the store
+func (fi *FaultInjector) requestedApplies(requestedID, route string, fault *Fault) bool {
+	store := fi.Store
+	if store == nil {
+		store = cache.CurrentStore()
+	}
+
+	fi.mut.Lock()
+	key := fmt.Sprintf("%s:%d:%s:%s", requestedFaultsKey, fi.generation, route, requestedID)
+	fi.mut.Unlock()
+
+	result, err := store.FixedWindow(key, fault.FailAfter, requestedFaultWindow)
+	if err != nil {
+		// not injecting the fault since there may be an issue with the store
+		return false
+	}
+
+	return !result.Allowed && fault.occurs()
+}
+
+// Checks if the fault occurs, given its probability
+func (f Fault) occurs() bool {
+	return f.Probability == 0 || rand.Float64() < f.Probability
+}
+
+// Returns the grant type of a token request
+func requestGrantType(r *http.Request) string {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	return peekPostForm(r).Get("grant_type")
+}
+
+// Replaces the client ID of the request, wherever it was sent, so that the handler sees the registered client
+func setRequestClientID(r *http.Request, old, clientID string) {
+	if username, password, ok := r.BasicAuth(); ok {
+		if unescaped, err := url.QueryUnescape(username); err == nil && unescaped == old {
+			r.SetBasicAuth(clientID, password)
+		}
+	}
+
+	query := r.URL.Query()
+	if query.Get("client_id") == old {
+		query.Set("client_id", clientID)
+		r.URL.RawQuery = query.Encode()
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		form := peekPostForm(r)
+		if form.Get("client_id") == old {
+			form.Set("client_id", clientID)
+			body := form.Encode()
+			r.Body = ioutil.NopCloser(strings.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+	}
+}
+
+// An http.ResponseWriter which keeps the response to be written later
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
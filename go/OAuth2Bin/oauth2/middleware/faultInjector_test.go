@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+)
+
+// Returns a handler behind the fault injector which responds with the client ID it sees
+// and whether the access tokens it issues are to be expired
+func newFaultyHandler(fi *FaultInjector) http.HandlerFunc {
+	return fi.Handle(func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.FormValue("client_id")
+		}
+
+		fault, _ := FaultFromContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"clientID": clientID, "expired": fault.ExpiredTokens})
+	})
+}
+
+// Sends a token request with the grant type, authenticated with the client ID
+func sendTokenRequest(handler http.HandlerFunc, clientID, grantType string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/token", strings.NewReader(url.Values{"grant_type": {grantType}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(url.QueryEscape(clientID), "clientSecret")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestParseFaultClientID(t *testing.T) {
+	clientID, fault, err := ParseFaultClientID("clientID")
+	if err != nil || clientID != "clientID" || fault != nil {
+		t.Fatalf("Unexpected fault for a plain client ID: %v %v", fault, err)
+	}
+
+	clientID, fault, err = ParseFaultClientID("clientID;grant_type=refresh_token;fail_after=2;error=invalid_grant;latency=10")
+	expected := Fault{ClientID: "clientID", GrantType: "refresh_token", FailAfter: 2, Error: "invalid_grant", Latency: 10}
+	if err != nil || clientID != "clientID" || fault == nil || *fault != expected {
+		t.Fatalf("Unexpected fault: %+v %v", fault, err)
+	}
+
+	for _, invalid := range []string{"clientID;", "clientID;fail_after=two;status=500", "clientID;status=200", "clientID;probability=2;malformed_json", "clientID;route=/token", "clientID;latency=30001"} {
+		if _, _, err := ParseFaultClientID(invalid); err == nil {
+			t.Fatalf("Invalid fault accepted: %s", invalid)
+		}
+	}
+}
+
+func TestFaultErrorResponse(t *testing.T) {
+	fi := NewFaultInjector()
+	handler := newFaultyHandler(fi)
+
+	expected := map[string]int{
+		"clientID;error=temporarily_unavailable": http.StatusServiceUnavailable,
+		"clientID;status=502":                    http.StatusBadGateway,
+		"clientID;error=invalid_grant":           http.StatusBadRequest,
+	}
+
+	for clientID, status := range expected {
+		w := sendTokenRequest(handler, clientID, "client_credentials")
+		if w.Code != status || !strings.Contains(w.Body.String(), "fault injected") {
+			t.Fatalf("HTTP %d: unexpected response for %s: %s", w.Code, clientID, w.Body.String())
+		}
+	}
+
+	// An invalid fault is reported to the client
+	w := sendTokenRequest(handler, "clientID;magic", "client_credentials")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_request") {
+		t.Fatalf("HTTP %d: invalid fault not reported: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFaultRequestHandled(t *testing.T) {
+	fi := NewFaultInjector()
+	handler := newFaultyHandler(fi)
+
+	// The handler sees the registered client along with the fault it is to apply
+	start := time.Now()
+	w := sendTokenRequest(handler, "clientID;latency=50;expired_tokens", "client_credentials")
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("Latency not added")
+	}
+
+	if w.Code != http.StatusOK || w.Body.String() != "{\"clientID\":\"clientID\",\"expired\":true}\n" {
+		t.Fatalf("HTTP %d: unexpected response: %s", w.Code, w.Body.String())
+	}
+
+	// The client ID is replaced wherever it is sent
+	r := httptest.NewRequest("POST", "/token?client_id=clientID%3Bexpired_tokens", strings.NewReader("client_id=clientID%3Bexpired_tokens&code=code"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	fi.Handle(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Query().Get("client_id"), " ", r.PostFormValue("client_id"), " ", r.PostFormValue("code"))
+	})(w, r)
+
+	if w.Body.String() != "clientID clientID code" {
+		t.Fatalf("Client ID not replaced: %s", w.Body.String())
+	}
+
+	// The response is cut off
+	w = sendTokenRequest(handler, "clientID;malformed_json", "client_credentials")
+	var response map[string]interface{}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) == nil {
+		t.Fatalf("HTTP %d: response not malformed: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFaultSelection(t *testing.T) {
+	fi := NewFaultInjector()
+	handler := newFaultyHandler(fi)
+
+	// Refresh requests fail after two uses, other grants are unaffected
+	clientID := "clientID;grant_type=refresh_token;fail_after=2;error=invalid_grant"
+	for i := 0; i < 4; i++ {
+		if w := sendTokenRequest(handler, clientID, "authorization_code"); w.Code != http.StatusOK {
+			t.Fatalf("HTTP %d: fault applied to another grant", w.Code)
+		}
+
+		w := sendTokenRequest(handler, clientID, "refresh_token")
+		if (i < 2) != (w.Code == http.StatusOK) {
+			t.Fatalf("HTTP %d: unexpected response to refresh request %d", w.Code, i+1)
+		}
+	}
+
+	// Faults set on the injector apply to the requests they match
+	err := fi.SetFaults([]Fault{{Route: "/token", ClientID: "other", Status: 500}, {Route: "/introspect", Status: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := sendTokenRequest(handler, "other", "client_credentials"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("HTTP %d: fault not applied", w.Code)
+	}
+
+	if w := sendTokenRequest(handler, "clientID", "client_credentials"); w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: fault applied to another client", w.Code)
+	}
+
+	if fi.SetFaults([]Fault{{Status: 200}}) == nil || len(fi.Faults()) != 2 {
+		t.Fatal("Invalid faults set")
+	}
+
+	// A fault with probability 1 always applies, those set on the injector start over when replaced
+	fi.SetFaults([]Fault{{Probability: 1, Error: "temporarily_unavailable"}})
+	for i := 0; i < 10; i++ {
+		if w := sendTokenRequest(handler, "clientID", "client_credentials"); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("HTTP %d: fault not applied", w.Code)
+		}
+	}
+
+	fi.SetFaults(nil)
+	if w := sendTokenRequest(handler, "clientID", "client_credentials"); w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: fault applied once removed", w.Code)
+	}
+}
+
+func TestRequestedFaultCounts(t *testing.T) {
+	store := cache.NewMemoryStore()
+	defer store.Close()
+
+	// The requests are counted in the store, across the injectors sharing it
+	first, second := newFaultyHandler(&FaultInjector{Store: store}), newFaultyHandler(&FaultInjector{Store: store})
+	clientID := "clientID;fail_after=2;status=503"
+	for i, handler := range []http.HandlerFunc{first, second, first, second} {
+		w := sendTokenRequest(handler, clientID, "client_credentials")
+		if (i < 2) != (w.Code == http.StatusOK) {
+			t.Fatalf("HTTP %d: unexpected response to request %d", w.Code, i+1)
+		}
+	}
+
+	// Each client ID is counted on its own, and the counts start over once the faults are replaced
+	fi := &FaultInjector{Store: store}
+	handler := newFaultyHandler(fi)
+	if w := sendTokenRequest(handler, "other;fail_after=1;status=503", "client_credentials"); w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: fault applied before its count", w.Code)
+	}
+
+	if w := sendTokenRequest(handler, "other;fail_after=1;status=503", "client_credentials"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("HTTP %d: fault not applied", w.Code)
+	}
+
+	fi.SetFaults(nil)
+	if w := sendTokenRequest(handler, "other;fail_after=1;status=503", "client_credentials"); w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: count not started over", w.Code)
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"strings"
 	"text/template"
 )
 
@@ -16,10 +17,12 @@ func NewNotFoundMiddleware(pattern string) NotFoundMiddleware {
 	return NotFoundMiddleware{URLPattern: pattern}
 }
 
-// Handle checks if the request's path matches URLPattern
+// Handle checks if the request's path matches URLPattern.
+// As with http.ServeMux, a pattern ending in a slash, other than the root "/",
+// also matches the paths under it.
 func (nfm NotFoundMiddleware) Handle(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != nfm.URLPattern {
+		if !nfm.matches(r.URL.Path) {
 			// Serve the 404 page
 			tmpl, err := template.ParseFiles(
 				"public/templates/404.html",
@@ -41,3 +44,12 @@ func (nfm NotFoundMiddleware) Handle(handler http.HandlerFunc) http.HandlerFunc
 		handler.ServeHTTP(w, r)
 	}
 }
+
+// Returns true if the path matches URLPattern
+func (nfm NotFoundMiddleware) matches(path string) bool {
+	if nfm.URLPattern != "/" && strings.HasSuffix(nfm.URLPattern, "/") {
+		return strings.HasPrefix(path, nfm.URLPattern)
+	}
+
+	return path == nfm.URLPattern
+}
@@ -70,7 +70,7 @@ func testContentType(t *testing.T, handler http.HandlerFunc) {
 	postReq.Header.Add("Content-Type", "application/json")
 
 	handler.ServeHTTP(recorder, postReq)
-	if recorder.Code == http.StatusBadRequest {
+	if recorder.Code != http.StatusBadRequest {
 		t.Fatal("Non application/x-www-form-urlencoded request accepted by PostFormValidator middleware")
 	}
 }
@@ -1,52 +1,155 @@
 package middleware
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/metrics"
+)
+
+// Rate limiting algorithms
+const (
+	// FixedWindow counts the requests over a window which starts with the first request
+	FixedWindow = "fixed_window"
+
+	// SlidingLog counts the requests made over the last window
+	SlidingLog = "sliding_log"
+
+	// TokenBucket allows bursts of up to 'Limit' requests and refills at 'Limit' requests per window
+	TokenBucket = "token_bucket"
+)
+
+// Keys by which requests are counted
+const (
+	// KeyIP counts the requests of each client IP address
+	KeyIP = "ip"
+
+	// KeyClientID counts the requests of each OAuth 2.0 client, or of each IP address for
+	// requests which do not identify their client. The client ID is read before the client
+	// is authenticated, hence a caller may spend the budget of another client or dodge its own
+	// by changing the ID it sends. It is meant for private deployments rather than the public bin.
+	KeyClientID = "client_id"
 
-	"github.com/gomodule/redigo/redis"
+	// KeyForwarded counts the requests of each IP address found in the X-Forwarded-For
+	// header, which is trusted only if sent by one of the trusted proxies
+	KeyForwarded = "forwarded"
 )
 
+// Prefix of the store keys under which the requests are counted
+const rateLimitsKey = "OA2B_RateLimits"
+
+// Size in bytes of the largest body read before the request is handled, e.g. for the client ID it holds.
+// Larger bodies are left for the handler to read and are treated as holding no parameters.
+const maxPeekedBodySize = 64 << 10
+
+// Returned when the body of a request is too large to be read before it is handled
+var errBodyTooLarge = errors.New("request body too large")
+
 // RatePolicy represents the rate limiting policy
 // for a specific route.
 //
-// Route: the server route to apply the policy to
+// Route: the server route to apply the policy to. A route ending with "*" matches every
+// route starting with the rest of it, and other wildcards are matched as by path.Match.
 // Limit: the number of API calls allowed
 // Minutes: the duration in minutes over which 'Limit' is imposed
+// Algorithm: one of FixedWindow (default), SlidingLog or TokenBucket
+// Key: one of KeyIP (default), KeyClientID or KeyForwarded
+// GrantType: if set, the policy only applies to the token requests of the grant type,
+// in place of the policy of the route, e.g. so that devices polling the token endpoint get a budget of their own
 type RatePolicy struct {
-	Route   string `json:"route"`
-	Limit   int    `json:"limit"`
-	Minutes int    `json:"minutes"`
+	Route     string `json:"route"`
+	Limit     int    `json:"limit"`
+	Minutes   int    `json:"minutes"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Key       string `json:"key,omitempty"`
+	GrantType string `json:"grantType,omitempty"`
+}
+
+// Name identifies the policy by its route, along with its grant type if any
+func (p RatePolicy) Name() string {
+	if p.GrantType == "" {
+		return p.Route
+	}
+
+	return p.Route + "?grant_type=" + p.GrantType
+}
+
+// Validate checks that the policy limits a route using a known algorithm and key
+func (p RatePolicy) Validate() error {
+	// A limit of zero would leave a token bucket without a refill rate
+	if p.Route == "" || p.Limit <= 0 || p.Minutes <= 0 {
+		return fmt.Errorf("policy for route %q must have a positive limit over a positive number of minutes", p.Route)
+	}
+
+	switch p.Algorithm {
+	case "", FixedWindow, SlidingLog, TokenBucket:
+	default:
+		return fmt.Errorf("unknown rate limiting algorithm %q for route %q", p.Algorithm, p.Route)
+	}
+
+	switch p.Key {
+	case "", KeyIP, KeyClientID, KeyForwarded:
+	default:
+		return fmt.Errorf("unknown rate limiting key %q for route %q", p.Key, p.Route)
+	}
+
+	return nil
 }
 
 // RateLimiter is an implementation of Middleware.
 // It holds a list of policies that are checked
-// when the CheckList mothod is invoked.
+// when the Handle method is invoked.
+//
+// Store: where the hits are counted. If nil, the store used by the flows is used.
+// TrustedProxies: the IP addresses or CIDR ranges of the proxies whose
+// X-Forwarded-For header is trusted by policies keyed by KeyForwarded
 type RateLimiter struct {
-	Policies []RatePolicy
+	Policies       []RatePolicy
+	Store          cache.Store
+	TrustedProxies []string
 }
 
-// Handle checks if the client is within the limits enforced by the policies
-// and returns the appropriate boolean value.
+// Handle checks if the client is within the limits enforced by the policy of the route.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are sent on every
+// response to a request counted under a policy, along with Retry-After once the limit has been exceeded.
+// Requests to routes without a policy, and those let through because the store could not count them,
+// are not limited, hence their responses carry none of these headers.
 func (rl RateLimiter) Handle(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		policy := rl.getRatePolicy(r.URL.Path)
+		policy := rl.getRatePolicy(r.URL.Path, rl.grantType(r))
 		if policy == nil {
 			// letting this request pass since no policies are set
 			handler.ServeHTTP(w, r)
 			return
 		}
 
-		hits, err := setHit(policy, r.RemoteAddr)
+		result, err := rl.setHit(policy, rl.requestKey(policy, r))
 		if err != nil {
-			// letting this request pass since there may be an issue with Redis
+			// letting this request pass since there may be an issue with the store
 			handler.ServeHTTP(w, r)
 			return
 		}
 
-		if hits > policy.Limit {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			metrics.RateLimited.Inc(policy.Route)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
 			showError(policy, w, r)
 		} else {
 			handler.ServeHTTP(w, r)
@@ -54,42 +157,238 @@ func (rl RateLimiter) Handle(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// Searches the policies based on the route
-func (rl RateLimiter) getRatePolicy(route string) *RatePolicy {
+// ReloadableRateLimiter is an implementation of Middleware which enforces the policies of a RateLimiter
+// that may be replaced while requests are being handled, e.g. when the policies are reloaded.
+// Every request is checked against the RateLimiter set when it arrives.
+type ReloadableRateLimiter struct {
+	current atomic.Value
+}
+
+// NewReloadableRateLimiter returns a new instance of ReloadableRateLimiter which enforces the limiter
+func NewReloadableRateLimiter(limiter RateLimiter) *ReloadableRateLimiter {
+	rl := &ReloadableRateLimiter{}
+	rl.Set(limiter)
+	return rl
+}
+
+// Limiter returns the RateLimiter being enforced
+func (rl *ReloadableRateLimiter) Limiter() RateLimiter {
+	return rl.current.Load().(RateLimiter)
+}
+
+// Set replaces the RateLimiter being enforced
+func (rl *ReloadableRateLimiter) Set(limiter RateLimiter) {
+	rl.current.Store(limiter)
+}
+
+// Handle implements the Middleware interface
+func (rl *ReloadableRateLimiter) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rl.Limiter().Handle(handler)(w, r)
+	}
+}
+
+// Searches the policies based on the route and the grant type of the request, if any.
+// A policy set for the grant type is preferred, then one set for the exact route,
+// followed by the longest matching pattern.
+func (rl RateLimiter) getRatePolicy(route, grantType string) *RatePolicy {
+	var match *RatePolicy
+	for i, policy := range rl.Policies {
+		if policy.GrantType != "" && policy.GrantType != grantType {
+			continue
+		}
+
+		if (route == policy.Route || matchRoute(policy.Route, route)) && (match == nil || policy.preferredTo(*match, route)) {
+			match = &rl.Policies[i]
+		}
+	}
+
+	return match
+}
+
+// Checks if the policy is preferred to another policy which also matches the route
+func (p RatePolicy) preferredTo(other RatePolicy, route string) bool {
+	if (p.GrantType != "") != (other.GrantType != "") {
+		return p.GrantType != ""
+	}
+
+	if (p.Route == route) != (other.Route == route) {
+		return p.Route == route
+	}
+
+	return len(p.Route) > len(other.Route)
+}
+
+// Returns the grant type of the request if any of the policies is set for a grant type,
+// so that the bodies of requests are only read when needed
+func (rl RateLimiter) grantType(r *http.Request) string {
 	for _, policy := range rl.Policies {
-		if route == policy.Route {
-			return &policy
+		if policy.GrantType != "" {
+			return requestGrantType(r)
 		}
 	}
 
-	return nil
+	return ""
 }
 
-// TODO: try to use goroutines for Redis calls
-// Registers a new hit for the route from the IP in Redis.
-// Returns the current hit count or an error.
-func setHit(policy *RatePolicy, ip string) (int, error) {
-	conn := cache.NewConn()
-	defer cache.CloseConn(conn)
+// Checks if the route matches the pattern of a policy
+func matchRoute(pattern, route string) bool {
+	if strings.HasSuffix(pattern, "*") && !strings.ContainsAny(pattern[:len(pattern)-1], "*?[") {
+		return strings.HasPrefix(route, pattern[:len(pattern)-1])
+	}
+
+	matched, err := path.Match(pattern, route)
+	return err == nil && matched
+}
+
+// Registers a new hit for the route by the key in the store using the algorithm of the policy.
+// Returns the outcome or an error.
+func (rl RateLimiter) setHit(policy *RatePolicy, key string) (cache.RateResult, error) {
+	store := rl.Store
+	if store == nil {
+		store = cache.CurrentStore()
+	}
+
+	window := time.Duration(policy.Minutes) * time.Minute
+	switch policy.Algorithm {
+	case SlidingLog:
+		return store.SlidingLog(rateLimitKey(SlidingLog, policy, key), policy.Limit, window)
+	case TokenBucket:
+		return store.TokenBucket(rateLimitKey(TokenBucket, policy, key), policy.Limit, window)
+	default:
+		return store.FixedWindow(rateLimitKey(FixedWindow, policy, key), policy.Limit, window)
+	}
+}
+
+// Returns the store key under which the requests by the key are counted for the policy
+func rateLimitKey(algorithm string, policy *RatePolicy, key string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", rateLimitsKey, algorithm, policy.Name(), key)
+}
+
+// Returns the key by which the request is counted under the policy
+func (rl RateLimiter) requestKey(policy *RatePolicy, r *http.Request) string {
+	switch policy.Key {
+	case KeyClientID:
+		if clientID := requestClientID(r); clientID != "" {
+			return "client:" + clientID
+		}
+	case KeyForwarded:
+		return "ip:" + rl.forwardedIP(r)
+	}
 
-	key := fmt.Sprintf("%s:%s", policy.Route, ip)
-	res, err := redis.String(conn.Do("GET", key))
+	return "ip:" + remoteIP(r)
+}
 
-	// if key exists, increment
+// Returns the IP address of the peer, without the port of the connection
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return redis.Int(conn.Do("INCR", key))
-		// else, set key with value 1 and set TTL according to policy
-	} else if res == "" && err == redis.ErrNil {
-		res, err = redis.String(conn.Do("SET", key, 1, "EX", policy.Minutes*60))
-		if res == "OK" {
-			return 1, nil
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Returns the IP address of the client as found in the X-Forwarded-For header if the peer is a
+// trusted proxy. The addresses are read from the right, skipping those of trusted proxies, so that
+// addresses prepended by the client cannot be used to evade the limit.
+func (rl RateLimiter) forwardedIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !rl.isTrustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
 		}
+
+		ip = hop
+		if !rl.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Checks if the IP address is one of the trusted proxies or within one of their CIDR ranges
+func (rl RateLimiter) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range rl.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the client ID sent using HTTP Basic authentication,
+// in the query or in the form-encoded body, if any
+func requestClientID(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok {
+		clientID, err := url.QueryUnescape(username)
+		if err == nil && clientID != "" {
+			return clientID
+		}
+	}
+
+	if clientID := r.URL.Query().Get("client_id"); clientID != "" {
+		return clientID
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		return peekPostForm(r).Get("client_id")
+	}
+
+	return ""
+}
+
+// Returns the parameters of the form-encoded body of the request,
+// leaving the body to be read again by the handler
+func peekPostForm(r *http.Request) url.Values {
+	body, err := peekBody(r)
+	if err != nil {
+		return nil
+	}
+
+	values, _ := url.ParseQuery(string(body))
+	return values
+}
+
+// Returns the body of the request, leaving it to be read again by the handler.
+// Returns errBodyTooLarge without reading further if the body exceeds maxPeekedBodySize.
+func peekBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPeekedBodySize+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err == nil && len(body) > maxPeekedBodySize {
+		err = errBodyTooLarge
+	}
+
+	return body, err
+}
+
+// Rounds the duration up to whole seconds, as sent in the headers
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
 	}
 
-	return -1, nil
+	return int(math.Ceil(d.Seconds()))
 }
 
 func showError(policy *RatePolicy, w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusTooManyRequests)
-	fmt.Fprintf(w, "You have exceeded the rate limit of %d requests per %d minute(s) on this route.\ns", policy.Limit, policy.Minutes)
+	fmt.Fprintf(w, "You have exceeded the rate limit of %d requests per %d minute(s) on this route.\n", policy.Limit, policy.Minutes)
 }
@@ -2,13 +2,31 @@ package middleware
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
-	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/metrics"
 )
 
+// Sends a request to the handler from the given remote address
+func sendLimited(handler http.HandlerFunc, r *http.Request, remoteAddr string) *httptest.ResponseRecorder {
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func newLimitedHandler(limiter Middleware) http.HandlerFunc {
+	return limiter.Handle(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello from OAuth 2.0 Bin!")
+	})
+}
+
 func TestLimiterHandle(t *testing.T) {
 	policies := make([]RatePolicy, 1)
 	policies[0] = RatePolicy{
@@ -17,50 +35,215 @@ func TestLimiterHandle(t *testing.T) {
 		Minutes: 1,
 	}
 
-	limiter := RateLimiter{Policies: policies}
-	http.HandleFunc("/", limiter.Handle(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Hello from OAuth 2.0 Bin!")
-	}))
-	go http.ListenAndServe(":8081", nil)
-
-	time.Sleep(3 * time.Second)
-
-	// Go creates a separate TCP connection for every HTTP request
-	// if we use http.Get(url). This creates a problem in our case since
-	// OA2B sees a different port number for every request thereby allowing
-	// it to pass. Hence, to properly test out the rate-limiting middleware, we
-	// use a custom single client for making requests which keeps the
-	// TCP connection alive between requests.
-	//
-	// Reference: https://awmanoj.github.io/tech/2016/12/16/keep-alive-http-requests-in-golang/
-	transport := &http.Transport{
-		MaxIdleConnsPerHost: 1024,
-		TLSHandshakeTimeout: 0 * time.Second,
-	}
-	client := &http.Client{Transport: transport}
-
-	// We send policy limit - 1 requests in this loop, which should exhoust the limit.
+	limiter := RateLimiter{Policies: policies, Store: cache.NewMemoryStore()}
+	handler := newLimitedHandler(limiter)
+
+	// Every request is sent from a different port, as it would be over a new connection,
+	// since the requests of a client are counted by its IP address alone.
 	for i := 0; i < policies[0].Limit; i++ {
-		res, err := client.Get("http://localhost:8081")
-		if err != nil || res.StatusCode != 200 {
-			t.Fatalf("request failed\n")
+		w := sendLimited(handler, httptest.NewRequest("GET", "/", nil), fmt.Sprintf("192.0.2.1:%d", 40000+i))
+		if w.Code != 200 {
+			t.Fatalf("HTTP %d: request failed\n", w.Code)
 		}
 
-		io.Copy(ioutil.Discard, res.Body)
-		defer res.Body.Close()
-		t.Logf("HTTP %d: request succeeded\n", res.StatusCode)
+		if w.Header().Get("RateLimit-Limit") != "50" || w.Header().Get("RateLimit-Remaining") != fmt.Sprint(policies[0].Limit-i-1) {
+			t.Fatalf("Unexpected rate limit headers: %v", w.Header())
+		}
 	}
 
 	// This request is made beyond the prescribed limit and
 	// must thus give HTTP 429 status code.
-	res, err := client.Get("http://localhost:8081")
-	if err != nil {
-		t.Fatalf("request failed\n")
+	limited := metrics.RateLimited.Value("/")
+	w := sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:50000")
+	if w.Code != 429 {
+		t.Fatalf("HTTP %d: request allowed beyond policy limit\n", w.Code)
+	}
+
+	if metrics.RateLimited.Value("/") != limited+1 {
+		t.Fatal("Limited request not counted")
+	}
+
+	if w.Header().Get("Retry-After") != "60" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Unexpected rate limit headers: %v", w.Header())
+	}
+
+	// Other clients are not limited
+	w = sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.2:40000")
+	if w.Code != 200 {
+		t.Fatalf("HTTP %d: request of another client limited\n", w.Code)
+	}
+}
+
+func TestLimiterAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{FixedWindow, SlidingLog, TokenBucket} {
+		limiter := RateLimiter{
+			Policies: []RatePolicy{{Route: "/", Limit: 2, Minutes: 1, Algorithm: algorithm}},
+			Store:    cache.NewMemoryStore(),
+		}
+		handler := newLimitedHandler(limiter)
+
+		for i := 0; i < 2; i++ {
+			w := sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:40000")
+			if w.Code != 200 {
+				t.Fatalf("%s: HTTP %d: request failed\n", algorithm, w.Code)
+			}
+		}
+
+		w := sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:40000")
+		if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: HTTP %d: request allowed beyond policy limit\n", algorithm, w.Code)
+		}
+	}
+}
+
+func TestRatePolicyValidate(t *testing.T) {
+	valid := RatePolicy{Route: "/token", Limit: 5, Minutes: 60, Algorithm: TokenBucket, Key: KeyClientID}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Valid policy rejected: %s", err)
+	}
+
+	for _, policy := range []RatePolicy{
+		{Route: "/token", Limit: 0, Minutes: 60, Algorithm: TokenBucket},
+		{Route: "/token", Limit: 0, Minutes: 60},
+		{Route: "/token", Limit: 5, Minutes: 0},
+		{Limit: 5, Minutes: 60},
+		{Route: "/token", Limit: 5, Minutes: 60, Algorithm: "leaky_bucket"},
+		{Route: "/token", Limit: 5, Minutes: 60, Key: "user"},
+	} {
+		if policy.Validate() == nil {
+			t.Fatalf("Invalid policy accepted: %+v", policy)
+		}
+	}
+}
+
+func TestLimiterRouteMatching(t *testing.T) {
+	limiter := RateLimiter{Policies: []RatePolicy{
+		{Route: "/api/*", Limit: 10, Minutes: 1},
+		{Route: "/api/scoped/*", Limit: 20, Minutes: 1},
+		{Route: "/api/me", Limit: 30, Minutes: 1},
+		{Route: "/clients/*/secret", Limit: 40, Minutes: 1},
+		{Route: "/token", Limit: 50, Minutes: 1},
+		{Route: "/*", Limit: 60, Minutes: 1, GrantType: "refresh_token"},
+	}}
+
+	tests := []struct {
+		route     string
+		grantType string
+		limit     int
+	}{
+		{"/api/me", "", 30},
+		{"/api/scoped/item", "", 20},
+		{"/api/other", "", 10},
+		{"/clients/abc/secret", "", 40},
+		{"/clients/abc/other", "", 0},
+		{"/token", "", 50},
+		{"/token", "password", 50},
+		{"/token", "refresh_token", 60},
+		{"/introspect", "", 0},
+	}
+
+	for _, test := range tests {
+		policy := limiter.getRatePolicy(test.route, test.grantType)
+		if test.limit == 0 && policy != nil {
+			t.Fatalf("%s: unexpected policy for %s", test.route, policy.Name())
+		}
+
+		if test.limit != 0 && (policy == nil || policy.Limit != test.limit) {
+			t.Fatalf("%s %s: expected the policy with limit %d, got %v", test.route, test.grantType, test.limit, policy)
+		}
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	limiter := RateLimiter{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.10"}}
+
+	clientPolicy := &RatePolicy{Key: KeyClientID}
+	r := httptest.NewRequest("POST", "/token", nil)
+	r.SetBasicAuth(url.QueryEscape("my client"), "secret")
+	r.RemoteAddr = "192.0.2.1:40000"
+	if key := limiter.requestKey(clientPolicy, r); key != "client:my client" {
+		t.Fatalf("Unexpected key for basic authentication: %s", key)
+	}
+
+	r = httptest.NewRequest("POST", "/token", strings.NewReader("client_id=other"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:40000"
+	if key := limiter.requestKey(clientPolicy, r); key != "client:other" {
+		t.Fatalf("Unexpected key for the client ID in the body: %s", key)
+	}
+
+	// The body is left for the handler to read
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "client_id=other" {
+		t.Fatalf("Request body consumed, %q left", body)
+	}
+
+	// Bodies too large to be read before the request is handled are left whole for the handler
+	large := "client_id=other&padding=" + strings.Repeat("a", maxPeekedBodySize)
+	r = httptest.NewRequest("POST", "/token", strings.NewReader(large))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:40000"
+	if key := limiter.requestKey(clientPolicy, r); key != "ip:192.0.2.1" {
+		t.Fatalf("Unexpected key for a large body: %s", key)
+	}
+
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != large {
+		t.Fatalf("Large request body consumed, %d bytes left", len(body))
+	}
+
+	// Requests without a client ID are counted by IP address
+	r = httptest.NewRequest("GET", "/userinfo", nil)
+	r.RemoteAddr = "192.0.2.1:40000"
+	if key := limiter.requestKey(clientPolicy, r); key != "ip:192.0.2.1" {
+		t.Fatalf("Unexpected key without a client ID: %s", key)
+	}
+
+	forwardedPolicy := &RatePolicy{Key: KeyForwarded}
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		key        string
+	}{
+		// The header is ignored unless sent by a trusted proxy
+		{"192.0.2.1:40000", "198.51.100.1", "ip:192.0.2.1"},
+		{"10.1.2.3:40000", "198.51.100.1", "ip:198.51.100.1"},
+		{"192.0.2.10:40000", "198.51.100.1", "ip:198.51.100.1"},
+		// Addresses prepended by the client are not trusted
+		{"10.1.2.3:40000", "203.0.113.7, 198.51.100.1, 10.4.5.6", "ip:198.51.100.1"},
+		{"10.1.2.3:40000", "", "ip:10.1.2.3"},
+		{"[2001:db8::1]:40000", "198.51.100.1", "ip:2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		r = httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		if key := limiter.requestKey(forwardedPolicy, r); key != test.key {
+			t.Fatalf("%s forwarding %q: expected %s, got %s", test.remoteAddr, test.forwarded, test.key, key)
+		}
+	}
+}
+
+func TestReloadableLimiter(t *testing.T) {
+	store := cache.NewMemoryStore()
+	limiter := NewReloadableRateLimiter(RateLimiter{
+		Policies: []RatePolicy{{Route: "/", Limit: 1, Minutes: 1}},
+		Store:    store,
+	})
+	handler := newLimitedHandler(limiter)
+
+	sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:40000")
+	w := sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:40000")
+	if w.Code != 429 {
+		t.Fatalf("HTTP %d: request allowed beyond policy limit\n", w.Code)
 	}
 
-	io.Copy(ioutil.Discard, res.Body)
-	defer res.Body.Close()
-	if res.StatusCode != 429 {
-		t.Fatalf("HTTP %d: request allowed beyond policy limit\n", res.StatusCode)
+	// The handler enforces the policies set after it was created
+	limiter.Set(RateLimiter{Policies: []RatePolicy{{Route: "/", Limit: 5, Minutes: 1}}, Store: store})
+	w = sendLimited(handler, httptest.NewRequest("GET", "/", nil), "192.0.2.1:40000")
+	if w.Code != 200 || w.Header().Get("RateLimit-Limit") != "5" {
+		t.Fatalf("HTTP %d: policies not reloaded: %v\n", w.Code, w.Header())
 	}
 }
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oauth2bin/oauth2/metrics"
+)
+
+// RequestMetrics is an implementation of Middleware which observes the latency of the requests
+// to a route by their status code. See metrics.RequestDuration.
+//
+// Handler: the pattern of the route, by which the requests are labelled
+type RequestMetrics struct {
+	Handler string
+}
+
+// NewRequestMetrics returns a new instance of RequestMetrics
+func NewRequestMetrics(handler string) RequestMetrics {
+	return RequestMetrics{Handler: handler}
+}
+
+// Handle implements the Middleware interface
+func (rm RequestMetrics) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		response := &statusResponse{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(response, r)
+
+		metrics.RequestDuration.ObserveSince(start, rm.Handler, strconv.Itoa(response.status))
+	}
+}
+
+// ErrorCounter is an implementation of Middleware which counts the error responses of a route
+// by the error code they carry, as defined by RFC 6749 Section 5.2 (https://tools.ietf.org/html/rfc6749#section-5.2)
+//
+// Errors: the counter of the error codes, labelled by the error code only.
+// Errors whose response carries no error code are counted as "unknown".
+type ErrorCounter struct {
+	Errors *metrics.Counter
+}
+
+// NewErrorCounter returns a new instance of ErrorCounter
+func NewErrorCounter(errors *metrics.Counter) ErrorCounter {
+	return ErrorCounter{Errors: errors}
+}
+
+// Handle implements the Middleware interface
+func (ec ErrorCounter) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := &recordingResponse{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(response, r)
+
+		if response.status < http.StatusBadRequest {
+			return
+		}
+
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(response.body.Bytes(), &body)
+		if body.Error == "" {
+			body.Error = "unknown"
+		}
+
+		ec.Errors.Inc(body.Error)
+	}
+}
+
+// An http.ResponseWriter which keeps the status of the response it writes.
+// Responses are flushed as they are written, e.g. for the streams of the request inspector.
+type statusResponse struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusResponse) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusResponse) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
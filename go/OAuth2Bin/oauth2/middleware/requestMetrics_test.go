@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2bin/oauth2/metrics"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	handler := NewRequestMetrics("/test").Handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		w.(http.Flusher).Flush()
+	})
+
+	ok, failed := metrics.RequestDuration.Count("/test", "200"), metrics.RequestDuration.Count("/test", "418")
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/test?fail=true", nil))
+
+	if metrics.RequestDuration.Count("/test", "200") != ok+1 || metrics.RequestDuration.Count("/test", "418") != failed+1 {
+		t.Fatal("Requests not observed by status code")
+	}
+}
+
+func TestErrorCounter(t *testing.T) {
+	errors := metrics.NewRegistry().NewCounter("test_errors_total", "Test.", "error")
+	handler := NewErrorCounter(errors).Handle(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("error") {
+		case "":
+			fmt.Fprint(w, `{"error":"not an error"}`)
+		case "none":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":%q}`, r.URL.Query().Get("error"))
+		}
+	})
+
+	for _, query := range []string{"", "?error=invalid_grant", "?error=invalid_grant", "?error=none"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/token"+query, nil))
+	}
+
+	if errors.Value("invalid_grant") != 2 || errors.Value("unknown") != 1 || errors.Value("not an error") != 0 {
+		t.Fatalf("Unexpected errors counted")
+	}
+}
@@ -0,0 +1,375 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/utils"
+)
+
+// Ways in which a request names the bin it is to be recorded in, see RequestBinID
+const (
+	BinHeader = "X-Bin-ID"
+	BinParam  = "bin"
+	BinCookie = "oa2b_bin"
+)
+
+const (
+	// Longest body of a request or response which is recorded, longer ones are cut off once redacted
+	maxRecordedBody = 4096
+
+	// Longest body of a response which is kept to be redacted, beyond which it is not recorded
+	maxCapturedBody = 64 << 10
+)
+
+// Replaces the secrets in the recorded requests
+const redacted = "********"
+
+// Parameters and JSON fields whose values are never recorded
+var secretParams = map[string]bool{
+	"client_secret":             true,
+	"client_assertion":          true,
+	"password":                  true,
+	"code":                      true,
+	"code_verifier":             true,
+	"device_code":               true,
+	"token":                     true,
+	"access_token":              true,
+	"refresh_token":             true,
+	"id_token":                  true,
+	"registration_access_token": true,
+}
+
+// RequestBinID returns the ID of the bin the request is to be recorded in, if any.
+// The bin is named by the X-Bin-ID header, the bin query parameter or the cookie
+// set by the inspector page, in this order.
+func RequestBinID(r *http.Request) string {
+	if binID := r.Header.Get(BinHeader); binID != "" {
+		return binID
+	}
+
+	if binID := r.URL.Query().Get(BinParam); binID != "" {
+		return binID
+	}
+
+	if cookie, err := r.Cookie(BinCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// RequestRecorder is an implementation of Middleware which records the requests which name a bin,
+// along with their responses, in the store, with the secrets they carry redacted.
+// The requests recorded are also sent to the subscribers of the bin, see Subscribe.
+type RequestRecorder struct {
+	mut         sync.Mutex
+	subscribers map[string]map[chan cache.BinRequest]bool
+	closed      bool
+}
+
+// NewRequestRecorder returns a new instance of RequestRecorder
+func NewRequestRecorder() *RequestRecorder {
+	return &RequestRecorder{subscribers: make(map[string]map[chan cache.BinRequest]bool)}
+}
+
+// Subscribe returns a channel which receives the requests recorded in the bin by this server
+// from now on, along with a function which ends the subscription.
+// The channel is closed when the subscription ends or the recorder is closed.
+// Requests are dropped rather than wait for a subscriber which does not keep up.
+func (rr *RequestRecorder) Subscribe(binID string) (<-chan cache.BinRequest, func()) {
+	rr.mut.Lock()
+	defer rr.mut.Unlock()
+
+	requests := make(chan cache.BinRequest, 16)
+	if rr.closed {
+		close(requests)
+		return requests, func() {}
+	}
+
+	if rr.subscribers[binID] == nil {
+		rr.subscribers[binID] = make(map[chan cache.BinRequest]bool)
+	}
+	rr.subscribers[binID][requests] = true
+
+	return requests, func() {
+		rr.mut.Lock()
+		defer rr.mut.Unlock()
+
+		if rr.subscribers[binID][requests] {
+			delete(rr.subscribers[binID], requests)
+			if len(rr.subscribers[binID]) == 0 {
+				delete(rr.subscribers, binID)
+			}
+			close(requests)
+		}
+	}
+}
+
+// Close ends every subscription, e.g. so that the streams of recorded requests
+// do not hold up the shutdown of the server
+func (rr *RequestRecorder) Close() {
+	rr.mut.Lock()
+	defer rr.mut.Unlock()
+
+	for _, subscribers := range rr.subscribers {
+		for requests := range subscribers {
+			close(requests)
+		}
+	}
+
+	rr.subscribers = make(map[string]map[chan cache.BinRequest]bool)
+	rr.closed = true
+}
+
+// Sends the request recorded in the bin to its subscribers
+func (rr *RequestRecorder) publish(binID string, request cache.BinRequest) {
+	rr.mut.Lock()
+	defer rr.mut.Unlock()
+
+	for requests := range rr.subscribers[binID] {
+		select {
+		case requests <- request:
+		default:
+		}
+	}
+}
+
+// Handle implements the Middleware interface.
+// The request is recorded once handled, if it names a bin which exists.
+func (rr *RequestRecorder) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		binID := RequestBinID(r)
+		if binID == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		request := cache.BinRequest{
+			Time:       start,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			Headers:    redactHeaders(r.Header),
+			Params:     requestParams(r),
+			Body:       requestBody(r),
+		}
+
+		response := &recordingResponse{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(response, r)
+
+		request.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		request.Status = response.status
+		request.ResponseHeaders = redactHeaders(w.Header())
+		if isJSON(w.Header()) {
+			request.Response = redactJSON(response.body.Bytes())
+		}
+
+		err := cache.RecordBinRequest(binID, request)
+		if err == cache.ErrUnknownBin {
+			return
+		} else if err != nil {
+			log.Printf("Could not record the request in bin %s: %s\n", binID, err)
+			return
+		}
+
+		rr.publish(binID, request)
+	}
+}
+
+// Returns the query and form parameters of the request with their secrets redacted
+func requestParams(r *http.Request) map[string][]string {
+	params := url.Values{}
+	for name, values := range r.URL.Query() {
+		params[name] = values
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		for name, values := range peekPostForm(r) {
+			params[name] = append(params[name], values...)
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+
+	return redactParams(params)
+}
+
+// Returns the JSON body of the request with its secrets redacted. Other bodies are
+// either form-encoded, and recorded as parameters, or not sent to the routes of the server.
+func requestBody(r *http.Request) string {
+	if !isJSON(r.Header) {
+		return ""
+	}
+
+	body, err := peekBody(r)
+	if err != nil {
+		return ""
+	}
+
+	return redactJSON(body)
+}
+
+// Checks if the body of the request or response the headers belong to is JSON
+func isJSON(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "application/json")
+}
+
+// Returns a copy of the parameters with the values of the secret ones redacted
+func redactParams(params url.Values) url.Values {
+	redactedParams := url.Values{}
+	for name, values := range params {
+		if !secretParams[name] {
+			redactedParams[name] = values
+			continue
+		}
+
+		for range values {
+			redactedParams.Add(name, redacted)
+		}
+	}
+
+	return redactedParams
+}
+
+// Returns a copy of the headers with the credentials they carry redacted.
+// The client ID of Basic credentials is kept, since it tells which client sent the request.
+// The redirects of the authorization endpoint carry the grants and tokens issued in their URL.
+func redactHeaders(header http.Header) map[string][]string {
+	redactedHeader := make(map[string][]string, len(header))
+	for name, values := range header {
+		redactedValues := make([]string, len(values))
+		for i, value := range values {
+			switch name {
+			case "Authorization", "Proxy-Authorization":
+				redactedValues[i] = redactCredentials(value)
+			case "Cookie", "Set-Cookie":
+				redactedValues[i] = redacted
+			case "Location":
+				redactedValues[i] = redactURL(value)
+			default:
+				redactedValues[i] = value
+			}
+		}
+
+		redactedHeader[name] = redactedValues
+	}
+
+	return redactedHeader
+}
+
+// Redacts the credentials of an Authorization header, but for the client ID of Basic credentials
+func redactCredentials(value string) string {
+	scheme := strings.SplitN(value, " ", 2)[0]
+	if scheme != "Basic" {
+		return scheme + " " + redacted
+	}
+
+	clientID, _ := utils.ParseBasicAuthHeader(value)
+	return scheme + " " + clientID + ":" + redacted
+}
+
+// Redacts the secret parameters in the query and fragment of the URL
+func redactURL(value string) string {
+	location, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+
+	location.RawQuery = redactQuery(location.RawQuery)
+	if location.Fragment != "" {
+		location.RawFragment = redactQuery(location.EscapedFragment())
+		location.Fragment, _ = url.PathUnescape(location.RawFragment)
+	}
+
+	return location.String()
+}
+
+// Redacts the values of the secret parameters of the URL-encoded query,
+// leaving the others as they were sent
+func redactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil && secretParams[unescaped] {
+			pairs[i] = name + "=" + redacted
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Returns the JSON document with the values of its secret fields redacted,
+// cut off beyond the longest body recorded. Nothing is returned for an invalid document,
+// since the secrets in it could not be told apart.
+func redactJSON(data []byte) string {
+	var document interface{}
+	err := json.Unmarshal(data, &document)
+	if err != nil {
+		return ""
+	}
+
+	data, _ = json.Marshal(redactJSONValue(document))
+
+	if len(data) > maxRecordedBody {
+		data = data[:maxRecordedBody]
+	}
+
+	return string(data)
+}
+
+func redactJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, field := range value {
+			if secretParams[name] {
+				value[name] = redacted
+			} else {
+				value[name] = redactJSONValue(field)
+			}
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = redactJSONValue(element)
+		}
+	}
+
+	return value
+}
+
+// An http.ResponseWriter which keeps the status and the beginning of the body of the response it writes
+type recordingResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *recordingResponse) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *recordingResponse) Write(data []byte) (int, error) {
+	if room := maxCapturedBody - rr.body.Len(); room > 0 {
+		if len(data) < room {
+			room = len(data)
+		}
+		rr.body.Write(data[:room])
+	}
+
+	return rr.ResponseWriter.Write(data)
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+)
+
+func newRecordedHandler(rr *RequestRecorder) http.HandlerFunc {
+	return rr.Handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_secret") != "clientSecret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"secret-token","token_type":"bearer","expires_in":3600}`)
+	})
+}
+
+func TestRecorderHandle(t *testing.T) {
+	binID, err := cache.NewBin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := NewRequestRecorder()
+	handler := newRecordedHandler(rr)
+	requests, unsubscribe := rr.Subscribe(binID)
+	defer unsubscribe()
+
+	form := url.Values{"grant_type": {"password"}, "password": {"hunter2"}, "client_secret": {"clientSecret"}}
+	r := httptest.NewRequest("POST", "/token?bin="+binID, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("clientID", "clientSecret")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	// The handler reads the request as it was sent
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: request not handled", w.Code)
+	}
+
+	recorded, err := cache.BinRequests(binID)
+	if err != nil || len(recorded) != 1 {
+		t.Fatalf("Request not recorded: %v %v", recorded, err)
+	}
+
+	request := recorded[0]
+	if request.Method != "POST" || request.Path != "/token" || request.Status != http.StatusOK || request.Params["grant_type"][0] != "password" {
+		t.Fatalf("Unexpected request recorded: %+v", request)
+	}
+
+	// Secrets are never recorded
+	jsonBytes, _ := json.Marshal(request)
+	for _, secret := range []string{"hunter2", "clientSecret", "secret-token"} {
+		if strings.Contains(string(jsonBytes), secret) {
+			t.Fatalf("Secret %s recorded: %s", secret, jsonBytes)
+		}
+	}
+
+	if request.Headers["Authorization"][0] != "Basic clientID:"+redacted || !strings.Contains(request.Response, `"token_type":"bearer"`) {
+		t.Fatalf("Unexpected request recorded: %s", jsonBytes)
+	}
+
+	// The subscribers of the bin receive the request
+	select {
+	case published := <-requests:
+		if published.Path != "/token" {
+			t.Fatalf("Unexpected request published: %+v", published)
+		}
+	default:
+		t.Fatal("Request not published")
+	}
+
+	// Requests to unknown bins or without a bin are not recorded
+	for _, target := range []string{"/echo?bin=unknown", "/echo"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	r = httptest.NewRequest("GET", "/echo", nil)
+	r.AddCookie(&http.Cookie{Name: BinCookie, Value: binID})
+	handler(httptest.NewRecorder(), r)
+
+	recorded, _ = cache.BinRequests(binID)
+	if len(recorded) != 2 || recorded[1].Path != "/echo" || recorded[1].Status != http.StatusUnauthorized {
+		t.Fatalf("Unexpected requests recorded: %+v", recorded)
+	}
+}
+
+func TestRecorderSubscriptions(t *testing.T) {
+	rr := NewRequestRecorder()
+
+	requests, unsubscribe := rr.Subscribe("bin")
+	unsubscribe()
+	if _, open := <-requests; open {
+		t.Fatal("Channel not closed when unsubscribed")
+	}
+	unsubscribe()
+
+	requests, _ = rr.Subscribe("bin")
+	rr.Close()
+	if _, open := <-requests; open {
+		t.Fatal("Channel not closed with the recorder")
+	}
+
+	requests, _ = rr.Subscribe("bin")
+	if _, open := <-requests; open {
+		t.Fatal("Subscribed to a closed recorder")
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	redirect := "https://client.example/cb?code=grant&state=xyz#access_token=token&token_type=bearer"
+	expected := "https://client.example/cb?code=********&state=xyz#access_token=********&token_type=bearer"
+	if redactURL(redirect) != expected {
+		t.Fatalf("Expected %s, got %s", expected, redactURL(redirect))
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+	"oauth2bin/oauth2/middleware"
+)
+
+// Returns the signing key of the server handling the request
+func requestSigner(r *http.Request) *jwt.Signer {
+	return scopeOf(r).signer
+}
+
+// Loads the signing key from the configured key file or generates one
+func newTokenSigner(jwtConfig config.JWTConfig) *jwt.Signer {
+	var signer *jwt.Signer
+	var err error
+
+	if jwtConfig.KeyFile != "" {
+		signer, err = jwt.LoadSigner(jwtConfig.KeyFile)
+	} else {
+		signer, err = jwt.NewSigner(jwtConfig.Algorithm)
+	}
+
+	if err != nil {
+		log.Fatalf("Could not set up the token signing key: %s", err)
+	}
+
+	return signer
+}
+
+// Returns the lifetimes of the grants and tokens issued to the client by the flow.
+// The access tokens are issued already expired if a fault injected into the request says so.
+func lifetimesFor(r *http.Request, client *config.Client, flow int) config.Lifetimes {
+	lifetimes := requestConfig(r).LifetimesFor(*client, flow)
+	if fault, ok := middleware.FaultFromContext(r.Context()); ok && fault.ExpiredTokens {
+		lifetimes.AccessToken = 0
+	}
+
+	return lifetimes
+}
+
+// Returns the access token to be issued to the client through the given flow.
+// If the client is to be issued JWT access tokens, the opaque token is wrapped
+// in a signed JWT which carries it as its "jti" claim so that the token can
+// still be looked up in the cache. Else the opaque token is returned as is.
+// Refer RFC 9068 Section 2 (https://www.rfc-editor.org/rfc/rfc9068#section-2)
+func formatAccessToken(r *http.Request, client *config.Client, flow int, accessToken, scope string, expiresIn int) (string, error) {
+	cnfg := requestConfig(r)
+
+	if !cnfg.IssuesJWTAccessTokens(*client) {
+		return accessToken, nil
+	}
+
+	// The client is the subject when no resource owner is involved
+	subject := cnfg.ROPCCnfg.Username
+	if flow == config.ClientCreds {
+		subject = client.ID
+	}
+
+	audience := cnfg.JWTCnfg.Audience
+	if audience == "" {
+		audience = cnfg.BaseURL
+	}
+
+	now := time.Now()
+	return requestSigner(r).Sign(jwt.AccessTokenClaims{
+		Issuer:   cnfg.BaseURL,
+		Subject:  subject,
+		Audience: audience,
+		Expiry:   now.Add(time.Duration(expiresIn) * time.Second).Unix(),
+		IssuedAt: now.Unix(),
+		JWTID:    accessToken,
+		ClientID: client.ID,
+		Scope:    scope,
+	}, jwt.AccessTokenType)
+}
+
+// Returns the opaque token under which an access token is stored in the cache.
+// For JWT access tokens, the signature is verified and the "jti" claim is returned.
+// An empty string is returned for JWTs that fail verification.
+func resolveAccessToken(r *http.Request, token string) string {
+	if !jwt.IsJWT(token) {
+		return token
+	}
+
+	var claims jwt.AccessTokenClaims
+	err := requestSigner(r).Verify(token, &claims)
+	if err != nil {
+		return ""
+	}
+
+	return claims.JWTID
+}
+
+// handleJWKS serves the public key used to sign JWTs as a JSON Web Key Set
+// Refer RFC 7517 Section 5 (https://tools.ietf.org/html/rfc7517#section-5)
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(requestSigner(r).JWKS())
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
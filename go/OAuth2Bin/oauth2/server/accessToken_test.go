@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+)
+
+func TestJWTAccessToken(t *testing.T) {
+	signer, err := jwt.NewSigner(jwt.ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnfg := &config.OA2Config{
+		BaseURL: "https://oauth2bin.org",
+		ClientCredsCnfg: config.ClientCredsConfig{
+			ClientID:          "rs",
+			ClientSecret:      "rs-secret",
+			AccessTokenFormat: config.JWTAccessToken,
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=client_credentials&scope=read"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("rs", "rs-secret")
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, handleToken)(recorder, req)
+
+	var token cache.ClientCredentialsToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.AccessTokenClaims
+	err = signer.Verify(token.AccessToken, &claims)
+	if err != nil {
+		t.Fatalf("Issued access token is not a valid JWT: %s", err)
+	}
+
+	if claims.Subject != "rs" || claims.ClientID != "rs" || claims.Scope != "read" ||
+		claims.Issuer != "https://oauth2bin.org" || !strings.HasPrefix(claims.JWTID, cache.ClientCredsFlowID) {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+
+	// The JWT must be usable wherever the opaque token is
+	recorder = introspect(cnfg, signer, "rs", "rs-secret", token.AccessToken)
+	if !strings.Contains(recorder.Body.String(), `"active":true`) {
+		t.Fatalf("JWT access token not active on introspection: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	withScope(cnfg, signer, handleJWKS)(recorder, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if !strings.Contains(recorder.Body.String(), signer.KeyID()) {
+		t.Fatalf("Signing key not published in JWKS: %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/middleware"
+	"oauth2bin/oauth2/utils"
+)
+
+// Route of the admin page, under which the admin API is served
+const adminRoute = "/admin"
+
+// Realm sent in the challenge of the admin API
+const adminRealm = "OAuth 2.0 Bin Admin"
+
+// Flows whose grants and tokens are listed by the admin API
+var adminFlows = []string{
+	cache.AuthCodeFlowName,
+	cache.ImplicitFlowName,
+	cache.ROPCFlowName,
+	cache.ClientCredsFlowName,
+	cache.DeviceFlowName,
+}
+
+// Returns true if the grants and tokens of the flow are listed by the admin API
+func isAdminFlow(flow string) bool {
+	for _, value := range adminFlows {
+		if value == flow {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminRequest is the JSON body of the requests which revoke or expire tokens.
+// Either a token, along with the token_type_hint as for the revocation endpoint,
+// or the client whose grants and tokens are all revoked is sent.
+type adminRequest struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+	ClientID      string `json:"client_id"`
+}
+
+// Returns the credentials of the admin API, see config.AdminConfig.
+// The username defaults to "admin".
+func adminCredentials(r *http.Request) (string, string) {
+	admin := requestConfig(r).Admin
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		admin.Password = password
+	}
+
+	if admin.Username == "" {
+		admin.Username = "admin"
+	}
+
+	return admin.Username, admin.Password
+}
+
+// Returns the middleware which protects the routes of the admin API with its credentials
+func newAdminAuth() middleware.BasicAuth {
+	return middleware.NewBasicAuth(adminRealm, adminCredentials)
+}
+
+// [Basic Auth Required] handleAdmin serves the admin page, which lists the grants and tokens
+// held in the store and revokes or expires them through the admin API
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ShowError(w, r, 405, "Method Not Allowed", r.Method+" not allowed.")
+		return
+	}
+
+	tmpl, err := template.ParseFiles(
+		"public/templates/admin.html",
+		"public/templates/nav.html",
+		"public/templates/footer.html",
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin", struct{ Flows []string }{Flows: adminFlows})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// [Basic Auth Required] handleAdminGrants lists the grants and tokens held in the store, newest first.
+// They may be narrowed down to a flow and a client with the flow and client_id query parameters.
+func handleAdminGrants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		showAdminMethodError(w, r)
+		return
+	}
+
+	flow, clientID := r.URL.Query().Get("flow"), r.URL.Query().Get("client_id")
+	if flow != "" && !isAdminFlow(flow) {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "flow must be one of " + strings.Join(adminFlows, ", "),
+		})
+		return
+	}
+
+	grants, err := cache.StoredGrants(flow, clientID)
+	if err != nil {
+		showAdminStoreError(w, r)
+		return
+	}
+
+	if grants == nil {
+		grants = []cache.StoredGrant{}
+	}
+
+	writeAdminResponse(w, grants)
+}
+
+// [Basic Auth Required] handleAdminRevoke revokes a token, as the revocation endpoint would,
+// or every grant and token issued to a client, and sends the number of those revoked.
+// Revoking those of a client is best-effort, see cache.RevokeClientGrants.
+func handleAdminRevoke(w http.ResponseWriter, r *http.Request) {
+	var request adminRequest
+	if !readAdminRequest(w, r, http.MethodPost, &request) {
+		return
+	}
+
+	if (request.Token == "") == (request.ClientID == "") {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "either token or client_id is required",
+		})
+		return
+	}
+
+	if request.ClientID != "" {
+		revoked, err := cache.RevokeClientGrants(request.ClientID)
+		if err != nil {
+			showAdminStoreError(w, r)
+			return
+		}
+
+		log.Printf("Admin revoked the %d grants and tokens of client %s\n", revoked, request.ClientID)
+		writeAdminResponse(w, map[string]int{"revoked": revoked})
+		return
+	}
+
+	if !cache.RevokeToken(resolveAccessToken(r, request.Token), request.TokenTypeHint) {
+		showAdminTokenNotFound(w, r)
+		return
+	}
+
+	writeAdminResponse(w, map[string]int{"revoked": 1})
+}
+
+// [Basic Auth Required] handleAdminExpire makes a token expire now, so that clients can be tested
+// against expired tokens. An access token keeps its refresh token.
+func handleAdminExpire(w http.ResponseWriter, r *http.Request) {
+	var request adminRequest
+	if !readAdminRequest(w, r, http.MethodPost, &request) {
+		return
+	}
+
+	if request.Token == "" {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "token is required",
+		})
+		return
+	}
+
+	if !cache.ExpireToken(resolveAccessToken(r, request.Token), request.TokenTypeHint) {
+		showAdminTokenNotFound(w, r)
+		return
+	}
+
+	writeAdminResponse(w, map[string]int{"expired": 1})
+}
+
+// [Basic Auth Required] handleAdminFaults sends the faults injected into the requests on GET,
+// and replaces them with those sent as a JSON array on PUT. See middleware.Fault.
+func (s *OA2Server) handleAdminFaults(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeAdminResponse(w, s.Faults.Faults())
+		return
+	}
+
+	var faults []middleware.Fault
+	if !readAdminRequest(w, r, http.MethodPut, &faults) {
+		return
+	}
+
+	err := s.Faults.SetFaults(faults)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Admin set %d faults\n", len(faults))
+	writeAdminResponse(w, s.Faults.Faults())
+}
+
+// Decodes the JSON body of a request to the admin API made with the method.
+// Only JSON bodies are accepted, which browsers do not send to other sites without asking them first,
+// so that other sites cannot make use of the credentials the browser keeps for the admin API.
+// If the request is invalid, the error is sent and false is returned.
+func readAdminRequest(w http.ResponseWriter, r *http.Request, method string, v interface{}) bool {
+	if r.Method != method {
+		showAdminMethodError(w, r)
+		return false
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		utils.ShowJSONError(w, r, http.StatusUnsupportedMediaType, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "Content-Type must be application/json",
+		})
+		return false
+	}
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  fmt.Sprintf("invalid JSON body: %s", err),
+		})
+		return false
+	}
+
+	return true
+}
+
+func writeAdminResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(v)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+func showAdminMethodError(w http.ResponseWriter, r *http.Request) {
+	utils.ShowJSONError(w, r, http.StatusMethodNotAllowed, utils.RequestError{
+		Error: "invalid_request",
+		Desc:  r.Method + " not allowed",
+	})
+}
+
+func showAdminTokenNotFound(w http.ResponseWriter, r *http.Request) {
+	utils.ShowJSONError(w, r, http.StatusNotFound, utils.RequestError{
+		Error: "invalid_request",
+		Desc:  "token not found, it may have expired or been revoked",
+	})
+}
+
+func showAdminStoreError(w http.ResponseWriter, r *http.Request) {
+	utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+		Error: "server_error",
+		Desc:  "the store could not be read",
+	})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/middleware"
+)
+
+func TestAdmin(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	// Sends the request to the admin API and checks the status, decoding the response into v
+	send := func(method, path, body string, status int, v interface{}) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "secret")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil || res.StatusCode != status {
+			t.Fatalf("%s %s: expected HTTP %d: %v %v", method, path, status, res, err)
+		}
+		defer res.Body.Close()
+
+		if v != nil {
+			json.NewDecoder(res.Body).Decode(v)
+		}
+	}
+
+	// The admin API is disabled until its password is set
+	send("GET", "/admin/grants", "", http.StatusNotFound, nil)
+
+	cnfg := *s.currentConfig()
+	cnfg.Admin = config.AdminConfig{Password: "secret"}
+	s.setConfig(&cnfg)
+
+	res, _ := http.Get(ts.URL + "/admin")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("HTTP %d: admin page served without credentials", res.StatusCode)
+	}
+	res.Body.Close()
+
+	send("GET", "/admin", "", http.StatusOK, nil)
+
+	token, err := cache.NewROPCToken("adminTestClient", "", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var grants []cache.StoredGrant
+	send("GET", "/admin/grants?flow=password&client_id=adminTestClient", "", http.StatusOK, &grants)
+	if len(grants) != 1 || grants[0].Key != token.AccessToken || !grants[0].Active {
+		t.Fatalf("Unexpected grants listed: %+v", grants)
+	}
+
+	send("GET", "/admin/grants?flow=unknown", "", http.StatusBadRequest, nil)
+
+	// Expiring the access token keeps its refresh token
+	send("POST", "/admin/expire", `{"token":"`+token.AccessToken+`"}`, http.StatusOK, nil)
+	if cache.IntrospectToken(token.AccessToken, "").Active || !cache.IntrospectToken(token.RefreshToken, cache.RefreshTokenHint).Active {
+		t.Fatal("Access token not expired on its own")
+	}
+
+	send("POST", "/admin/revoke", `{"token":"unknown"}`, http.StatusNotFound, nil)
+	send("POST", "/admin/revoke", `{}`, http.StatusBadRequest, nil)
+
+	var revoked map[string]int
+	send("POST", "/admin/revoke", `{"client_id":"adminTestClient"}`, http.StatusOK, &revoked)
+	if revoked["revoked"] != 1 || cache.ROPCRefreshTokenExists(token.RefreshToken, false) {
+		t.Fatalf("Client tokens not revoked: %v", revoked)
+	}
+
+	// Only JSON bodies are accepted
+	req, _ := http.NewRequest("POST", ts.URL+"/admin/revoke", strings.NewReader("client_id=adminTestClient"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "secret")
+	res, _ = http.DefaultClient.Do(req)
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("HTTP %d: form body accepted", res.StatusCode)
+	}
+	res.Body.Close()
+
+	var faults []middleware.Fault
+	send("PUT", "/admin/faults", `[{"route":"/token","status":503}]`, http.StatusOK, &faults)
+	if len(faults) != 1 || len(s.Faults.Faults()) != 1 || s.Faults.Faults()[0].Status != 503 {
+		t.Fatalf("Faults not set: %+v", faults)
+	}
+
+	send("PUT", "/admin/faults", `[{"status":42}]`, http.StatusBadRequest, nil)
+	send("GET", "/admin/faults", "", http.StatusOK, &faults)
+	if len(faults) != 1 {
+		t.Fatalf("Invalid faults set: %+v", faults)
+	}
+}
@@ -6,29 +6,75 @@ import (
 	"net/http"
 	"oauth2bin/oauth2/cache"
 	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/metrics"
 	"oauth2bin/oauth2/utils"
 )
 
-// handleAuthCodeAuth checks for the existence of client_id in the query parametes.
-// If not present, an HTTP 400 response is sent.
-// If an unrecognized client_id is found, an HTTP 401 response is sent.
-// Else, an authorization screen is presented to the user.
-func handleAuthCodeAuth(w http.ResponseWriter, r *http.Request) {
+// handleAuthCodeAuth looks up the client_id of the query parameters in the client registry.
+// The redirect URI was already validated by handleAuth, hence errors are sent to it:
+// If the client may not use the Authorization Code flow, unauthorized_client is sent.
+// If the PKCE parameters are invalid, or missing when required, invalid_request is sent.
+// If the requested scope is not allowed for the client, invalid_scope is sent.
+// Else, the request is stored and an authorization screen is presented to the user.
+func handleAuthCodeAuth(w http.ResponseWriter, r *http.Request, redirectURI string) {
 	queryParams := r.URL.Query()
-	clientID := queryParams.Get("client_id")
+	redirect := authRedirect{URI: redirectURI, State: queryParams.Get("state")}
 
-	switch clientID {
-	case "":
-		utils.ShowError(w, r, 400, "Bad Request", "client_id is required")
-	case serverConfig.AuthCodeCnfg.ClientID:
-		utils.PresentAuthScreen(w, r, config.AuthCode)
-	default:
-		utils.ShowError(w, r, 401, "Unauthorized", "Invalid client_id")
+	client, err := findClient(r, queryParams.Get("client_id"), config.AuthCodeGrant)
+	if err != nil {
+		redirect.sendError(w, r, "unauthorized_client", err.Error())
+		return
+	}
+
+	// The redirect URI may have been registered by another client with the same ID
+	if !client.AllowsRedirectURI(redirectURI) {
+		utils.ShowError(w, r, 400, "Bad Request", errInvalidRedirectURI.Error())
+		return
 	}
+
+	pkce, err := cache.NewPKCEChallenge(queryParams.Get("code_challenge"), queryParams.Get("code_challenge_method"))
+	if err != nil {
+		redirect.sendError(w, r, "invalid_request", err.Error())
+		return
+	}
+
+	// Refer RFC 7636 Section 4.4.1 (https://tools.ietf.org/html/rfc7636#section-4.4.1)
+	if pkce.IsEmpty() && client.RequirePKCE && client.IsPublic() {
+		redirect.sendError(w, r, "invalid_request", "code challenge required")
+		return
+	}
+
+	scope, err := utils.NarrowScope(queryParams.Get("scope"), client.Scopes)
+	if err != nil {
+		redirect.sendError(w, r, "invalid_scope", err.Error())
+		return
+	}
+
+	requestID, err := cache.NewAuthorizationRequest(cache.AuthorizationRequest{
+		Flow:         config.AuthCode,
+		ClientID:     client.ID,
+		RedirectURI:  redirectURI,
+		ResponseType: "code",
+		Scope:        scope,
+		State:        queryParams.Get("state"),
+		Nonce:        queryParams.Get("nonce"),
+		PKCE:         pkce,
+	})
+	if err != nil {
+		redirect.sendError(w, r, "server_error", "An error occurred while processing your request.")
+		return
+	}
+
+	utils.PresentAuthScreen(w, r, config.AuthCode, scope, requestID)
 }
 
 // handleAuthCodeToken checks for the existence of all parametes detailed in Section 4.1.3 of RFC (https://tools.ietf.org/html/rfc6749#section-4.1.3).
 // If not present, an HTTP 400 response is sent.
+// If the client is unrecognized or may not use the flow, an error response is sent as per Section 5.2.
+// Confidential clients must authenticate with their client_secret, else invalid_client is sent.
+// If the grant was issued to another client, invalid_grant is sent.
+// If the grant was issued with a PKCE code challenge, code_verifier is checked as per
+// Section 4.6 of RFC 7636 (https://tools.ietf.org/html/rfc7636#section-4.6).
 // Else, a new token is generated, added to the store, and returned to the user in a JSON response.
 func handleAuthCodeToken(w http.ResponseWriter, r *http.Request, params map[string]string) {
 	if params["client_id"] == "" || params["grant_type"] == "" || params["code"] == "" {
@@ -39,8 +85,31 @@ func handleAuthCodeToken(w http.ResponseWriter, r *http.Request, params map[stri
 		return
 	}
 
-	token, err := cache.NewAuthCodeToken(params["code"], "", params["redirect_uri"])
+	client, err := findClient(r, params["client_id"], config.AuthCodeGrant)
 	if err != nil {
+		showClientLookupError(w, r, err)
+		return
+	}
+
+	if !authenticateTokenRequest(client, params) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	// The grant is bound to the only registered redirect URI if the client did not send one
+	redirectURI := params["redirect_uri"]
+	if redirectURI == "" {
+		redirectURI, _ = resolveRedirectURI(clientsWithID(r, client.ID), "")
+	}
+
+	token, err := cache.NewAuthCodeToken(client.ID, params["code"], "", redirectURI, params["code_verifier"], lifetimesFor(r, client, config.AuthCode))
+	if err == cache.ErrInvalidCodeVerifier || err == cache.ErrMissingCodeVerifier || err == cache.ErrAuthCodeClientMismatch {
+		utils.ShowJSONError(w, r, 400, utils.RequestError{
+			Error: "invalid_grant",
+			Desc:  err.Error(),
+		})
+		return
+	} else if err != nil {
 		utils.ShowJSONError(w, r, 400, utils.RequestError{
 			Error: "invalid_request",
 			Desc:  err.Error(),
@@ -48,6 +117,23 @@ func handleAuthCodeToken(w http.ResponseWriter, r *http.Request, params map[stri
 		return
 	}
 
+	token.AccessToken, err = formatAccessToken(r, client, config.AuthCode, token.AccessToken, token.Scope, token.ExpiresIn)
+
+	// An ID token is issued along with the access token for OpenID Connect authentication requests
+	// Refer OpenID Connect Core 1.0 Section 3.1.3.3 (https://openid.net/specs/openid-connect-core-1_0.html#TokenResponse)
+	if err == nil && hasOpenIDScope(token.Scope) {
+		token.IDToken, err = newIDToken(r, client.ID, token.Nonce, token.AuthTime, token.AccessToken, token.ExpiresIn)
+	}
+
+	if err != nil {
+		utils.ShowJSONError(w, r, 500, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Token generation failed. Please try again.",
+		})
+		return
+	}
+
+	metrics.TokensIssued.Inc(cache.AuthCodeFlowName, config.AuthCodeGrant)
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	jsonBytes, err := json.Marshal(token)
 
@@ -55,26 +141,37 @@ func handleAuthCodeToken(w http.ResponseWriter, r *http.Request, params map[stri
 }
 
 // Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
-func handleAuthCodeRefresh(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	// If found, invalidate previously issued token
-	if cache.AuthCodeRefreshTokenExists(params["refresh_token"], true) {
-		token, err := cache.NewAuthCodeRefreshToken(params["refresh_token"])
-		if err != nil {
-			utils.ShowJSONError(w, r, 500, utils.RequestError{
-				Error: "Internal Server Error",
-				Desc:  "Token generation failed. Please try again.",
-			})
-			return
-		}
+// The previously issued token is invalidated if the refresh token is found.
+// The scope, if present, may only narrow the scope originally granted.
+// client is the client to which the refresh token was issued.
+// A new refresh token is issued in place of the one presented if rotation is enabled for the client.
+func handleAuthCodeRefresh(w http.ResponseWriter, r *http.Request, client *config.Client, params map[string]string) {
+	token, err := cache.NewAuthCodeRefreshToken(params["refresh_token"], params["scope"], requestConfig(r).RotatesRefreshTokens(*client), lifetimesFor(r, client, config.AuthCode))
+	if err == nil {
+		token.AccessToken, err = formatAccessToken(r, client, config.AuthCode, token.AccessToken, token.Scope, token.ExpiresIn)
+	}
 
+	switch err {
+	case nil:
+		metrics.TokensIssued.Inc(cache.AuthCodeFlowName, config.RefreshTokenGrant)
 		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
-		jsonBytes, err := json.Marshal(token)
+		jsonBytes, _ := json.Marshal(token)
 
 		fmt.Fprintln(w, string(jsonBytes))
-	} else {
+	case cache.ErrInvalidRefreshToken:
 		utils.ShowJSONError(w, r, 400, utils.RequestError{
 			Error: "invalid_refresh_token",
-			Desc:  "expired or invalid refresh token",
+			Desc:  err.Error(),
+		})
+	case cache.ErrInvalidScope:
+		utils.ShowJSONError(w, r, 400, utils.RequestError{
+			Error: "invalid_scope",
+			Desc:  err.Error(),
+		})
+	default:
+		utils.ShowJSONError(w, r, 500, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Token generation failed. Please try again.",
 		})
 	}
 }
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+// Errors returned when looking up the client of a request in the registry
+var (
+	errUnknownClient      = errors.New("Invalid client_id")
+	errUnauthorizedClient = errors.New("client is not allowed to use this grant type")
+)
+
+// Returns the clients registered with the ID, either in the server config or through the
+// Dynamic Client Registration endpoint. Since the per-flow layout of the config may use
+// the same client ID for several flows, there may be more than one.
+func clientsWithID(r *http.Request, clientID string) []config.Client {
+	if clientID == "" {
+		return nil
+	}
+
+	var clients []config.Client
+	for _, client := range requestConfig(r).RegisteredClients() {
+		if client.ID == clientID {
+			clients = append(clients, client)
+		}
+	}
+
+	if registration := cache.LookupClientRegistration(clientID); registration != nil {
+		clients = append(clients, registration.Client())
+	}
+
+	return clients
+}
+
+// Looks up the client with the ID which may use the grant type.
+// errUnknownClient is returned if no client with the ID is registered,
+// and errUnauthorizedClient if none of the clients with the ID may use the grant type.
+func findClient(r *http.Request, clientID, grantType string) (*config.Client, error) {
+	clients := clientsWithID(r, clientID)
+	if len(clients) == 0 {
+		return nil, errUnknownClient
+	}
+
+	for _, client := range clients {
+		if client.AllowsGrantType(grantType) {
+			return &client, nil
+		}
+	}
+
+	return nil, errUnauthorizedClient
+}
+
+// Checks the credentials against the confidential clients in the registry
+func authenticateClient(r *http.Request, clientID, clientSecret string) bool {
+	if clientSecret == "" {
+		return false
+	}
+
+	for _, client := range clientsWithID(r, clientID) {
+		if client.Secret == clientSecret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks the credentials of a request to the token endpoint against the client the grant belongs to.
+// Confidential clients must send their client_id and client_secret, while public clients,
+// which cannot authenticate, need only send a client_id matching theirs, if any.
+// Refer RFC 6749 Section 3.2.1 (https://tools.ietf.org/html/rfc6749#section-3.2.1)
+func authenticateTokenRequest(client *config.Client, params map[string]string) bool {
+	if client.IsPublic() {
+		return params["client_id"] == "" || params["client_id"] == client.ID
+	}
+
+	return params["client_id"] == client.ID && client.Authenticate(params["client_secret"])
+}
+
+// Checks if the client ID belongs to a public client, i.e. one that has
+// no client secret configured and thus cannot authenticate itself.
+func isPublicClient(r *http.Request, clientID string) bool {
+	for _, client := range clientsWithID(r, clientID) {
+		if client.IsPublic() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Presents an HTTP 401 response with the invalid_client error.
+// Refer RFC 6749 Section 5.2 (https://tools.ietf.org/html/rfc6749#section-5.2)
+func showInvalidClientError(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="OAuth 2.0 Bin"`)
+	utils.ShowJSONError(w, r, http.StatusUnauthorized, utils.RequestError{
+		Error: "invalid_client",
+		Desc:  "client authentication failed",
+	})
+}
+
+// Presents an HTTP 400 response with the unauthorized_client error,
+// sent when the client may not use the requested grant type.
+// Refer RFC 6749 Section 5.2 (https://tools.ietf.org/html/rfc6749#section-5.2)
+func showUnauthorizedClientError(w http.ResponseWriter, r *http.Request) {
+	utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+		Error: "unauthorized_client",
+		Desc:  errUnauthorizedClient.Error(),
+	})
+}
+
+// Presents the error returned by findClient as a JSON response
+func showClientLookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == errUnauthorizedClient {
+		showUnauthorizedClientError(w, r)
+		return
+	}
+
+	showInvalidClientError(w, r)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+)
+
+func TestClientRegistry(t *testing.T) {
+	cnfg := &config.OA2Config{
+		ImplicitCnfg: config.ImplicitConfig{ClientID: "spa"},
+		ROPCCnfg:     config.ROPCConfig{Username: "user", Password: "pass", ClientID: "ropc", ClientSecret: "ropc-secret"},
+		Clients: []config.Client{{
+			ID:           "svc",
+			Secret:       "svc-secret",
+			GrantTypes:   []string{config.ClientCredsGrant},
+			RedirectURIs: []string{"https://svc.example/callback"},
+			Scopes:       []string{"read"},
+		}},
+	}
+	handleToken := withScope(cnfg, nil, handleToken)
+
+	ropc := url.Values{
+		"grant_type": {"password"},
+		"username":   {"user"},
+		"password":   {"pass"},
+		"client_id":  {"spa"},
+	}
+
+	// A client registered for the Implicit flow only may not use the ROPC flow
+	recorder := postForm(handleToken, "/token", ropc)
+	if errorCode(recorder) != "unauthorized_client" {
+		t.Fatalf("HTTP %d: client used a grant type it is not allowed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	ropc.Set("client_id", "ropc")
+	ropc.Set("client_secret", "ropc-secret")
+	recorder = postForm(handleToken, "/token", ropc)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: ROPC token request failed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	clientCreds := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"svc"},
+		"client_secret": {"svc-secret"},
+		"scope":         {"write"},
+	}
+
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if errorCode(recorder) != "invalid_scope" {
+		t.Fatalf("HTTP %d: scope not allowed for the client was granted", recorder.Code)
+	}
+
+	clientCreds.Set("client_secret", "wrong-secret")
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if recorder.Code != http.StatusUnauthorized || errorCode(recorder) != "invalid_client" {
+		t.Fatalf("HTTP %d: client authenticated with a wrong secret", recorder.Code)
+	}
+
+	clientCreds.Set("client_secret", "svc-secret")
+	clientCreds.Del("scope")
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: client credentials token request failed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var token cache.ClientCredentialsToken
+	err := json.Unmarshal(recorder.Body.Bytes(), &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is reported as issued to the client which requested it
+	recorder = introspect(cnfg, nil, "svc", "svc-secret", token.AccessToken)
+
+	var info cache.TokenInfo
+	json.Unmarshal(recorder.Body.Bytes(), &info)
+	if info.ClientID != "svc" {
+		t.Fatalf("Unexpected client_id on introspection: %s", recorder.Body.String())
+	}
+
+	// The error pages are rendered from the templates relative to the project root
+	err = os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("oauth2/server")
+
+	// The only registered redirect URI is used when none is sent, and errors are sent to it
+	recorder = httptest.NewRecorder()
+	withScope(cnfg, nil, handleAuth)(recorder, httptest.NewRequest(http.MethodGet, "/authorize?response_type=token&client_id=svc", nil))
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	if recorder.Code != http.StatusSeeOther || !strings.Contains(location.Fragment, "error=unauthorized_client") {
+		t.Fatalf("HTTP %d: client used the Implicit flow without being allowed: %s", recorder.Code, location)
+	}
+
+	// The configuration is replaced rather than modified, as on reload
+	allowed := *cnfg
+	allowed.Clients = []config.Client{cnfg.Clients[0]}
+	allowed.Clients[0].GrantTypes = []string{config.ClientCredsGrant, config.ImplicitGrant}
+	handleAuth := withScope(&allowed, nil, handleAuth)
+
+	recorder = httptest.NewRecorder()
+	handleAuth(recorder, httptest.NewRequest(http.MethodGet, "/authorize?response_type=token&client_id=svc&redirect_uri=https://evil.example/", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("HTTP %d: unregistered redirect_uri accepted", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handleAuth(recorder, httptest.NewRequest(http.MethodGet, "/authorize?response_type=token&client_id=svc&redirect_uri=https://svc.example/callback", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: registered redirect_uri rejected", recorder.Code)
+	}
+}
+
+func TestAuthCodeClientAuthentication(t *testing.T) {
+	handleToken := withScope(&config.OA2Config{Clients: []config.Client{{
+		ID:           "web",
+		Secret:       "web-secret",
+		GrantTypes:   []string{config.AuthCodeGrant},
+		RedirectURIs: []string{"https://web.example/callback"},
+	}, {
+		ID:           "spa",
+		GrantTypes:   []string{config.AuthCodeGrant},
+		RedirectURIs: []string{"https://spa.example/callback"},
+	}}}, nil, handleToken)
+
+	code := cache.NewAuthCodeGrant("web", "https://web.example/callback", "", "", cache.PKCEChallenge{}, config.DefaultLifetimes)
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {"https://web.example/callback"},
+		"client_id":    {"web"},
+	}
+
+	// Confidential clients must authenticate
+	for _, secret := range []string{"", "wrong-secret"} {
+		form.Set("client_secret", secret)
+		recorder := postForm(handleToken, "/token", form)
+		if recorder.Code != http.StatusUnauthorized || errorCode(recorder) != "invalid_client" ||
+			recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("HTTP %d: code redeemed with client_secret %q: %s", recorder.Code, secret, recorder.Body.String())
+		}
+	}
+
+	// Another client may not redeem the code, even a public one
+	form.Set("client_id", "spa")
+	form.Del("client_secret")
+	recorder := postForm(handleToken, "/token", form)
+	if errorCode(recorder) != "invalid_grant" {
+		t.Fatalf("HTTP %d: code redeemed by another client: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The credentials may be sent in the Basic Authorization header
+	form.Del("client_id")
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("web", "web-secret")
+
+	recorder = httptest.NewRecorder()
+	handleToken(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: code not redeemed with Basic authentication: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestClientLifetimes(t *testing.T) {
+	cnfg := &config.OA2Config{
+		ClientCredsCnfg: config.ClientCredsConfig{
+			ClientID:     "cc",
+			ClientSecret: "cc-secret",
+			Lifetimes:    config.Lifetimes{AccessToken: 120},
+		},
+		Clients: []config.Client{{
+			ID:         "svc",
+			Secret:     "svc-secret",
+			GrantTypes: []string{config.ClientCredsGrant},
+			Lifetimes:  config.Lifetimes{AccessToken: 60},
+		}},
+	}
+	handleToken := withScope(cnfg, nil, handleToken)
+
+	// The lifetime configured for the client overrides that of the flow, which overrides the default
+	expected := map[string]int{"svc": 60, "cc": 120}
+	for clientID, expiresIn := range expected {
+		recorder := postForm(handleToken, "/token", url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {clientID + "-secret"},
+		})
+
+		var token cache.ClientCredentialsToken
+		json.Unmarshal(recorder.Body.Bytes(), &token)
+		if token.ExpiresIn != expiresIn {
+			t.Fatalf("HTTP %d: token issued to %s with expires_in %d, expected %d", recorder.Code, clientID, token.ExpiresIn, expiresIn)
+		}
+	}
+
+	lifetimes := cnfg.LifetimesFor(cnfg.Clients[0], config.ClientCreds)
+	if lifetimes.RefreshToken != config.DefaultLifetimes.RefreshToken || lifetimes.AuthCode != config.DefaultLifetimes.AuthCode {
+		t.Fatalf("Lifetimes not configured fell back incorrectly: %+v", lifetimes)
+	}
+}
@@ -7,21 +7,44 @@ import (
 	"net/http"
 
 	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/metrics"
 	"oauth2bin/oauth2/utils"
 )
 
+// Checks if client_id and client_secret are those of a confidential client registered for the flow.
+// If the client fails to authenticate, an invalid_client error is sent.
+// If the client may not use the flow, an unauthorized_client error is sent.
+// If yes, an access token is issued with the requested scope, if allowed for the client.
+// Refer: https://tools.ietf.org/html/rfc6749#section-4.4.2
 func handleClientCredsToken(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	if params["client_id"] != serverConfig.ClientCredsCnfg.ClientID ||
-		params["client_secret"] != serverConfig.ClientCredsCnfg.ClientSecret {
+	client, err := findClient(r, params["client_id"], config.ClientCredsGrant)
+	if err != nil {
+		showClientLookupError(w, r, err)
+		return
+	}
+
+	// Refer RFC 6749 Section 4.4 (https://tools.ietf.org/html/rfc6749#section-4.4)
+	if client.IsPublic() || !authenticateTokenRequest(client, params) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	scope, err := utils.NarrowScope(params["scope"], client.Scopes)
+	if err != nil {
 		utils.ShowJSONError(w, r, 400, utils.RequestError{
-			Error: "invalid_request",
-			Desc:  "client_id and client_secret are missing or invalid",
+			Error: "invalid_scope",
+			Desc:  err.Error(),
 		})
 		return
 	}
 
 	// If everything checks out, issue the token
-	token, err := cache.NewClientCredsToken()
+	token, err := cache.NewClientCredsToken(client.ID, scope, lifetimesFor(r, client, config.ClientCreds))
+	if err == nil {
+		token.AccessToken, err = formatAccessToken(r, client, config.ClientCreds, token.AccessToken, token.Scope, token.ExpiresIn)
+	}
+
 	if err != nil {
 		log.Println(err)
 		utils.ShowJSONError(w, r, 500, utils.RequestError{
@@ -31,6 +54,7 @@ func handleClientCredsToken(w http.ResponseWriter, r *http.Request, params map[s
 		return
 	}
 
+	metrics.TokensIssued.Inc(cache.ClientCredsFlowName, config.ClientCredsGrant)
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	jsonBytes, err := json.Marshal(token)
 
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/metrics"
+	"oauth2bin/oauth2/utils"
+)
+
+// handleDeviceAuthorization issues a device code and a user code to the device client.
+// If the client_id is unrecognized, an HTTP 401 response is sent, or an HTTP 400 response
+// if the client may not use the flow.
+// If the requested scope is not allowed for the client, an HTTP 400 response is sent.
+// Refer RFC 8628 Section 3.1 (https://tools.ietf.org/html/rfc8628#section-3.1)
+func handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseClientRequest(w, r)
+	if !ok {
+		return
+	}
+
+	client, err := findClient(r, params["client_id"], config.DeviceGrant)
+	if err != nil {
+		showClientLookupError(w, r, err)
+		return
+	}
+
+	scope, err := utils.NarrowScope(params["scope"], client.Scopes)
+	if err != nil {
+		utils.ShowJSONError(w, r, 400, utils.RequestError{
+			Error: "invalid_scope",
+			Desc:  err.Error(),
+		})
+		return
+	}
+
+	authorization, err := cache.NewDeviceAuthorization(client.ID, scope)
+	if err != nil {
+		log.Println(err)
+		utils.ShowJSONError(w, r, 500, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Device code generation failed. Please try again.",
+		})
+		return
+	}
+
+	authorization.VerificationURI = requestConfig(r).BaseURL + "/device"
+	authorization.VerificationURIComplete = authorization.VerificationURI + "?user_code=" + url.QueryEscape(authorization.UserCode)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(authorization)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+// handleDevice is the verification page of the Device Authorization flow.
+// The user enters the user code displayed by the device, and is then presented
+// the authorization screen listing the scope requested by the device.
+// Refer RFC 8628 Section 3.3 (https://tools.ietf.org/html/rfc8628#section-3.3)
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ShowError(w, r, 405, "Method Not Allowed", r.Method+" not allowed.")
+		return
+	}
+
+	userCode := r.URL.Query().Get("user_code")
+	if userCode == "" {
+		utils.PresentDeviceScreen(w, r, http.StatusOK, "")
+		return
+	}
+
+	scope, err := cache.LookupDeviceUserCode(userCode)
+	if err != nil {
+		utils.PresentDeviceScreen(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.PresentAuthScreen(w, r, config.Device, scope, "")
+}
+
+// Invoked by handleResponse when the user approves or denies a device authorization request
+func handleDeviceResponse(w http.ResponseWriter, r *http.Request, approved bool) {
+	err := cache.ResolveDeviceAuthorization(r.FormValue("user_code"), approved)
+	if err != nil {
+		utils.PresentDeviceScreen(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "The device has been denied access. You may close this window."
+	if approved {
+		message = "The device has been connected. You may return to your device."
+	}
+
+	utils.PresentDeviceScreen(w, r, http.StatusOK, message)
+}
+
+// handleDeviceToken issues the access token to a device polling the token endpoint
+// once the user has approved the request.
+// Refer RFC 8628 Section 3.4 (https://tools.ietf.org/html/rfc8628#section-3.4)
+func handleDeviceToken(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	client, err := findClient(r, params["client_id"], config.DeviceGrant)
+	if err != nil {
+		showClientLookupError(w, r, err)
+		return
+	}
+
+	if !authenticateTokenRequest(client, params) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	if params["device_code"] == "" {
+		utils.ShowJSONError(w, r, 400, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "device_code is required",
+		})
+		return
+	}
+
+	token, err := cache.NewDeviceToken(client.ID, params["device_code"], lifetimesFor(r, client, config.Device))
+	if err == nil {
+		token.AccessToken, err = formatAccessToken(r, client, config.Device, token.AccessToken, token.Scope, token.ExpiresIn)
+	}
+
+	// Refer RFC 8628 Section 3.5 (https://tools.ietf.org/html/rfc8628#section-3.5)
+	var errorCode string
+	switch err {
+	case nil:
+		metrics.TokensIssued.Inc(cache.DeviceFlowName, config.DeviceGrant)
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		jsonBytes, _ := json.Marshal(token)
+
+		fmt.Fprintln(w, string(jsonBytes))
+		return
+	case cache.ErrAuthorizationPending:
+		errorCode = "authorization_pending"
+	case cache.ErrSlowDown:
+		errorCode = "slow_down"
+	case cache.ErrExpiredToken:
+		errorCode = "expired_token"
+	case cache.ErrAccessDenied:
+		errorCode = "access_denied"
+	case cache.ErrInvalidDeviceCode:
+		errorCode = "invalid_grant"
+	default:
+		log.Println(err)
+		utils.ShowJSONError(w, r, 500, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Token generation failed. Please try again.",
+		})
+		return
+	}
+
+	utils.ShowJSONError(w, r, 400, utils.RequestError{
+		Error: errorCode,
+		Desc:  err.Error(),
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+// Makes a request with the form-encoded body to the handler and returns the response
+func postForm(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+// Returns the error code of a JSON error response
+func errorCode(recorder *httptest.ResponseRecorder) string {
+	var body utils.RequestError
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return body.Error
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	cnfg := &config.OA2Config{
+		BaseURL:    "https://oauth2bin.org",
+		DeviceCnfg: config.DeviceConfig{ClientID: "tv", Scopes: []string{"read"}},
+	}
+	handleDeviceAuthorization := withScope(cnfg, nil, handleDeviceAuthorization)
+	handleToken := withScope(cnfg, nil, handleToken)
+	handleResponse := withScope(cnfg, nil, handleResponse)
+
+	recorder := postForm(handleDeviceAuthorization, "/device_authorization", url.Values{"client_id": {"unknown"}})
+	if recorder.Code != http.StatusUnauthorized || errorCode(recorder) != "invalid_client" {
+		t.Fatalf("HTTP %d: unknown client issued a device code", recorder.Code)
+	}
+
+	recorder = postForm(handleDeviceAuthorization, "/device_authorization", url.Values{"client_id": {"tv"}, "scope": {"write"}})
+	if errorCode(recorder) != "invalid_scope" {
+		t.Fatalf("HTTP %d: scope not allowed for the client was granted", recorder.Code)
+	}
+
+	recorder = postForm(handleDeviceAuthorization, "/device_authorization", url.Values{"client_id": {"tv"}})
+
+	var authorization cache.DeviceAuthorization
+	err := json.Unmarshal(recorder.Body.Bytes(), &authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authorization.VerificationURI != "https://oauth2bin.org/device" ||
+		!strings.HasSuffix(authorization.VerificationURIComplete, url.QueryEscape(authorization.UserCode)) {
+		t.Fatalf("Unexpected verification URIs: %+v", authorization)
+	}
+
+	poll := url.Values{
+		"grant_type":  {cache.DeviceFlowName},
+		"client_id":   {"tv"},
+		"device_code": {authorization.DeviceCode},
+	}
+
+	recorder = postForm(handleToken, "/token", poll)
+	if recorder.Code != http.StatusBadRequest || errorCode(recorder) != "authorization_pending" {
+		t.Fatalf("HTTP %d: expected authorization_pending: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The confirmation page is rendered from the templates relative to the project root
+	err = os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("oauth2/server")
+
+	// The user approves the request on the authorization screen
+	recorder = postForm(handleResponse, "/response", url.Values{
+		"flow":      {strconv.Itoa(config.Device)},
+		"response":  {"ACCEPT"},
+		"user_code": {authorization.UserCode},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: device authorization could not be approved", recorder.Code)
+	}
+
+	recorder = postForm(handleToken, "/token", poll)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), cache.DeviceFlowID) {
+		t.Fatalf("HTTP %d: token not issued: %s", recorder.Code, recorder.Body.String())
+	}
+}
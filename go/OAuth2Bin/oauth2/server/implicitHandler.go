@@ -2,21 +2,68 @@ package server
 
 import (
 	"net/http"
-	
+
+	"oauth2bin/oauth2/cache"
 	"oauth2bin/oauth2/config"
 	"oauth2bin/oauth2/utils"
 )
 
-func handleImplicitAuth(w http.ResponseWriter, r *http.Request) {
+// handleImplicitAuth looks up the client_id of the query parameters in the client registry.
+// The redirect URI was already validated by handleAuth, hence errors are sent to its fragment:
+// If the client may not use the Implicit flow, unauthorized_client is sent.
+// If the requested scope is not allowed for the client, invalid_scope is sent.
+// If an ID token is requested without a nonce or the openid scope, invalid_request or invalid_scope is sent.
+// Else, the request is stored and an authorization screen is presented to the user.
+func handleImplicitAuth(w http.ResponseWriter, r *http.Request, redirectURI string) {
 	queryParams := r.URL.Query()
-	clientID := queryParams.Get("client_id")
-
-	switch clientID {
-	case "":
-		utils.ShowError(w, r, 400, "Bad Request", "client_id is required")
-	case serverConfig.AuthCodeCnfg.ClientID:
-		utils.PresentAuthScreen(w, r, config.Implicit)
-	default:
-		utils.ShowError(w, r, 401, "Unauthorized", "Invalid client_id")
+	redirect := authRedirect{URI: redirectURI, InFragment: true, State: queryParams.Get("state")}
+
+	client, err := findClient(r, queryParams.Get("client_id"), config.ImplicitGrant)
+	if err != nil {
+		redirect.sendError(w, r, "unauthorized_client", err.Error())
+		return
+	}
+
+	// The redirect URI may have been registered by another client with the same ID
+	if !client.AllowsRedirectURI(redirectURI) {
+		utils.ShowError(w, r, 400, "Bad Request", errInvalidRedirectURI.Error())
+		return
+	}
+
+	scope, err := utils.NarrowScope(queryParams.Get("scope"), client.Scopes)
+	if err != nil {
+		redirect.sendError(w, r, "invalid_scope", err.Error())
+		return
 	}
+
+	// ID tokens are only issued for OpenID Connect authentication requests, which must carry a nonce
+	// Refer OpenID Connect Core 1.0 Section 3.2.2.1 (https://openid.net/specs/openid-connect-core-1_0.html#ImplicitAuthRequest)
+	responseType := normalizeResponseType(queryParams.Get("response_type"))
+	if responseTypeIncludes(responseType, "id_token") {
+		if queryParams.Get("nonce") == "" {
+			redirect.sendError(w, r, "invalid_request", "nonce is required for response_type "+responseType)
+			return
+		}
+
+		if !hasOpenIDScope(scope) {
+			redirect.sendError(w, r, "invalid_scope", "the openid scope is required for response_type "+responseType)
+			return
+		}
+	}
+
+	requestID, err := cache.NewAuthorizationRequest(cache.AuthorizationRequest{
+		Flow:         config.Implicit,
+		ClientID:     client.ID,
+		RedirectURI:  redirectURI,
+		ResponseType: responseType,
+		Scope:        scope,
+		State:        queryParams.Get("state"),
+		Nonce:        queryParams.Get("nonce"),
+	})
+	if err != nil {
+		redirect.sendError(w, r, "server_error", "An error occurred while processing your request.")
+		return
+	}
+
+	utils.PresentAuthScreen(w, r, config.Implicit, scope, requestID)
 }
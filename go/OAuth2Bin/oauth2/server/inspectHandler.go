@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/middleware"
+	"oauth2bin/oauth2/utils"
+)
+
+// Route of the request inspector page, under which its streams and exports are served
+const inspectorRoute = "/inspect"
+
+// How often a comment is sent down an idle stream of recorded requests, so that proxies keep it open
+const inspectorKeepAlive = 15 * time.Second
+
+// handleInspector serves the request inspector page, listing the requests recorded in a bin
+// as they come in. The page of the bin named by the bin parameter is served, and the bin is
+// set as the cookie of the browser, so that the requests it makes to the other routes are recorded.
+// Without it, the user is redirected to the bin of the cookie, or to a new bin if there is none
+// or the new parameter is present.
+func (s *OA2Server) handleInspector(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ShowError(w, r, 405, "Method Not Allowed", r.Method+" not allowed.")
+		return
+	}
+
+	query := r.URL.Query()
+	binID := query.Get(middleware.BinParam)
+	if binID == "" {
+		redirectToBin(w, r, query.Get("new") == "")
+		return
+	}
+
+	_, err := cache.BinRequests(binID)
+	if err == cache.ErrUnknownBin {
+		utils.ShowError(w, r, http.StatusNotFound, "Bin Not Found", "The bin has expired or never existed.")
+		return
+	} else if err != nil {
+		utils.ShowError(w, r, http.StatusInternalServerError, "Internal Server Error", "The bin could not be read.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.BinCookie,
+		Value:    binID,
+		Path:     "/",
+		MaxAge:   int(cache.BinLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	tmpl, err := template.ParseFiles(
+		"public/templates/inspector.html",
+		"public/templates/nav.html",
+		"public/templates/footer.html",
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = tmpl.ExecuteTemplate(w, "inspector", struct {
+		BinID   string
+		BaseURL string
+	}{BinID: binID, BaseURL: requestConfig(r).BaseURL})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Redirects the user to the page of the bin of the cookie, if it still exists and is to be kept,
+// or to the page of a new bin
+func redirectToBin(w http.ResponseWriter, r *http.Request, keepCookie bool) {
+	binID := ""
+	if cookie, err := r.Cookie(middleware.BinCookie); err == nil && keepCookie {
+		if _, err := cache.BinRequests(cookie.Value); err == nil {
+			binID = cookie.Value
+		}
+	}
+
+	if binID == "" {
+		var err error
+		binID, err = cache.NewBin()
+		if err != nil {
+			utils.ShowError(w, r, http.StatusInternalServerError, "Internal Server Error", "A bin could not be created.")
+			return
+		}
+	}
+
+	http.Redirect(w, r, inspectorRoute+"?"+url.Values{middleware.BinParam: {binID}}.Encode(), http.StatusFound)
+}
+
+// handleInspectorExport sends the requests recorded in the bin as a JSON array, oldest first
+func handleInspectorExport(w http.ResponseWriter, r *http.Request) {
+	binID, requests, ok := readBin(w, r)
+	if !ok {
+		return
+	}
+
+	if requests == nil {
+		requests = []cache.BinRequest{}
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bin-%s.json"`, binID))
+	jsonBytes, _ := json.Marshal(requests)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+// handleInspectorEvents streams the requests recorded in the bin from now on as server-sent events,
+// each a "request" event holding the request as JSON, until the client goes away or the server shuts down.
+// Refer https://html.spec.whatwg.org/multipage/server-sent-events.html
+func (s *OA2Server) handleInspectorEvents(w http.ResponseWriter, r *http.Request) {
+	binID, _, ok := readBin(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+			Error: "server_error",
+			Desc:  "streaming is not supported",
+		})
+		return
+	}
+
+	requests, unsubscribe := s.Recorder.Subscribe(binID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(inspectorKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case request, open := <-requests:
+			if !open {
+				return
+			}
+
+			jsonBytes, _ := json.Marshal(request)
+			fmt.Fprintf(w, "event: request\ndata: %s\n\n", jsonBytes)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// Reads the bin named by the bin parameter of a GET request.
+// If the request is invalid or the bin is not found, the error is sent and ok is false.
+func readBin(w http.ResponseWriter, r *http.Request) (binID string, requests []cache.BinRequest, ok bool) {
+	if r.Method != http.MethodGet {
+		utils.ShowJSONError(w, r, http.StatusMethodNotAllowed, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  r.Method + " not allowed",
+		})
+		return "", nil, false
+	}
+
+	binID = r.URL.Query().Get(middleware.BinParam)
+	requests, err := cache.BinRequests(binID)
+	if err == cache.ErrUnknownBin {
+		utils.ShowJSONError(w, r, http.StatusNotFound, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  err.Error(),
+		})
+		return "", nil, false
+	} else if err != nil {
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+			Error: "server_error",
+			Desc:  "the bin could not be read",
+		})
+		return "", nil, false
+	}
+
+	return binID, requests, true
+}
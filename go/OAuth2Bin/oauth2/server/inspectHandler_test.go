@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+)
+
+func TestInspector(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	// A new bin is created for the browser, and set as its cookie
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Get(ts.URL + "/inspect")
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("Not redirected to a new bin: %v", err)
+	}
+	res.Body.Close()
+
+	location, _ := url.Parse(res.Header.Get("Location"))
+	binID := location.Query().Get("bin")
+	if _, err := cache.BinRequests(binID); err != nil {
+		t.Fatalf("Bin %s not created: %s", binID, err)
+	}
+
+	res, err = client.Get(ts.URL + location.String())
+	if err != nil || res.StatusCode != http.StatusOK || len(res.Cookies()) != 1 || res.Cookies()[0].Value != binID {
+		t.Fatalf("Inspector page not served: %v", err)
+	}
+	res.Body.Close()
+
+	res, _ = client.Get(ts.URL + "/inspect?bin=unknown")
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("HTTP %d: page of an unknown bin served", res.StatusCode)
+	}
+	res.Body.Close()
+
+	// The requests recorded from now on are streamed
+	events, err := http.Get(ts.URL + "/inspect/events?bin=" + binID)
+	if err != nil || events.StatusCode != http.StatusOK || events.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Could not stream the requests: %v", err)
+	}
+	defer events.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Bin-ID", binID)
+	req.SetBasicAuth("clientID", "clientSecret")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get a token: %v", err)
+	}
+	res.Body.Close()
+
+	reader := bufio.NewReader(events.Body)
+	if line, _ := reader.ReadString('\n'); line != "event: request\n" {
+		t.Fatalf("Unexpected event: %q", line)
+	}
+
+	line, _ := reader.ReadString('\n')
+	var streamed cache.BinRequest
+	err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &streamed)
+	if err != nil || streamed.Path != "/token" || streamed.Status != http.StatusOK {
+		t.Fatalf("Unexpected request streamed: %s", line)
+	}
+
+	// The requests to the inspector are not recorded
+	res, err = http.Get(ts.URL + "/inspect/export?bin=" + binID)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not export the bin: %v", err)
+	}
+
+	var exported []cache.BinRequest
+	json.NewDecoder(res.Body).Decode(&exported)
+	res.Body.Close()
+	if len(exported) != 1 || exported[0].Path != "/token" || !strings.Contains(exported[0].Response, `"access_token":"********"`) {
+		t.Fatalf("Unexpected requests exported: %+v", exported)
+	}
+
+	res, _ = http.Get(ts.URL + "/inspect/export?bin=unknown")
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("HTTP %d: unknown bin exported", res.StatusCode)
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/utils"
+)
+
+// handleIntrospect returns the state of an access or refresh token to an authenticated client.
+// Refer RFC 7662 Section 2 (https://tools.ietf.org/html/rfc7662#section-2)
+// Accepts only POST requests with application/x-www-form-urlencoded body.
+func handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseClientRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !authenticateClient(r, params["client_id"], params["client_secret"]) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	if params["token"] == "" {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "token is required",
+		})
+		return
+	}
+
+	info := cache.IntrospectToken(resolveAccessToken(r, params["token"]), params["token_type_hint"])
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(info)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
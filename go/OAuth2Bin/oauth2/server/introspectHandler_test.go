@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+)
+
+// Makes an introspection request for the token with the given client credentials
+// to a server with the configuration and signing key
+func introspect(cnfg *config.OA2Config, signer *jwt.Signer, clientID, clientSecret, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader("token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, handleIntrospect)(recorder, req)
+	return recorder
+}
+
+func TestIntrospect(t *testing.T) {
+	cnfg := &config.OA2Config{ClientCredsCnfg: config.ClientCredsConfig{ClientID: "rs", ClientSecret: "rs-secret"}}
+
+	token, err := cache.NewClientCredsToken("rs", "read", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := introspect(cnfg, nil, "rs", "wrong", token.AccessToken)
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("HTTP %d: unauthenticated client allowed to introspect", recorder.Code)
+	}
+
+	recorder = introspect(cnfg, nil, "rs", "rs-secret", token.AccessToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: introspection failed", recorder.Code)
+	}
+
+	var info cache.TokenInfo
+	err = json.Unmarshal(recorder.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Active || info.ClientID != "rs" || info.Scope != "read" || info.Flow != cache.ClientCredsFlowName {
+		t.Fatalf("Unexpected introspection response: %s", recorder.Body.String())
+	}
+
+	recorder = introspect(cnfg, nil, "rs", "rs-secret", "CLICREDSunknown")
+	if strings.TrimSpace(recorder.Body.String()) != `{"active":false}` {
+		t.Fatalf("Unexpected response for unknown token: %s", recorder.Body.String())
+	}
+}
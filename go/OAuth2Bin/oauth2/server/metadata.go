@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+// Metadata field holding the URL of the endpoint served at each route.
+// Endpoints are only advertised if their route was registered in setupRoutes.
+var endpointMetadata = map[string]string{
+	"/authorize":             "authorization_endpoint",
+	"/token":                 "token_endpoint",
+	"/introspect":            "introspection_endpoint",
+	"/revoke":                "revocation_endpoint",
+	"/device_authorization":  "device_authorization_endpoint",
+	"/userinfo":              "userinfo_endpoint",
+	"/register":              "registration_endpoint",
+	"/.well-known/jwks.json": "jwks_uri",
+}
+
+// Grant types made available by each route
+var routeGrantTypes = map[string][]string{
+	"/authorize": {"authorization_code", "implicit"},
+	"/token":     {"password", "client_credentials", "refresh_token"},
+
+	"/device_authorization": {cache.DeviceFlowName},
+}
+
+// Response types accepted by the authorization endpoint
+var responseTypes = []string{"code", "token", "id_token", "id_token token"}
+
+// Client authentication methods accepted by the endpoints which authenticate clients.
+// "none" denotes public clients which only identify themselves with their client_id.
+var clientAuthMethods = []string{"client_secret_basic", "client_secret_post", "none"}
+
+// Returns the authorization server metadata generated from the base URL of the configuration
+// and the routes registered on the server.
+// Refer RFC 8414 Section 2 (https://tools.ietf.org/html/rfc8414#section-2)
+func (s *OA2Server) metadata(cnfg *config.OA2Config) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"issuer": cnfg.BaseURL,
+	}
+
+	var grantTypes []string
+	for _, route := range s.routes {
+		if field, found := endpointMetadata[route]; found {
+			metadata[field] = cnfg.BaseURL + route
+		}
+
+		grantTypes = append(grantTypes, routeGrantTypes[route]...)
+	}
+
+	if len(grantTypes) > 0 {
+		metadata["grant_types_supported"] = grantTypes
+	}
+
+	if _, found := metadata["authorization_endpoint"]; found {
+		metadata["response_types_supported"] = responseTypes
+		metadata["code_challenge_methods_supported"] = []string{cache.PKCEPlain, cache.PKCES256}
+	}
+
+	for _, endpoint := range []string{"token", "introspection", "revocation"} {
+		if _, found := metadata[endpoint+"_endpoint"]; found {
+			metadata[endpoint+"_endpoint_auth_methods_supported"] = clientAuthMethods
+		}
+	}
+
+	if scopes := supportedScopes(cnfg); len(scopes) > 0 {
+		metadata["scopes_supported"] = scopes
+	}
+
+	return metadata
+}
+
+// Returns the scopes allowed for any of the clients registered in the configuration
+func supportedScopes(cnfg *config.OA2Config) []string {
+	var scopes []string
+	for _, client := range cnfg.RegisteredClients() {
+		scopes = append(scopes, client.Scopes...)
+	}
+
+	return utils.ParseScope(strings.Join(scopes, " "))
+}
+
+// handleMetadata serves the authorization server metadata document
+// Refer RFC 8414 Section 3 (https://tools.ietf.org/html/rfc8414#section-3)
+func (s *OA2Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(s.metadata(requestConfig(r)))
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
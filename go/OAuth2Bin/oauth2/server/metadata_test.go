@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2bin/oauth2/config"
+)
+
+func TestMetadata(t *testing.T) {
+	cnfg := &config.OA2Config{
+		BaseURL:         "https://oauth2bin.org",
+		AuthCodeCnfg:    config.AuthCodeConfig{ClientID: "ac", Scopes: []string{"openid", "read"}},
+		ClientCredsCnfg: config.ClientCredsConfig{ClientID: "cc", Scopes: []string{"read", "write"}},
+	}
+
+	s := &OA2Server{routes: []string{"/", "/authorize", "/token", "/echo"}}
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, nil, s.handleMetadata)(recorder, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil))
+
+	var metadata struct {
+		Issuer                string   `json:"issuer"`
+		AuthorizationEndpoint string   `json:"authorization_endpoint"`
+		TokenEndpoint         string   `json:"token_endpoint"`
+		RevocationEndpoint    string   `json:"revocation_endpoint"`
+		GrantTypes            []string `json:"grant_types_supported"`
+		ResponseTypes         []string `json:"response_types_supported"`
+		TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+		Scopes                []string `json:"scopes_supported"`
+	}
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Issuer != "https://oauth2bin.org" ||
+		metadata.AuthorizationEndpoint != "https://oauth2bin.org/authorize" ||
+		metadata.TokenEndpoint != "https://oauth2bin.org/token" {
+		t.Fatalf("Unexpected endpoints: %s", recorder.Body.String())
+	}
+
+	// Endpoints whose routes were not registered must not be advertised
+	if metadata.RevocationEndpoint != "" {
+		t.Fatalf("Unregistered endpoint advertised: %s", recorder.Body.String())
+	}
+
+	if len(metadata.GrantTypes) != 5 || len(metadata.ResponseTypes) == 0 || len(metadata.TokenAuthMethods) == 0 {
+		t.Fatalf("Supported grant types, response types or auth methods missing: %s", recorder.Body.String())
+	}
+
+	if len(metadata.Scopes) != 3 {
+		t.Fatalf("Expected the union of flow scopes, got %v", metadata.Scopes)
+	}
+}
+
+func TestOpenIDConfigurationScopes(t *testing.T) {
+	cnfg, signer := newOIDCConfig(t)
+	cnfg.ClientCredsCnfg = config.ClientCredsConfig{ClientID: "cc", Scopes: []string{"read"}}
+
+	s := &OA2Server{routes: []string{"/authorize", "/token"}}
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, s.handleOpenIDConfiguration)(recorder, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+
+	var document struct {
+		Scopes []string `json:"scopes_supported"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &document)
+
+	// openid is advertised although no client lists it
+	if len(document.Scopes) != 2 || document.Scopes[0] != "read" || document.Scopes[1] != "openid" {
+		t.Fatalf("Unexpected scopes: %v", document.Scopes)
+	}
+}
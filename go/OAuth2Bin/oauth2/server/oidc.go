@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/jwt"
+	"oauth2bin/oauth2/utils"
+)
+
+// The scope which turns an authorization request into an OpenID Connect authentication request
+const openIDScope = "openid"
+
+// Returns true if the scope string contains the "openid" scope
+func hasOpenIDScope(scope string) bool {
+	return utils.IsScopeSubset([]string{openIDScope}, utils.ParseScope(scope))
+}
+
+// Returns the space-delimited values of response_type in a canonical order
+// since their order is insignificant, eg: "token id_token" becomes "id_token token".
+// Refer OAuth 2.0 Multiple Response Type Encoding Practices (https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html)
+func normalizeResponseType(responseType string) string {
+	values := strings.Fields(responseType)
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+// Returns true if the response type contains the given value
+func responseTypeIncludes(responseType, value string) bool {
+	for _, v := range strings.Fields(responseType) {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Generates a signed ID token for the configured user issued to the client.
+// at_hash is included if an access token is issued alongside the ID token.
+// Refer OpenID Connect Core 1.0 Section 2 (https://openid.net/specs/openid-connect-core-1_0.html#IDToken)
+func newIDToken(r *http.Request, clientID, nonce string, authTime int64, accessToken string, expiresIn int) (string, error) {
+	cnfg := requestConfig(r)
+
+	now := time.Now()
+	claims := jwt.IDTokenClaims{
+		Issuer:   cnfg.BaseURL,
+		Subject:  cnfg.ROPCCnfg.Username,
+		Audience: clientID,
+		Expiry:   now.Add(time.Duration(expiresIn) * time.Second).Unix(),
+		IssuedAt: now.Unix(),
+		AuthTime: authTime,
+		Nonce:    nonce,
+	}
+
+	if accessToken != "" {
+		claims.AccessTokenHash = jwt.HalfHash(accessToken)
+	}
+
+	return requestSigner(r).Sign(claims, jwt.IDTokenType)
+}
+
+// handleUserInfo returns the claims about the configured user to a client presenting
+// an access token in the Authorization header which was granted the "openid" scope.
+// The "profile" and "email" scopes release the respective claims.
+// Refer OpenID Connect Core 1.0 Section 5.3 (https://openid.net/specs/openid-connect-core-1_0.html#UserInfo)
+func handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	cnfg := requestConfig(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		utils.ShowJSONError(w, r, http.StatusMethodNotAllowed, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  r.Method + " not allowed",
+		})
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="OAuth 2.0 Bin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	info := cache.IntrospectToken(resolveAccessToken(r, strings.TrimSpace(authorization[len("Bearer "):])), cache.AccessTokenHint)
+	if !info.Active || info.TokenType != "bearer" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="OAuth 2.0 Bin", error="invalid_token"`)
+		utils.ShowJSONError(w, r, http.StatusUnauthorized, utils.RequestError{
+			Error: "invalid_token",
+			Desc:  "access token expired, revoked or invalid",
+		})
+		return
+	}
+
+	if !hasOpenIDScope(info.Scope) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="OAuth 2.0 Bin", error="insufficient_scope", scope="openid"`)
+		utils.ShowJSONError(w, r, http.StatusForbidden, utils.RequestError{
+			Error: "insufficient_scope",
+			Desc:  "access token was not granted the openid scope",
+		})
+		return
+	}
+
+	claims := map[string]interface{}{"sub": cnfg.ROPCCnfg.Username}
+	scopes := utils.ParseScope(info.Scope)
+	if utils.IsScopeSubset([]string{"profile"}, scopes) {
+		claims["name"] = cnfg.ROPCCnfg.Name
+		claims["preferred_username"] = cnfg.ROPCCnfg.Username
+	}
+
+	if utils.IsScopeSubset([]string{"email"}, scopes) {
+		claims["email"] = cnfg.ROPCCnfg.Email
+		claims["email_verified"] = true
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(claims)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
+
+// handleOpenIDConfiguration serves the OpenID Provider configuration document,
+// which extends the authorization server metadata with the OpenID Connect specific fields.
+// Refer OpenID Connect Discovery 1.0 Section 3 (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata)
+func (s *OA2Server) handleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	cnfg := requestConfig(r)
+	document := s.metadata(cnfg)
+
+	// The openid scope is supported even if no client lists it
+	document["scopes_supported"] = utils.ParseScope(strings.Join(append(supportedScopes(cnfg), openIDScope), " "))
+	document["subject_types_supported"] = []string{"public"}
+	document["id_token_signing_alg_values_supported"] = []string{requestSigner(r).Algorithm()}
+	document["claims_supported"] = []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+		"name", "preferred_username", "email", "email_verified"}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	jsonBytes, _ := json.Marshal(document)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
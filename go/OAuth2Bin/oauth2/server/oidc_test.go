@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+)
+
+const testNonce = "n-0S6_WzA2Mj"
+
+// Returns the configuration of an OpenID Provider along with a fresh signing key
+func newOIDCConfig(t *testing.T) (*config.OA2Config, *jwt.Signer) {
+	signer, err := jwt.NewSigner(jwt.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &config.OA2Config{
+		BaseURL:      "https://oauth2bin.org",
+		AuthCodeCnfg: config.AuthCodeConfig{ClientID: "ac", ClientSecret: "ac-secret"},
+		ImplicitCnfg: config.ImplicitConfig{ClientID: "imp"},
+		ROPCCnfg:     config.ROPCConfig{Username: "oa2buser", Name: "OAuth 2.0 Bin User", Email: "oa2buser@oauth2bin.org"},
+	}, signer
+}
+
+// Requests the user's claims with the given access token from a server with the configuration and signing key
+func userInfo(cnfg *config.OA2Config, signer *jwt.Signer, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, handleUserInfo)(recorder, req)
+	return recorder
+}
+
+func TestAuthCodeIDToken(t *testing.T) {
+	cnfg, signer := newOIDCConfig(t)
+
+	requestID, err := cache.NewAuthorizationRequest(cache.AuthorizationRequest{
+		Flow:         config.AuthCode,
+		ClientID:     "ac",
+		RedirectURI:  "https://oauth2bin.org/callback",
+		ResponseType: "code",
+		Scope:        "openid email",
+		Nonce:        testNonce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Set("flow", strconv.Itoa(config.AuthCode))
+	form.Set("request_id", requestID)
+	form.Set("response", "ACCEPT")
+
+	req := httptest.NewRequest(http.MethodPost, "/response", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, handleResponse)(recorder, req)
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := url.Values{}
+	body.Set("grant_type", "authorization_code")
+	body.Set("client_id", "ac")
+	body.Set("client_secret", "ac-secret")
+	body.Set("code", location.Query().Get("code"))
+	body.Set("redirect_uri", "https://oauth2bin.org/callback")
+
+	req = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder = httptest.NewRecorder()
+	withScope(cnfg, signer, handleToken)(recorder, req)
+
+	var token cache.AuthCodeToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.IDTokenClaims
+	err = signer.Verify(token.IDToken, &claims)
+	if err != nil {
+		t.Fatalf("Issued ID token is not a valid JWT: %s (%s)", err, recorder.Body.String())
+	}
+
+	if claims.Nonce != testNonce || claims.Audience != "ac" || claims.Subject != "oa2buser" ||
+		claims.AuthTime == 0 || claims.AccessTokenHash != jwt.HalfHash(token.AccessToken) {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+
+	recorder = userInfo(cnfg, signer, token.AccessToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: userinfo request failed", recorder.Code)
+	}
+
+	var info map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &info)
+	if info["sub"] != "oa2buser" || info["email"] != "oa2buser@oauth2bin.org" || info["name"] != nil {
+		t.Fatalf("Unexpected claims released: %s", recorder.Body.String())
+	}
+}
+
+func TestImplicitIDToken(t *testing.T) {
+	cnfg, signer := newOIDCConfig(t)
+
+	requestID, err := cache.NewAuthorizationRequest(cache.AuthorizationRequest{
+		Flow:         config.Implicit,
+		ClientID:     "imp",
+		RedirectURI:  "https://oauth2bin.org/callback",
+		ResponseType: "id_token token",
+		Scope:        "openid",
+		Nonce:        testNonce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Set("flow", strconv.Itoa(config.Implicit))
+	form.Set("request_id", requestID)
+	form.Set("response", "ACCEPT")
+
+	req := httptest.NewRequest(http.MethodPost, "/response", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, signer, handleResponse)(recorder, req)
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragment, err := url.ParseQuery(location.EscapedFragment())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.IDTokenClaims
+	err = signer.Verify(fragment.Get("id_token"), &claims)
+	if err != nil {
+		t.Fatalf("ID token missing or invalid in redirect %s: %s", location, err)
+	}
+
+	if claims.Nonce != testNonce || claims.Audience != "imp" || claims.AccessTokenHash != jwt.HalfHash(fragment.Get("access_token")) {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestImplicitIDTokenRequest(t *testing.T) {
+	cnfg := &config.OA2Config{Clients: []config.Client{{
+		ID:           "oidc",
+		GrantTypes:   []string{config.ImplicitGrant},
+		RedirectURIs: []string{"https://oauth2bin.org/callback"},
+		Scopes:       []string{"openid", "read"},
+	}}}
+
+	// The authorization screen is rendered from the templates relative to the project root
+	err := os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("oauth2/server")
+
+	tests := []struct {
+		responseType, scope, nonce, errorCode string
+	}{
+		{"id_token", "openid", "", "invalid_request"},
+		{"id_token token", "openid read", "", "invalid_request"},
+		{"id_token", "read", testNonce, "invalid_scope"},
+		{"token id_token", "read", testNonce, "invalid_scope"},
+		{"id_token token", "openid", testNonce, ""}, // The authorization screen is presented
+	}
+
+	for _, test := range tests {
+		recorder := authorize(cnfg, url.Values{
+			"response_type": {test.responseType},
+			"client_id":     {"oidc"},
+			"redirect_uri":  {"https://oauth2bin.org/callback"},
+			"scope":         {test.scope},
+			"nonce":         {test.nonce},
+		})
+
+		location, _ := url.Parse(recorder.Header().Get("Location"))
+		fragment, _ := url.ParseQuery(location.EscapedFragment())
+		if fragment.Get("error") != test.errorCode || (test.errorCode == "" && recorder.Code != http.StatusOK) {
+			t.Fatalf("%s with scope %q and nonce %q: expected error %q, got HTTP %d %s",
+				test.responseType, test.scope, test.nonce, test.errorCode, recorder.Code, location)
+		}
+	}
+}
+
+func TestUserInfoScope(t *testing.T) {
+	cnfg, signer := newOIDCConfig(t)
+
+	recorder := userInfo(cnfg, signer, "invalid")
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("HTTP %d: invalid access token accepted", recorder.Code)
+	}
+
+	token, err := cache.NewImplicitToken("imp", "profile", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = userInfo(cnfg, signer, token.AccessToken)
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+		t.Fatalf("HTTP %d: access token without the openid scope accepted", recorder.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"oauth2bin/oauth2/config"
+)
+
+var (
+	errMissingRedirectURI = errors.New("redirect_uri is required")
+	errInvalidRedirectURI = errors.New("Invalid redirect_uri")
+)
+
+// authRedirect describes where and how the response to an authorization request is sent.
+// Responses of the Implicit flow are sent in the fragment, else in the query component.
+// The state sent by the client, if any, is included in every response.
+// Refer RFC 6749 Section 4.1.2 (https://tools.ietf.org/html/rfc6749#section-4.1.2)
+// and Section 4.2.2 (https://tools.ietf.org/html/rfc6749#section-4.2.2)
+type authRedirect struct {
+	URI        string
+	InFragment bool
+	State      string
+}
+
+// Redirects the user-agent to the client with the response parameters
+func (ar authRedirect) send(w http.ResponseWriter, r *http.Request, params url.Values) {
+	if ar.State != "" {
+		params.Set("state", ar.State)
+	}
+
+	http.Redirect(w, r, appendResponseParams(ar.URI, params, ar.InFragment), http.StatusSeeOther)
+}
+
+// Redirects the user-agent to the client with the error and its description.
+// Refer RFC 6749 Section 4.1.2.1 (https://tools.ietf.org/html/rfc6749#section-4.1.2.1)
+func (ar authRedirect) sendError(w http.ResponseWriter, r *http.Request, errorCode, desc string) {
+	ar.send(w, r, url.Values{"error": {errorCode}, "error_description": {desc}})
+}
+
+// Returns the redirect URI with the parameters added to its fragment, or to its query component
+// while retaining the query parameters the URI was registered with.
+// Refer RFC 6749 Section 3.1.2 (https://tools.ietf.org/html/rfc6749#section-3.1.2)
+func appendResponseParams(redirectURI string, params url.Values, inFragment bool) string {
+	if inFragment {
+		return redirectURI + "#" + params.Encode()
+	}
+
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := parsed.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// Returns the redirect URI to which the response to an authorization request is to be sent.
+// The requested URI must be absolute, without a fragment, and registered for one of the clients.
+// If no URI was requested, the only one registered is used.
+// Until the redirect URI is known to be valid, errors must not be sent to it.
+// Refer RFC 6749 Section 3.1.2 (https://tools.ietf.org/html/rfc6749#section-3.1.2)
+func resolveRedirectURI(clients []config.Client, requested string) (string, error) {
+	if requested == "" {
+		registered := map[string]bool{}
+		for _, client := range clients {
+			for _, redirectURI := range client.RedirectURIs {
+				registered[redirectURI] = true
+				requested = redirectURI
+			}
+		}
+
+		if len(registered) != 1 {
+			return "", errMissingRedirectURI
+		}
+
+		return requested, nil
+	}
+
+	parsed, err := url.Parse(requested)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return "", errInvalidRedirectURI
+	}
+
+	for _, client := range clients {
+		if client.AllowsRedirectURI(requested) {
+			return requested, nil
+		}
+	}
+
+	return "", errInvalidRedirectURI
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/utils"
+)
+
+// Route under which the registered clients are managed by their registration client URI
+const clientConfigurationRoute = "/register/"
+
+// Grant types a client may register for
+var registrableGrantTypes = []string{
+	config.AuthCodeGrant, config.ImplicitGrant, config.ROPCGrant,
+	config.ClientCredsGrant, config.DeviceGrant, config.RefreshTokenGrant,
+}
+
+// Grant type the client must register for in order to use each response type
+var responseTypeGrantTypes = map[string]string{
+	"code":     config.AuthCodeGrant,
+	"token":    config.ImplicitGrant,
+	"id_token": config.ImplicitGrant,
+}
+
+// handleRegister registers a new client with the metadata sent as JSON in the request body.
+// The client's credentials are returned along with a registration access token and a
+// registration client URI through which the client can later manage its registration.
+// Refer RFC 7591 Section 3 (https://tools.ietf.org/html/rfc7591#section-3)
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ShowJSONError(w, r, http.StatusMethodNotAllowed, r.Method+" not allowed.")
+		return
+	}
+
+	metadata, ok := parseClientMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	registration, err := cache.NewClientRegistration(*metadata)
+	if err != nil {
+		log.Println(err)
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Client registration failed. Please try again.",
+		})
+		return
+	}
+
+	writeClientRegistration(w, r, http.StatusCreated, registration)
+}
+
+// handleClientConfiguration serves the registration client URI of a registered client.
+// The client authenticates with the registration access token issued on registration.
+// Registrations can be read with GET, updated with PUT and deleted with DELETE requests.
+// Refer RFC 7592 Section 2 (https://tools.ietf.org/html/rfc7592#section-2)
+func handleClientConfiguration(w http.ResponseWriter, r *http.Request) {
+	clientID := strings.TrimPrefix(r.URL.Path, clientConfigurationRoute)
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="OAuth 2.0 Bin"`)
+		utils.ShowJSONError(w, r, http.StatusUnauthorized, utils.RequestError{
+			Error: "invalid_token",
+			Desc:  "registration access token is required",
+		})
+		return
+	}
+	token := strings.TrimSpace(authorization[len("Bearer "):])
+
+	var registration *cache.ClientRegistration
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		registration, err = cache.VerifyClientRegistration(clientID, token)
+	case http.MethodPut:
+		// The token is checked before the body so that the metadata of
+		// another client cannot be probed for validation errors
+		_, err = cache.VerifyClientRegistration(clientID, token)
+		if err != nil {
+			break
+		}
+
+		metadata, ok := parseClientUpdate(w, r, clientID)
+		if !ok {
+			return
+		}
+
+		registration, err = cache.UpdateClientRegistration(clientID, token, *metadata)
+	case http.MethodDelete:
+		err = cache.DeleteClientRegistration(clientID, token)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		utils.ShowJSONError(w, r, http.StatusMethodNotAllowed, r.Method+" not allowed.")
+		return
+	}
+
+	switch err {
+	case nil:
+		writeClientRegistration(w, r, http.StatusOK, registration)
+	case cache.ErrInvalidRegistrationToken:
+		// Refer RFC 7592 Section 2.1: unknown clients are treated as an invalid token
+		w.Header().Set("WWW-Authenticate", `Bearer realm="OAuth 2.0 Bin", error="invalid_token"`)
+		utils.ShowJSONError(w, r, http.StatusUnauthorized, utils.RequestError{
+			Error: "invalid_token",
+			Desc:  err.Error(),
+		})
+	default:
+		log.Println(err)
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Client configuration request failed. Please try again.",
+		})
+	}
+}
+
+// Parses the client metadata in the JSON body of a registration request and validates it.
+// Presents a JSON error and returns false if the body could not be parsed or is invalid.
+func parseClientMetadata(w http.ResponseWriter, r *http.Request) (*cache.ClientMetadata, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, "An error occurred while processing your request")
+		return nil, false
+	}
+
+	var metadata cache.ClientMetadata
+	err = json.Unmarshal(body, &metadata)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_client_metadata",
+			Desc:  "request body must be a JSON object of client metadata",
+		})
+		return nil, false
+	}
+
+	if requestErr := validateClientMetadata(r, &metadata); requestErr != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, *requestErr)
+		return nil, false
+	}
+
+	return &metadata, true
+}
+
+// Parses the body of a client update request, which must carry the client's ID and,
+// if sent, its current secret along with the metadata replacing the registered metadata.
+// Refer RFC 7592 Section 2.2 (https://tools.ietf.org/html/rfc7592#section-2.2)
+func parseClientUpdate(w http.ResponseWriter, r *http.Request, clientID string) (*cache.ClientMetadata, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, "An error occurred while processing your request")
+		return nil, false
+	}
+
+	var update struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		cache.ClientMetadata
+	}
+
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_client_metadata",
+			Desc:  "request body must be a JSON object of client metadata",
+		})
+		return nil, false
+	}
+
+	registration := cache.LookupClientRegistration(clientID)
+	if update.ClientID != clientID || registration == nil ||
+		(update.ClientSecret != "" && update.ClientSecret != registration.ClientSecret) {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_client_metadata",
+			Desc:  "client_id and client_secret, if sent, must be those of the registered client",
+		})
+		return nil, false
+	}
+
+	if requestErr := validateClientMetadata(r, &update.ClientMetadata); requestErr != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, *requestErr)
+		return nil, false
+	}
+
+	return &update.ClientMetadata, true
+}
+
+// Checks the client metadata and fills in the defaults for the omitted fields.
+// Returns the error to be sent to the client if the metadata is invalid.
+// Refer RFC 7591 Section 2 (https://tools.ietf.org/html/rfc7591#section-2)
+func validateClientMetadata(r *http.Request, metadata *cache.ClientMetadata) *utils.RequestError {
+	invalid := func(format string, args ...interface{}) *utils.RequestError {
+		return &utils.RequestError{Error: "invalid_client_metadata", Desc: fmt.Sprintf(format, args...)}
+	}
+
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = cache.AuthMethodClientSecretBasic
+	}
+
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{config.AuthCodeGrant}
+	}
+
+	if len(metadata.ResponseTypes) == 0 && hasGrantType(metadata.GrantTypes, config.AuthCodeGrant) {
+		metadata.ResponseTypes = []string{"code"}
+	}
+
+	switch metadata.TokenEndpointAuthMethod {
+	case cache.AuthMethodNone, cache.AuthMethodClientSecretBasic, cache.AuthMethodClientSecretPost:
+	default:
+		return invalid("unsupported token_endpoint_auth_method: %s", metadata.TokenEndpointAuthMethod)
+	}
+
+	for _, grantType := range metadata.GrantTypes {
+		if !hasGrantType(registrableGrantTypes, grantType) {
+			return invalid("unsupported grant type: %s", grantType)
+		}
+	}
+
+	if metadata.IsPublic() && hasGrantType(metadata.GrantTypes, config.ClientCredsGrant) {
+		return invalid("client_credentials may only be used by confidential clients")
+	}
+
+	for _, responseType := range metadata.ResponseTypes {
+		for _, value := range strings.Fields(responseType) {
+			grantType, ok := responseTypeGrantTypes[value]
+			if !ok {
+				return invalid("unsupported response type: %s", responseType)
+			}
+
+			if !hasGrantType(metadata.GrantTypes, grantType) {
+				return invalid("response type %s requires the %s grant type", responseType, grantType)
+			}
+		}
+	}
+
+	// Clients redirecting the user-agent must register where to
+	if len(metadata.RedirectURIs) == 0 &&
+		(hasGrantType(metadata.GrantTypes, config.AuthCodeGrant) || hasGrantType(metadata.GrantTypes, config.ImplicitGrant)) {
+		return &utils.RequestError{Error: "invalid_redirect_uri", Desc: "redirect_uris are required for the requested grant types"}
+	}
+
+	// Refer RFC 6749 Section 3.1.2 (https://tools.ietf.org/html/rfc6749#section-3.1.2)
+	for _, redirectURI := range metadata.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return &utils.RequestError{Error: "invalid_redirect_uri", Desc: "redirect URIs must be absolute and without a fragment: " + redirectURI}
+		}
+	}
+
+	scopes := utils.ParseScope(metadata.Scope)
+	if supported := supportedScopes(requestConfig(r)); len(supported) > 0 && !utils.IsScopeSubset(scopes, supported) {
+		return invalid("scope must be a subset of: %s", strings.Join(supported, " "))
+	}
+	metadata.Scope = strings.Join(scopes, " ")
+
+	return nil
+}
+
+// Returns true if the grant type is in the list
+func hasGrantType(grantTypes []string, grantType string) bool {
+	for _, value := range grantTypes {
+		if value == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes the client registration as a JSON response with the given status.
+// The response carries credentials and thus must not be cached.
+// Refer RFC 7591 Section 3.2.1 (https://tools.ietf.org/html/rfc7591#section-3.2.1)
+func writeClientRegistration(w http.ResponseWriter, r *http.Request, status int, registration *cache.ClientRegistration) {
+	registration.RegistrationClientURI = requestConfig(r).BaseURL + clientConfigurationRoute + registration.ClientID
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+
+	jsonBytes, _ := json.Marshal(registration)
+	fmt.Fprintln(w, string(jsonBytes))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+)
+
+// Makes a request to the registration endpoints of a server with the configuration,
+// with the JSON body and registration access token, if any
+func register(cnfg *config.OA2Config, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	if path == "/register" {
+		withScope(cnfg, nil, handleRegister)(recorder, req)
+	} else {
+		withScope(cnfg, nil, handleClientConfiguration)(recorder, req)
+	}
+
+	return recorder
+}
+
+func TestRegister(t *testing.T) {
+	cnfg := &config.OA2Config{BaseURL: "https://oauth2bin.org"}
+	handleToken := withScope(cnfg, nil, handleToken)
+
+	recorder := register(cnfg, http.MethodPost, "/register", `{"grant_types": ["authorization_code"]}`, "")
+	if errorCode(recorder) != "invalid_redirect_uri" {
+		t.Fatalf("HTTP %d: client registered for the Authorization Code flow without redirect URIs", recorder.Code)
+	}
+
+	recorder = register(cnfg, http.MethodPost, "/register", `{"grant_types": ["client_credentials"], "token_endpoint_auth_method": "none"}`, "")
+	if errorCode(recorder) != "invalid_client_metadata" {
+		t.Fatalf("HTTP %d: public client registered for the Client Credentials flow", recorder.Code)
+	}
+
+	recorder = register(cnfg, http.MethodPost, "/register", `{"grant_types": ["client_credentials"], "client_name": "Test"}`, "")
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("HTTP %d: registration failed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var registration cache.ClientRegistration
+	err := json.Unmarshal(recorder.Body.Bytes(), &registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if registration.ClientSecret == "" || registration.ClientSecretExpiresAt == nil ||
+		registration.RegistrationClientURI != "https://oauth2bin.org/register/"+registration.ClientID {
+		t.Fatalf("Unexpected registration: %s", recorder.Body.String())
+	}
+
+	// The registered client is enforced by the flow handlers
+	clientCreds := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {registration.ClientID},
+		"client_secret": {registration.ClientSecret},
+	}
+
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: registered client could not obtain a token: %s", recorder.Code, recorder.Body.String())
+	}
+
+	path := "/register/" + registration.ClientID
+	recorder = register(cnfg, http.MethodGet, path, "", "wrong")
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("HTTP %d: registration read with an invalid token", recorder.Code)
+	}
+
+	recorder = register(cnfg, http.MethodGet, path, "", registration.RegistrationAccessToken)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"client_name":"Test"`) {
+		t.Fatalf("HTTP %d: registration read failed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The client is no longer allowed the Client Credentials flow once its grant types are updated
+	recorder = register(cnfg, http.MethodPut, path, `{"grant_types": ["password"]}`, registration.RegistrationAccessToken)
+	if errorCode(recorder) != "invalid_client_metadata" {
+		t.Fatalf("HTTP %d: update without client_id accepted", recorder.Code)
+	}
+
+	update := `{"client_id": "` + registration.ClientID + `", "grant_types": ["password"]}`
+	recorder = register(cnfg, http.MethodPut, path, update, registration.RegistrationAccessToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: update failed: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if errorCode(recorder) != "unauthorized_client" {
+		t.Fatalf("HTTP %d: client used a grant type removed on update", recorder.Code)
+	}
+
+	recorder = register(cnfg, http.MethodDelete, path, "", registration.RegistrationAccessToken)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("HTTP %d: deletion failed", recorder.Code)
+	}
+
+	recorder = postForm(handleToken, "/token", clientCreds)
+	if recorder.Code == http.StatusOK {
+		t.Fatal("Deleted client obtained a token")
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/middleware"
+)
+
+// How often the files of the configuration and rate policies are checked for changes
+const configPollInterval = 2 * time.Second
+
+// Returns the configuration in use by the server.
+// It is replaced as a whole when reloaded and never modified in place.
+func (s *OA2Server) currentConfig() *config.OA2Config {
+	return s.cnfg.Load().(*config.OA2Config)
+}
+
+// Replaces the configuration in use by the server
+func (s *OA2Server) setConfig(cnfg *config.OA2Config) {
+	s.cnfg.Store(cnfg)
+}
+
+// Returns the configuration of the server handling the request, read once when the request
+// was received so that the handlers see the same configuration however long they take.
+func requestConfig(r *http.Request) *config.OA2Config {
+	return scopeOf(r).cnfg
+}
+
+// Reload reads the server configuration and the rate policies again and swaps them in
+// once both are validated, logging what changed. If either cannot be read or is invalid,
+// those in use are kept and the error is returned.
+// The store and the JWT signing key are set up at startup, so changes to them take effect on restart.
+func (s *OA2Server) Reload() error {
+	s.reloadMut.Lock()
+	defer s.reloadMut.Unlock()
+
+	updated, err := readServerConfig(s.serverConfigPath)
+	if err != nil {
+		return fmt.Errorf("could not read the server config: %s", err)
+	}
+
+	err = updated.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid server config: %s", err)
+	}
+
+	policies, err := readRatePolicies(s.ratePoliciesPath)
+	if os.IsNotExist(err) {
+		// No policies are enforced without the file, as at startup
+		policies, err = nil, nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not read rate policies: %s", err)
+	}
+
+	for _, policy := range policies {
+		err = policy.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid rate policies: %s", err)
+		}
+	}
+
+	old := s.currentConfig()
+	keepRestartSettings(old, updated)
+
+	limiter := s.Limiter.Limiter()
+	changes := config.Diff(*old, *updated)
+	changes = append(changes, diffRatePolicies(limiter.Policies, policies)...)
+
+	s.setConfig(updated)
+	limiter.Policies = policies
+	limiter.TrustedProxies = updated.TrustedProxies
+	s.Limiter.Set(limiter)
+
+	if len(changes) == 0 {
+		log.Println("Reloaded the configuration, nothing changed")
+	}
+
+	for _, change := range changes {
+		log.Printf("Reloaded the configuration: %s\n", change)
+	}
+
+	return nil
+}
+
+// Keeps the settings in use which take effect only on restart, logging those which were changed
+func keepRestartSettings(old, updated *config.OA2Config) {
+	if updated.Store != old.Store {
+		log.Println("Changes to the store take effect on restart")
+		updated.Store = old.Store
+	}
+
+	if updated.JWTCnfg.Algorithm != old.JWTCnfg.Algorithm || updated.JWTCnfg.KeyFile != old.JWTCnfg.KeyFile {
+		log.Println("Changes to the JWT signing key take effect on restart")
+		updated.JWTCnfg.Algorithm = old.JWTCnfg.Algorithm
+		updated.JWTCnfg.KeyFile = old.JWTCnfg.KeyFile
+	}
+}
+
+// Returns the policies which were added, removed or changed, one per line, named by their route and grant type
+func diffRatePolicies(old, updated []middleware.RatePolicy) []string {
+	var changes []string
+
+	oldPolicies := make(map[string]middleware.RatePolicy)
+	for _, policy := range old {
+		oldPolicies[policy.Name()] = policy
+	}
+
+	updatedPolicies := make(map[string]middleware.RatePolicy)
+	for _, policy := range updated {
+		updatedPolicies[policy.Name()] = policy
+
+		oldPolicy, found := oldPolicies[policy.Name()]
+		if !found {
+			changes = append(changes, fmt.Sprintf("ratePolicies[%s] added: %s", policy.Name(), formatPolicy(policy)))
+		} else if oldPolicy != policy {
+			changes = append(changes, fmt.Sprintf("ratePolicies[%s]: %s -> %s", policy.Name(), formatPolicy(oldPolicy), formatPolicy(policy)))
+		}
+	}
+
+	for _, policy := range old {
+		if _, found := updatedPolicies[policy.Name()]; !found {
+			changes = append(changes, fmt.Sprintf("ratePolicies[%s] removed: %s", policy.Name(), formatPolicy(policy)))
+		}
+	}
+
+	return changes
+}
+
+func formatPolicy(policy middleware.RatePolicy) string {
+	data, _ := json.Marshal(policy)
+	return string(data)
+}
+
+// The version of a file, which changes whenever it is written
+type fileVersion struct {
+	modTime int64
+	size    int64
+}
+
+// Returns the versions of the files of the configuration and rate policies.
+// A file which cannot be found has the zero version.
+func (s *OA2Server) configFileVersions() [2]fileVersion {
+	var versions [2]fileVersion
+	for i, path := range []string{s.serverConfigPath, s.ratePoliciesPath} {
+		info, err := os.Stat(path)
+		if err == nil {
+			versions[i] = fileVersion{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+
+	return versions
+}
+
+// Fires up a goroutine which reloads the configuration when the server receives SIGHUP
+// or when the files of the configuration or rate policies change, until the server is shut down.
+func (s *OA2Server) watchConfig() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		versions := s.configFileVersions()
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		defer signal.Stop(hangup)
+
+		for {
+			select {
+			case <-s.stopWatch:
+				return
+			case <-hangup:
+				log.Println("Received SIGHUP, reloading the configuration")
+			case <-ticker.C:
+				if s.configFileVersions() == versions {
+					continue
+				}
+				log.Println("Configuration files changed, reloading the configuration")
+			}
+
+			versions = s.configFileVersions()
+			err := s.Reload()
+			if err != nil {
+				log.Printf("Keeping the configuration in use: %s\n", err)
+			}
+		}
+	}()
+}
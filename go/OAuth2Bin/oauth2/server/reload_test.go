@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/middleware"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oa2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &OA2Server{
+		Limiter:          middleware.NewReloadableRateLimiter(middleware.RateLimiter{}),
+		serverConfigPath: filepath.Join(dir, "flowParams.json"),
+		ratePoliciesPath: filepath.Join(dir, "ratePolicies.csv"),
+	}
+
+	s.setConfig(&config.OA2Config{BaseURL: "https://oauth2bin.org", Store: "memory", JWTCnfg: config.JWTConfig{Algorithm: "RS256"}})
+
+	ioutil.WriteFile(s.serverConfigPath, []byte(`{
+		"baseURL": "https://oauth2bin.org/",
+		"store": "memory",
+		"jwt": {"algorithm": "RS256"},
+		"clients": [{"clientID": "reloaded", "clientSecret": "secret"}]
+	}`), 0600)
+	ioutil.WriteFile(s.ratePoliciesPath, []byte("/token,5,60\n/api/*,10,1,token_bucket"), 0600)
+
+	err = s.Reload()
+	if err != nil {
+		t.Fatalf("Valid configuration not reloaded: %s", err)
+	}
+
+	if s.currentConfig().BaseURL != "https://oauth2bin.org" || len(s.currentConfig().Clients) != 1 {
+		t.Fatalf("Unexpected configuration: %+v", s.currentConfig())
+	}
+
+	if policies := s.Limiter.Limiter().Policies; len(policies) != 2 || policies[1].Algorithm != middleware.TokenBucket {
+		t.Fatalf("Unexpected rate policies: %+v", policies)
+	}
+
+	// An invalid configuration is not swapped in, nor are the rate policies read along with it
+	reloaded := s.currentConfig()
+	ioutil.WriteFile(s.serverConfigPath, []byte(`{"baseURL": "https://oauth2bin.org", "clients": [{"clientSecret": "secret"}]}`), 0600)
+	ioutil.WriteFile(s.ratePoliciesPath, []byte("/token,5,60"), 0600)
+	if s.Reload() == nil || s.currentConfig() != reloaded || len(s.Limiter.Limiter().Policies) != 2 {
+		t.Fatal("Invalid configuration reloaded")
+	}
+
+	// Invalid rate policies are not swapped in, nor is the configuration read along with them
+	ioutil.WriteFile(s.serverConfigPath, []byte(`{"baseURL": "https://oauth2bin.org", "store": "memory"}`), 0600)
+	ioutil.WriteFile(s.ratePoliciesPath, []byte("/token,5,60,leaky_bucket"), 0600)
+	if s.Reload() == nil || s.currentConfig() != reloaded || len(s.Limiter.Limiter().Policies) != 2 {
+		t.Fatal("Invalid rate policies reloaded")
+	}
+
+	// The store and signing key in use are kept until restart
+	ioutil.WriteFile(s.serverConfigPath, []byte(`{
+		"baseURL": "https://oauth2bin.org",
+		"store": "redis",
+		"jwt": {"enabled": true, "algorithm": "ES256"}
+	}`), 0600)
+	ioutil.WriteFile(s.ratePoliciesPath, []byte("/token,5,60"), 0600)
+
+	err = s.Reload()
+	if err != nil {
+		t.Fatalf("Valid configuration not reloaded: %s", err)
+	}
+
+	jwtConfig := s.currentConfig().JWTCnfg
+	if s.currentConfig().Store != "memory" || jwtConfig.Algorithm != "RS256" || !jwtConfig.Enabled {
+		t.Fatalf("Unexpected configuration: %+v", s.currentConfig())
+	}
+}
+
+func TestDiffRatePolicies(t *testing.T) {
+	old := []middleware.RatePolicy{
+		{Route: "/token", Limit: 5, Minutes: 60},
+		{Route: "/revoke", Limit: 100, Minutes: 60},
+		{Route: "/echo", Limit: 50, Minutes: 30},
+	}
+	updated := []middleware.RatePolicy{
+		{Route: "/token", Limit: 10, Minutes: 60},
+		{Route: "/echo", Limit: 50, Minutes: 30},
+		{Route: "/api/*", Limit: 10, Minutes: 1},
+	}
+
+	changes := diffRatePolicies(old, updated)
+	expected := []string{
+		`ratePolicies[/token]: {"route":"/token","limit":5,"minutes":60} -> {"route":"/token","limit":10,"minutes":60}`,
+		`ratePolicies[/api/*] added: {"route":"/api/*","limit":10,"minutes":1}`,
+		`ratePolicies[/revoke] removed: {"route":"/revoke","limit":100,"minutes":60}`,
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Unexpected changes: %q", changes)
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("Expected %s, got %s", expected[i], changes[i])
+		}
+	}
+}
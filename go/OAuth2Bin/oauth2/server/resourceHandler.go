@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/middleware"
+	"oauth2bin/oauth2/utils"
+)
+
+// Route under which the resources requiring the scope named by the path are served
+const scopedResourceRoute = "/api/scoped/"
+
+// Realm of the bearer-protected sample resource API
+const resourceRealm = "OAuth 2.0 Bin"
+
+// Returns the middleware protecting the sample resource API with the access tokens issued by the server.
+// requiredScope returns the scope a request must be authorized for, or is nil if any scope suffices.
+func newResourceAuth(requiredScope func(*http.Request) []string) middleware.BearerAuth {
+	return middleware.NewBearerAuth(resourceRealm, resolveAccessToken, requiredScope)
+}
+
+// resourceResponse describes the access token with which a protected resource was accessed.
+// User is the resource owner who authorized the client, absent for the Client Credentials flow.
+type resourceResponse struct {
+	User     string `json:"user,omitempty"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	Flow     string `json:"flow"`
+	Exp      int64  `json:"exp"`
+	Resource string `json:"resource,omitempty"`
+}
+
+// [Bearer Auth Required] handleMe describes the access token presented and the user who authorized it.
+// Tokens of any scope are accepted.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	writeResourceResponse(w, r, "")
+}
+
+// [Bearer Auth Required] handleScoped serves a resource requiring the scope named by the path.
+// e.g. /api/scoped/read requires an access token granted the "read" scope.
+func handleScoped(w http.ResponseWriter, r *http.Request) {
+	scope := strings.TrimPrefix(r.URL.Path, scopedResourceRoute)
+	if scope == "" {
+		utils.ShowJSONError(w, r, http.StatusNotFound, utils.RequestError{
+			Error: "Not Found",
+			Desc:  "scope is required, e.g. " + scopedResourceRoute + "read",
+		})
+		return
+	}
+
+	writeResourceResponse(w, r, scope)
+}
+
+// Returns the scope named by the path of a request to a scoped resource.
+// Refer RFC 6749 Section 3.3 for the delimiter (https://tools.ietf.org/html/rfc6749#section-3.3)
+func scopedResourceScope(r *http.Request) []string {
+	return utils.ParseScope(strings.TrimPrefix(r.URL.Path, scopedResourceRoute))
+}
+
+// Writes the description of the access token verified by BearerAuth as JSON
+func writeResourceResponse(w http.ResponseWriter, r *http.Request, resource string) {
+	info, _ := middleware.TokenInfoFromContext(r.Context())
+
+	response := resourceResponse{
+		ClientID: info.ClientID,
+		Scope:    info.Scope,
+		Flow:     info.Flow,
+		Exp:      info.Exp,
+		Resource: resource,
+	}
+
+	if info.Flow != cache.ClientCredsFlowName {
+		response.User = requestConfig(r).ROPCCnfg.Username
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	jsonBytes, _ := json.Marshal(response)
+
+	fmt.Fprintln(w, string(jsonBytes))
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/utils"
+)
+
+// handleRevoke revokes an access or refresh token issued to the requesting client.
+// Confidential clients must authenticate, public clients need only send their client_id.
+// As per the RFC, an HTTP 200 response is sent even if the token is invalid or unknown.
+// Refer RFC 7009 Section 2 (https://tools.ietf.org/html/rfc7009#section-2)
+// Accepts only POST requests with application/x-www-form-urlencoded body.
+func handleRevoke(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseClientRequest(w, r)
+	if !ok {
+		return
+	}
+
+	clientID := params["client_id"]
+	if !authenticateClient(r, clientID, params["client_secret"]) &&
+		!(params["client_secret"] == "" && isPublicClient(r, clientID)) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	if params["token"] == "" {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_request",
+			Desc:  "token is required",
+		})
+		return
+	}
+
+	// Refer RFC 7009 Section 2.1: the token must have been issued to the requesting client
+	token := resolveAccessToken(r, params["token"])
+	info := cache.IntrospectToken(token, params["token_type_hint"])
+	if info.Active && info.ClientID != clientID {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "unauthorized_client",
+			Desc:  "token was not issued to this client",
+		})
+		return
+	}
+
+	cache.RevokeToken(token, params["token_type_hint"])
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+)
+
+// Makes a revocation request for the token with the given client credentials to a server with the configuration
+func revoke(cnfg *config.OA2Config, clientID, clientSecret, token, hint string) *httptest.ResponseRecorder {
+	body := "token=" + token + "&token_type_hint=" + hint + "&client_id=" + clientID
+	if clientSecret != "" {
+		body += "&client_secret=" + clientSecret
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, nil, handleRevoke)(recorder, req)
+	return recorder
+}
+
+func TestRevoke(t *testing.T) {
+	cnfg := &config.OA2Config{
+		ROPCCnfg:     config.ROPCConfig{ClientID: "ropc", ClientSecret: "ropc-secret"},
+		ImplicitCnfg: config.ImplicitConfig{ClientID: "spa"},
+	}
+
+	token, err := cache.NewROPCToken("ropc", "", "", config.DefaultLifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := revoke(cnfg, "ropc", "wrong", token.RefreshToken, cache.RefreshTokenHint)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("HTTP %d: unauthenticated client allowed to revoke", recorder.Code)
+	}
+
+	recorder = revoke(cnfg, "spa", "", token.AccessToken, cache.AccessTokenHint)
+	if recorder.Code != http.StatusBadRequest || !cache.VerifyROPCToken(token.AccessToken) {
+		t.Fatalf("HTTP %d: client allowed to revoke a token issued to another client", recorder.Code)
+	}
+
+	recorder = revoke(cnfg, "ropc", "ropc-secret", token.RefreshToken, cache.RefreshTokenHint)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: revocation failed", recorder.Code)
+	}
+
+	if cache.VerifyROPCToken(token.AccessToken) {
+		t.Fatal("Access token still valid after revoking its refresh token")
+	}
+
+	// Revoking an unknown token must succeed as well
+	recorder = revoke(cnfg, "ropc", "ropc-secret", token.RefreshToken, cache.RefreshTokenHint)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: revocation of an unknown token failed", recorder.Code)
+	}
+}
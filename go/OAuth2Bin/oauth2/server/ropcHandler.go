@@ -6,26 +6,55 @@ import (
 	"log"
 	"net/http"
 	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/metrics"
 	"oauth2bin/oauth2/utils"
 )
 
-// Checks if the values for username, password, client_id and client_secret match the server presents.
-// If yes, an access token is issued.
+// Checks if the values for username and password match the user the server presents,
+// and if client_id and client_secret are those of a client registered for the flow.
+// If the client fails to authenticate, an invalid_client error is sent.
+// If the client may not use the flow, an unauthorized_client error is sent.
+// If yes, an access token is issued with the requested scope, if allowed for the client.
 // Refer: https://tools.ietf.org/html/rfc6749#section-4.3.2
 func handleROPCToken(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	if params["username"] != serverConfig.ROPCCnfg.Username ||
-		params["password"] != serverConfig.ROPCCnfg.Password ||
-		params["client_id"] != serverConfig.ROPCCnfg.ClientID ||
-		params["client_secret"] != serverConfig.AuthCodeCnfg.ClientSecret {
+	cnfg := requestConfig(r)
+
+	client, err := findClient(r, params["client_id"], config.ROPCGrant)
+	if err != nil {
+		showClientLookupError(w, r, err)
+		return
+	}
+
+	if !authenticateTokenRequest(client, params) {
+		showInvalidClientError(w, r)
+		return
+	}
+
+	if params["username"] != cnfg.ROPCCnfg.Username ||
+		params["password"] != cnfg.ROPCCnfg.Password {
 		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
 			Error: "invalid_request",
-			Desc:  "username, password, client_id and client_secret are missing or invalid",
+			Desc:  "username and password are missing or invalid",
+		})
+		return
+	}
+
+	scope, err := utils.NarrowScope(params["scope"], client.Scopes)
+	if err != nil {
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_scope",
+			Desc:  err.Error(),
 		})
 		return
 	}
 
 	// If everything checks out, issue the token
-	token, err := cache.NewROPCToken("")
+	token, err := cache.NewROPCToken(client.ID, "", scope, lifetimesFor(r, client, config.ROPC))
+	if err == nil {
+		token.AccessToken, err = formatAccessToken(r, client, config.ROPC, token.AccessToken, token.Scope, token.ExpiresIn)
+	}
+
 	if err != nil {
 		log.Println(err)
 		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
@@ -35,32 +64,45 @@ func handleROPCToken(w http.ResponseWriter, r *http.Request, params map[string]s
 		return
 	}
 
+	metrics.TokensIssued.Inc(cache.ROPCFlowName, config.ROPCGrant)
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	jsonBytes, err := json.Marshal(token)
 
 	fmt.Fprintln(w, string(jsonBytes))
 }
 
-func handleROPCRefresh(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	// Invalidate previously issued token
-	if cache.ROPCRefreshTokenExists(params["refresh_token"], true) {
-		token, err := cache.NewROPCRefreshToken(params["refresh_token"])
-		if err != nil {
-			utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
-				Error: "Internal Server Error",
-				Desc:  "Token generation failed. Please try again.",
-			})
-			return
-		}
+// Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
+// The previously issued token is invalidated if the refresh token is found.
+// The scope, if present, may only narrow the scope originally granted.
+// client is the client to which the refresh token was issued.
+// A new refresh token is issued in place of the one presented if rotation is enabled for the client.
+func handleROPCRefresh(w http.ResponseWriter, r *http.Request, client *config.Client, params map[string]string) {
+	token, err := cache.NewROPCRefreshToken(params["refresh_token"], params["scope"], requestConfig(r).RotatesRefreshTokens(*client), lifetimesFor(r, client, config.ROPC))
+	if err == nil {
+		token.AccessToken, err = formatAccessToken(r, client, config.ROPC, token.AccessToken, token.Scope, token.ExpiresIn)
+	}
 
+	switch err {
+	case nil:
+		metrics.TokensIssued.Inc(cache.ROPCFlowName, config.RefreshTokenGrant)
 		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
-		jsonBytes, err := json.Marshal(token)
+		jsonBytes, _ := json.Marshal(token)
 
 		fmt.Fprintln(w, string(jsonBytes))
-	} else {
+	case cache.ErrInvalidRefreshToken:
 		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
 			Error: "invalid_refresh_token",
-			Desc:  "expired or invalid refresh token",
+			Desc:  err.Error(),
+		})
+	case cache.ErrInvalidScope:
+		utils.ShowJSONError(w, r, http.StatusBadRequest, utils.RequestError{
+			Error: "invalid_scope",
+			Desc:  err.Error(),
+		})
+	default:
+		utils.ShowJSONError(w, r, http.StatusInternalServerError, utils.RequestError{
+			Error: "Internal Server Error",
+			Desc:  "Token generation failed. Please try again.",
 		})
 	}
 }
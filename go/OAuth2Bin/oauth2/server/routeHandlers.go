@@ -9,12 +9,17 @@ import (
 	"net/url"
 	"oauth2bin/oauth2/cache"
 	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/metrics"
 	"oauth2bin/oauth2/utils"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Routes the request to a AuthorizationHandler based on the request_type
+// Routes the request to a AuthorizationHandler based on the request_type.
+// The client_id and redirect_uri are validated first. If either is invalid, the error is
+// shown to the user, else errors are sent back to the client at the redirect URI.
+// Refer RFC 6749 Section 4.1.2.1 (https://tools.ietf.org/html/rfc6749#section-4.1.2.1)
 func handleAuth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		utils.ShowError(w, r, 405, "Method Not Allowed", r.Method+" not allowed.")
@@ -22,28 +27,48 @@ func handleAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	params := r.URL.Query()
+	if params.Get("client_id") == "" {
+		utils.ShowError(w, r, 400, "Bad Request", "client_id is required.")
+		return
+	}
+
+	clients := clientsWithID(r, params.Get("client_id"))
+	if len(clients) == 0 {
+		utils.ShowError(w, r, 401, "Unauthorized", errUnknownClient.Error())
+		return
+	}
 
-	// Perform empty checks on the following parameters:
-	// - response_type
-	// - client_id
-	if params.Get("response_type") == "" || params.Get("client_id") == "" {
-		utils.ShowError(w, r, 400, "Bad Request", "response_type and client_id are required.")
+	redirectURI, err := resolveRedirectURI(clients, params.Get("redirect_uri"))
+	if err != nil {
+		utils.ShowError(w, r, 400, "Bad Request", err.Error())
 		return
 	}
 
-	switch r.URL.Query().Get("response_type") {
+	switch normalizeResponseType(params.Get("response_type")) {
 	case "code":
-		handleAuthCodeAuth(w, r)
-	case "token":
-		handleImplicitAuth(w, r)
+		handleAuthCodeAuth(w, r, redirectURI)
+	case "token", "id_token", "id_token token":
+		handleImplicitAuth(w, r, redirectURI)
+	case "":
+		redirect := authRedirect{URI: redirectURI, State: params.Get("state")}
+		redirect.sendError(w, r, "invalid_request", "response_type is required")
 	default:
-		utils.ShowError(w, r, http.StatusBadRequest, "Authorization Flow Error", "Unknown response_type: "+r.URL.Query().Get("response_type"))
+		redirect := authRedirect{URI: redirectURI, State: params.Get("state")}
+		redirect.sendError(w, r, "unsupported_response_type", "Unknown response_type: "+params.Get("response_type"))
 	}
 }
 
-// Invoked by the Authorization Grant screen when the user accepts the authorization request.
-// Extracts the redirect_uri from the JSON body, attaches an authorization grant to it,
-// and redirects the user-agent to that URI.
+// Grant types of the flows which present the authorization screen for a redirect URI
+var authScreenGrantTypes = map[int]string{
+	config.AuthCode: config.AuthCodeGrant,
+	config.Implicit: config.ImplicitGrant,
+}
+
+// Invoked by the Authorization Grant screen when the user accepts or denies the authorization request.
+// The request validated by the authorization endpoint is looked up by the ID carried by the form,
+// and the user-agent is redirected to its redirect URI with an authorization grant or access token,
+// or with the access_denied error. The state, if sent by the client, is included in both.
+// Refer RFC 6749 Section 4.1.2 (https://tools.ietf.org/html/rfc6749#section-4.1.2)
 func handleResponse(w http.ResponseWriter, r *http.Request) {
 	flow, err := strconv.Atoi(r.FormValue("flow"))
 	if err != nil {
@@ -51,57 +76,105 @@ func handleResponse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := r.FormValue("response")
-	redirectURI, err := url.QueryUnescape(r.FormValue("redirectURI"))
+	// Device authorization requests have no redirect URI to send the response to
+	if flow == config.Device {
+		handleDeviceResponse(w, r, r.FormValue("response") == "ACCEPT")
+		return
+	}
+
+	request, err := cache.ConsumeAuthorizationRequest(r.FormValue("request_id"))
+	if err == cache.ErrInvalidAuthorizationRequest {
+		utils.ShowError(w, r, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	} else if err != nil {
+		utils.ShowError(w, r, 500, "Internal Server Error", "An error occurred while processing your request.")
+		return
+	}
+
+	redirect := authRedirect{
+		URI:        request.RedirectURI,
+		InFragment: request.Flow == config.Implicit,
+		State:      request.State,
+	}
+
+	if r.FormValue("response") != "ACCEPT" {
+		redirect.sendError(w, r, "access_denied", "the user denied the authorization request")
+		return
+	}
+
+	// The client may have been removed or changed since the request was made
+	client, err := findClient(r, request.ClientID, authScreenGrantTypes[request.Flow])
 	if err != nil {
-		utils.ShowError(w, r, http.StatusBadRequest, "Bad Request", "Invalid redirect_uri")
+		redirect.sendError(w, r, "unauthorized_client", err.Error())
 		return
 	}
 
-	if response == "ACCEPT" {
-		switch flow {
-		case config.AuthCode:
-			redirectURI += "?code=" + cache.NewAuthCodeGrant(redirectURI)
-		case config.Implicit:
-			token, err := cache.NewImplicitToken()
-			if err != nil {
-				utils.ShowError(w, r, 500, "Internal Server Error", "Token generation failed. Please try again.")
-				return
-			}
+	switch request.Flow {
+	case config.AuthCode:
+		code := cache.NewAuthCodeGrant(client.ID, request.RedirectURI, request.Scope, request.Nonce, request.PKCE, lifetimesFor(r, client, config.AuthCode))
+		redirect.send(w, r, url.Values{"code": {code}})
+	case config.Implicit:
+		params, err := implicitResponse(r, client, request.ResponseType, request.Scope, request.Nonce)
+		if err != nil {
+			redirect.sendError(w, r, "server_error", "Token generation failed. Please try again.")
+			return
+		}
+
+		redirect.send(w, r, params)
+	}
+}
+
+// Returns the parameters of the implicit flow response to the client, to be added to the redirect URI fragment.
+// An access token is issued for the "token" response type and an ID token for the "id_token" response type.
+// Refer OpenID Connect Core 1.0 Section 3.2.2.5 (https://openid.net/specs/openid-connect-core-1_0.html#ImplicitAuthResponse)
+func implicitResponse(r *http.Request, client *config.Client, responseType, scope, nonce string) (url.Values, error) {
+	params := url.Values{}
+	lifetimes := lifetimesFor(r, client, config.Implicit)
+	var accessToken string
+	expiresIn := lifetimes.AccessToken
+
+	if responseTypeIncludes(responseType, "token") {
+		token, err := cache.NewImplicitToken(client.ID, scope, lifetimes)
+		if err == nil {
+			token.AccessToken, err = formatAccessToken(r, client, config.Implicit, token.AccessToken, token.Scope, token.ExpiresIn)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		metrics.TokensIssued.Inc(cache.ImplicitFlowName, config.ImplicitGrant)
+		accessToken, expiresIn = token.AccessToken, token.ExpiresIn
+		params.Set("access_token", token.AccessToken)
+		params.Set("token_type", "bearer")
+		params.Set("expires_in", strconv.Itoa(token.ExpiresIn))
+	}
 
-			redirectURI += fmt.Sprintf("#access_token=%s&token_type=bearer&expires_in=%d", token.AccessToken, token.ExpiresIn)
+	if responseTypeIncludes(responseType, "id_token") {
+		idToken, err := newIDToken(r, client.ID, nonce, time.Now().Unix(), accessToken, expiresIn)
+		if err != nil {
+			return nil, err
 		}
 
-	} else if response == "CANCEL" {
-		redirectURI += "?error=access_denied"
+		params.Set("id_token", idToken)
+	}
+
+	if scope != "" {
+		params.Set("scope", scope)
 	}
 
-	http.Redirect(w, r, redirectURI, http.StatusSeeOther)
+	return params, nil
 }
 
 // Redirects the request to the appropriate flowHandler by checking the 'grant_type' parameter.
 // Refer RFC 6749 Section 4.1.3 (https://tools.ietf.org/html/rfc6749#section-4.1.3)
 // Accepts only POST requests with application/x-www-form-urlencoded body.
 func handleToken(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		utils.ShowJSONError(w, r, 500, "An error occurred while processing your request")
+	params, ok := parseClientRequest(w, r)
+	if !ok {
 		return
 	}
 
-	params, err := utils.ParseParams(string(body))
-	if err != nil {
-		log.Println(err)
-		utils.ShowJSONError(w, r, 400, "Expected parameters not found.")
-		return
-	}
-
-	if params["client_id"] == "" && params["client_secret"] == "" {
-		clientID, clientSecret := utils.ParseBasicAuthHeader(r.Header.Get("Authorization"))
-		params["client_id"] = clientID
-		params["client_secret"] = clientSecret
-	}
-
 	switch params["grant_type"] {
 	case "authorization_code":
 		handleAuthCodeToken(w, r, params)
@@ -109,6 +182,8 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 		handleROPCToken(w, r, params)
 	case "client_credentials":
 		handleClientCredsToken(w, r, params)
+	case cache.DeviceFlowName:
+		handleDeviceToken(w, r, params)
 	case "refresh_token":
 		if len(params["refresh_token"]) != 72 {
 			utils.ShowJSONError(w, r, 400, utils.RequestError{
@@ -118,16 +193,75 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// The client the refresh token was issued to must still be allowed to refresh tokens
+		info := cache.IntrospectToken(params["refresh_token"], cache.RefreshTokenHint)
+		if !info.Active {
+			desc := cache.ErrInvalidRefreshToken.Error()
+
+			// A rotated refresh token may have been stolen, hence the tokens of its family are revoked
+			if cache.RevokeReusedRefreshToken(params["refresh_token"]) {
+				desc = cache.ErrRefreshTokenReused.Error()
+			}
+
+			utils.ShowJSONError(w, r, 400, utils.RequestError{
+				Error: "invalid_refresh_token",
+				Desc:  desc,
+			})
+			return
+		}
+
+		client, err := findClient(r, info.ClientID, config.RefreshTokenGrant)
+		if err != nil {
+			showClientLookupError(w, r, err)
+			return
+		}
+
+		// Refer RFC 6749 Section 6 (https://tools.ietf.org/html/rfc6749#section-6)
+		if !authenticateTokenRequest(client, params) {
+			showInvalidClientError(w, r)
+			return
+		}
+
 		if strings.HasPrefix(params["refresh_token"], cache.AuthCodeFlowID) {
-			handleAuthCodeRefresh(w, r, params)
+			handleAuthCodeRefresh(w, r, client, params)
 		} else if strings.HasPrefix(params["refresh_token"], cache.ROPCFlowID) {
-			handleROPCRefresh(w, r, params)
+			handleROPCRefresh(w, r, client, params)
 		}
 	default:
 		utils.ShowJSONError(w, r, 400, "grant_type absent or invalid")
 	}
 }
 
+// Parses the application/x-www-form-urlencoded body of a request made by a client.
+// If the client secret is absent in the body, the credentials are read from the Basic Authorization header,
+// unless the header carries a client ID other than the one in the body.
+// Refer RFC 6749 Section 2.3.1 (https://tools.ietf.org/html/rfc6749#section-2.3.1)
+// Presents a JSON error and returns false if the body could not be parsed.
+func parseClientRequest(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.ShowJSONError(w, r, 500, "An error occurred while processing your request")
+		return nil, false
+	}
+
+	params, err := utils.ParseParams(string(body))
+	if err != nil {
+		log.Println(err)
+		utils.ShowJSONError(w, r, 400, "Expected parameters not found.")
+		return nil, false
+	}
+
+	if params["client_secret"] == "" {
+		clientID, clientSecret := utils.ParseBasicAuthHeader(r.Header.Get("Authorization"))
+		if params["client_id"] == "" || params["client_id"] == clientID {
+			params["client_id"] = clientID
+			params["client_secret"] = clientSecret
+		}
+	}
+
+	return params, true
+}
+
 type echoResponse struct {
 	Method      string `json:"method"`
 	HTTPVersion string `json:"httpVersion"`
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+)
+
+const testState = "xyz ABC/123"
+
+// Submits the authorization screen form to handleResponse and
+// returns the URL the user-agent is redirected to.
+func submitAuthScreen(t *testing.T, flow int, response string) *url.URL {
+	cnfg := &config.OA2Config{Clients: []config.Client{{
+		ID:           "web",
+		GrantTypes:   []string{config.AuthCodeGrant, config.ImplicitGrant},
+		RedirectURIs: []string{"https://oauth2bin.org/callback"},
+	}}}
+
+	responseType := "code"
+	if flow == config.Implicit {
+		responseType = "token"
+	}
+
+	requestID, err := cache.NewAuthorizationRequest(cache.AuthorizationRequest{
+		Flow:         flow,
+		ClientID:     "web",
+		RedirectURI:  "https://oauth2bin.org/callback",
+		ResponseType: responseType,
+		State:        testState,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Set("flow", strconv.Itoa(flow))
+	form.Set("request_id", requestID)
+	form.Set("response", response)
+
+	req := httptest.NewRequest(http.MethodPost, "/response", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, nil, handleResponse)(recorder, req)
+
+	if recorder.Code != http.StatusSeeOther {
+		t.Fatalf("HTTP %d: expected a redirect", recorder.Code)
+	}
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func TestAuthCodeResponseState(t *testing.T) {
+	location := submitAuthScreen(t, config.AuthCode, "ACCEPT")
+
+	query := location.Query()
+	if query.Get("code") == "" {
+		t.Fatalf("code missing from redirect: %s", location)
+	}
+
+	if query.Get("state") != testState {
+		t.Fatalf("state not round-tripped: %s", location)
+	}
+}
+
+func TestImplicitResponseState(t *testing.T) {
+	location := submitAuthScreen(t, config.Implicit, "ACCEPT")
+
+	fragment, err := url.ParseQuery(location.EscapedFragment())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fragment.Get("access_token") == "" {
+		t.Fatalf("access_token missing from redirect: %s", location)
+	}
+
+	if fragment.Get("state") != testState {
+		t.Fatalf("state not round-tripped: %s", location)
+	}
+}
+
+func TestCancelResponseState(t *testing.T) {
+	location := submitAuthScreen(t, config.AuthCode, "CANCEL")
+
+	query := location.Query()
+	if query.Get("error") != "access_denied" {
+		t.Fatalf("error missing from redirect: %s", location)
+	}
+
+	if query.Get("state") != testState {
+		t.Fatalf("state not round-tripped: %s", location)
+	}
+}
+
+// Sends an authorization request to handleAuth of a server with the configuration and returns the recorded response
+func authorize(cnfg *config.OA2Config, query url.Values) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	withScope(cnfg, nil, handleAuth)(recorder, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil))
+	return recorder
+}
+
+func TestAuthorizeErrorRedirect(t *testing.T) {
+	cnfg := &config.OA2Config{Clients: []config.Client{{
+		ID:           "web",
+		GrantTypes:   []string{config.AuthCodeGrant, config.ImplicitGrant},
+		RedirectURIs: []string{"https://oauth2bin.org/callback?app=web"},
+		Scopes:       []string{"read"},
+	}}}
+
+	query := url.Values{
+		"response_type": {"assertion"},
+		"client_id":     {"web"},
+		"redirect_uri":  {"https://oauth2bin.org/callback?app=web"},
+		"state":         {testState},
+	}
+
+	errorCodes := map[string]string{
+		"assertion": "unsupported_response_type",
+		"":          "invalid_request",
+		"code":      "invalid_scope",
+	}
+
+	query.Set("scope", "write")
+	for responseType, errorCode := range errorCodes {
+		query.Set("response_type", responseType)
+
+		recorder := authorize(cnfg, query)
+		location, _ := url.Parse(recorder.Header().Get("Location"))
+		if recorder.Code != http.StatusSeeOther {
+			t.Fatalf("HTTP %d: expected %s to be sent to the redirect URI", recorder.Code, errorCode)
+		}
+
+		params := location.Query()
+		if params.Get("error") != errorCode || params.Get("error_description") == "" ||
+			params.Get("state") != testState || params.Get("app") != "web" {
+			t.Fatalf("Unexpected error redirect for response_type %q: %s", responseType, location)
+		}
+	}
+
+	// Implicit flow errors are sent in the fragment
+	query.Set("response_type", "token")
+	location, _ := url.Parse(authorize(cnfg, query).Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.EscapedFragment())
+	if fragment.Get("error") != "invalid_scope" || fragment.Get("state") != testState {
+		t.Fatalf("Unexpected error redirect for the Implicit flow: %s", location)
+	}
+
+	// The error pages are rendered from the templates relative to the project root
+	err := os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("oauth2/server")
+
+	// Errors are never sent to a redirect URI which is not registered
+	query.Set("redirect_uri", "https://evil.example/")
+	recorder := authorize(cnfg, query)
+	if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Location") != "" {
+		t.Fatalf("HTTP %d: error sent to an unregistered redirect_uri", recorder.Code)
+	}
+}
+
+func TestResponseRequestBinding(t *testing.T) {
+	location := submitAuthScreen(t, config.AuthCode, "ACCEPT")
+	if location.Host != "oauth2bin.org" {
+		t.Fatalf("Redirected to an unexpected URI: %s", location)
+	}
+
+	err := os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("oauth2/server")
+
+	// A redirect URI posted by the form is not trusted, and unknown requests are rejected
+	form := url.Values{
+		"flow":        {strconv.Itoa(config.AuthCode)},
+		"request_id":  {"unknown"},
+		"response":    {"ACCEPT"},
+		"redirectURI": {"https://evil.example/"},
+	}
+
+	recorder := postForm(withScope(&config.OA2Config{}, nil, handleResponse), "/response", form)
+	if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Location") != "" {
+		t.Fatalf("HTTP %d: unknown authorization request accepted", recorder.Code)
+	}
+}
+
+func TestDefaultClientRedirectURI(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	// Sends the authorization request through the routes of the server, which runs the shipped configuration
+	send := func(query url.Values) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		s.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil))
+		return recorder
+	}
+
+	for _, responseType := range []string{"code", "token"} {
+		query := url.Values{
+			"response_type": {responseType},
+			"client_id":     {"clientID"},
+			"redirect_uri":  {"https://evil.example/"},
+		}
+
+		// The default client is sent only to the redirect URIs registered for it
+		recorder := send(query)
+		if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Location") != "" {
+			t.Fatalf("%s: HTTP %d: unregistered redirect_uri accepted for the default client", responseType, recorder.Code)
+		}
+
+		query.Del("redirect_uri")
+		if recorder = send(query); recorder.Code != http.StatusOK {
+			t.Fatalf("%s: HTTP %d: registered redirect_uri not used", responseType, recorder.Code)
+		}
+	}
+
+	// A client without any redirect URI is never sent anywhere
+	cnfg := *s.currentConfig()
+	cnfg.Clients = []config.Client{{ID: "bare", GrantTypes: []string{config.AuthCodeGrant}}}
+	s.setConfig(&cnfg)
+	recorder := send(url.Values{
+		"response_type": {"code"},
+		"client_id":     {"bare"},
+		"redirect_uri":  {"https://evil.example/"},
+	})
+	if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Location") != "" {
+		t.Fatalf("HTTP %d: redirect_uri accepted for a client without any", recorder.Code)
+	}
+}
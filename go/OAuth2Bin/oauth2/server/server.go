@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -11,70 +13,284 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/template"
+	"time"
 
 	"oauth2bin/oauth2/cache"
 	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+	"oauth2bin/oauth2/metrics"
 	"oauth2bin/oauth2/middleware"
 )
 
 // OA2Server implements an OAuth 2.0 server
 type OA2Server struct {
 	Port    string
-	Config  config.OA2Config
-	Limiter middleware.RateLimiter
+	Limiter *middleware.ReloadableRateLimiter
+	Faults  *middleware.FaultInjector
+
+	// Records the requests to the routes in the bins of the request inspector
+	Recorder *middleware.RequestRecorder
+
+	// Files from which the configuration and rate policies are reloaded
+	serverConfigPath string
+	ratePoliciesPath string
+
+	// The configuration in use, as a *config.OA2Config, along with the lock serializing its reloads
+	cnfg      atomic.Value
+	reloadMut sync.Mutex
+
+	// Signs the JWTs issued by the server and is published at its JWKS endpoint
+	signer *jwt.Signer
+
+	// Routes registered in setupRoutes, from which the metadata document is generated
+	routes []string
+
+	// The routes are served from a mux of the server's own rather than http.DefaultServeMux
+	mux        *http.ServeMux
+	httpServer *http.Server
+
+	// Closed on shutdown to stop reloading the configuration
+	stopWatch    chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// Closed once the server has been shut down
+	done chan struct{}
 }
 
-var serverConfig config.OA2Config
+// How long in-flight requests are given to complete when the process is asked to stop
+const shutdownTimeout = 10 * time.Second
+
+// The store is that of the cache package, hence the servers of a process share it
+// as replicas share Redis. It is set up by the first server and closed by the last one to shut down.
+var (
+	storeMut   sync.Mutex
+	storeUsers int
+	storeKind  string
+)
 
 // NewOA2Server returns a new OAuth 2.0 server which runs
-// on the specified port with the specified configuration
+// on the specified port with the specified configuration.
+// Several servers may run in one process, each with a configuration of its own, but they share the store.
 func NewOA2Server(port string, serverConfigPath string, ratePoliciesPath string) *OA2Server {
-	serverConfig = *getServerConfig(serverConfigPath)
-	return &OA2Server{
-		Port:   port,
-		Config: serverConfig,
-		Limiter: middleware.RateLimiter{
-			Policies: getRatePolicies(ratePoliciesPath),
-		},
+	cnfg, err := readServerConfig(serverConfigPath)
+	if err != nil {
+		log.Fatalf("Could not read the server config: %s", err)
+	}
+
+	err = cnfg.Validate()
+	if err != nil {
+		log.Fatalf("Invalid server config: %s", err)
 	}
+
+	// The STORE environment variable overrides the store set in the config
+	kind := os.Getenv("STORE")
+	if kind == "" {
+		kind = cnfg.Store
+	}
+	acquireStore(kind)
+
+	s := &OA2Server{
+		Port: port,
+		Limiter: middleware.NewReloadableRateLimiter(middleware.RateLimiter{
+			Policies:       getRatePolicies(ratePoliciesPath),
+			TrustedProxies: cnfg.TrustedProxies,
+		}),
+		Faults:           middleware.NewFaultInjector(),
+		Recorder:         middleware.NewRequestRecorder(),
+		serverConfigPath: serverConfigPath,
+		ratePoliciesPath: ratePoliciesPath,
+		mux:              http.NewServeMux(),
+		stopWatch:        make(chan struct{}),
+		done:             make(chan struct{}),
+		signer:           newTokenSigner(cnfg.JWTCnfg),
+	}
+	s.setConfig(cnfg)
+
+	s.setupRoutes()
+	s.httpServer = &http.Server{Addr: ":" + port, Handler: s.mux}
+
+	// Ends the streams of the request inspector, which would otherwise hold up the shutdown
+	s.httpServer.RegisterOnShutdown(s.Recorder.Close)
+	return s
+}
+
+// Handler returns the handler which serves every route of the server,
+// e.g. to drive the server with net/http/httptest.
+func (s *OA2Server) Handler() http.Handler {
+	return s.mux
 }
 
 // SetRateLimiter creates a new RateLimiter which enforces
-// the policies passed.
+// the policies passed, trusting the proxies of the configuration.
+// The policies are replaced by those of the file when it is reloaded.
 func (s *OA2Server) SetRateLimiter(policies []middleware.RatePolicy) {
-	s.Limiter = middleware.RateLimiter{Policies: policies}
+	s.Limiter.Set(middleware.RateLimiter{Policies: policies, TrustedProxies: s.currentConfig().TrustedProxies})
 }
 
-// Start sets up the static file server, handling routes and then starts listening for requests
+// Start starts listening for requests, and blocks until the server is shut down either by
+// Shutdown or when the process receives SIGINT or SIGTERM. It returns once the in-flight
+// requests have been handled and the store has been released.
 func (s *OA2Server) Start() {
-	s.setupRoutes()
-	setupGracefulShutdown()
+	s.handleStopSignals()
+	s.watchConfig()
 
 	log.Printf("OAuth 2.0 Server has started on port %s\n", s.Port)
-	err := http.ListenAndServe(":"+s.Port, nil)
+	err := s.httpServer.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Could not start server on port %s\n", s.Port)
+		log.Fatalf("Could not start server on port %s: %s\n", s.Port, err)
+	}
+
+	<-s.done
+}
+
+// Shutdown shuts the server down gracefully. It stops accepting requests and waits for those
+// in flight to complete, or for the context to be done, before it stops reloading the configuration
+// and releases the store, which is closed unless other servers of the process still use it.
+// Returns the error of draining the connections, if any.
+// Only the first call has any effect, the others wait for it to complete.
+func (s *OA2Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.httpServer.Shutdown(ctx)
+		close(s.stopWatch)
+		releaseStore()
+		close(s.done)
+	})
+
+	return s.shutdownErr
+}
+
+// Sets up the store of the given kind and migrates it, unless another server
+// of the process has set one up already, in which case it is shared.
+func acquireStore(kind string) {
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	storeUsers++
+	if storeUsers > 1 {
+		if kind != storeKind {
+			log.Printf("Sharing the %q store of the other servers, the %q store is not set up\n", storeKind, kind)
+		}
+		return
+	}
+
+	store, err := cache.NewStore(kind)
+	if err != nil {
+		log.Fatalf("Could not set up the store: %s", err)
+	}
+	cache.SetStore(store)
+	storeKind = kind
+
+	err = cache.MigrateStore()
+	if err != nil {
+		log.Fatalf("Could not migrate the store: %s", err)
+	}
+}
+
+// Closes the store once no server of the process uses it
+func releaseStore() {
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	storeUsers--
+	if storeUsers == 0 {
+		cache.CloseStore()
+	}
+}
+
+// Key under which the requestScope of a request is stored in its context
+type requestScopeKey struct{}
+
+// requestScope holds the configuration and signing key of the server handling a request
+type requestScope struct {
+	cnfg   *config.OA2Config
+	signer *jwt.Signer
+}
+
+// Returns the request with the scope in its context
+func withRequestScope(r *http.Request, scope requestScope) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestScopeKey{}, scope))
+}
+
+// Returns the scope of the server handling the request.
+// Every route is served behind serverScope, hence a request without a scope is a programming error.
+func scopeOf(r *http.Request) requestScope {
+	scope, ok := r.Context().Value(requestScopeKey{}).(requestScope)
+	if !ok {
+		panic("request handled outside of the scope of a server")
+	}
+
+	return scope
+}
+
+// serverScope is an implementation of Middleware which passes the requestScope
+// of the server on to the handler in the request context
+type serverScope struct {
+	server *OA2Server
+}
+
+// Handle implements the Middleware interface.
+// The configuration is read once per request, so that a reload does not change it halfway through.
+func (ss serverScope) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope := requestScope{cnfg: ss.server.currentConfig(), signer: ss.server.signer}
+		handler.ServeHTTP(w, withRequestScope(r, scope))
 	}
 }
 
 func (s *OA2Server) chainCommonMiddleware(pattern string, handler http.HandlerFunc, extras ...middleware.Middleware) {
-	middlewareSlice := []middleware.Middleware{s.Limiter, middleware.NewNotFoundMiddleware(pattern)}
+	middlewareSlice := []middleware.Middleware{serverScope{server: s}, middleware.NewRequestMetrics(pattern), s.Recorder, s.Limiter, middleware.NewNotFoundMiddleware(pattern), s.Faults}
 	middlewareSlice = append(middlewareSlice, extras...)
 	chain := middleware.Chain(handler, middlewareSlice...)
-	http.HandleFunc(pattern, chain)
+	s.mux.HandleFunc(pattern, chain)
+	s.routes = append(s.routes, pattern)
+}
+
+// Registers a route of the tools used to debug clients or run the server, such as the request inspector,
+// the admin API and the metrics. Their requests are neither recorded in bins nor subject to injected faults,
+// and their routes are not advertised in the metadata document.
+func (s *OA2Server) chainToolMiddleware(pattern string, handler http.HandlerFunc, extras ...middleware.Middleware) {
+	middlewareSlice := []middleware.Middleware{serverScope{server: s}, middleware.NewRequestMetrics(pattern), s.Limiter, middleware.NewNotFoundMiddleware(pattern)}
+	middlewareSlice = append(middlewareSlice, extras...)
+	chain := middleware.Chain(handler, middlewareSlice...)
+	s.mux.HandleFunc(pattern, chain)
 }
 
 func (s *OA2Server) setupRoutes() {
 	public := http.FileServer(http.Dir("public/"))
-	http.Handle("/public/", http.StripPrefix("/public/", public))
+	s.mux.Handle("/public/", http.StripPrefix("/public/", public))
 
 	s.chainCommonMiddleware("/", s.handleHome)
 	s.chainCommonMiddleware("/authorize", handleAuth)
 	s.chainCommonMiddleware("/response", handleResponse, middleware.NewPostFormValidator(true))
-	s.chainCommonMiddleware("/token", handleToken, middleware.NewPostFormValidator(false))
+	s.chainCommonMiddleware("/token", handleToken, middleware.NewErrorCounter(metrics.TokenErrors), middleware.NewPostFormValidator(false))
+	s.chainCommonMiddleware("/introspect", handleIntrospect, middleware.NewPostFormValidator(false))
+	s.chainCommonMiddleware("/revoke", handleRevoke, middleware.NewPostFormValidator(false))
+	s.chainCommonMiddleware("/device_authorization", handleDeviceAuthorization, middleware.NewPostFormValidator(false))
+	s.chainCommonMiddleware("/device", handleDevice)
+	s.chainCommonMiddleware("/userinfo", handleUserInfo)
+	s.chainCommonMiddleware("/register", handleRegister)
+	s.chainCommonMiddleware(clientConfigurationRoute, handleClientConfiguration)
+	s.chainCommonMiddleware("/api/me", handleMe, newResourceAuth(nil))
+	s.chainCommonMiddleware(scopedResourceRoute, handleScoped, newResourceAuth(scopedResourceScope))
 	s.chainCommonMiddleware("/echo", handleEcho)
+	s.chainCommonMiddleware("/.well-known/jwks.json", handleJWKS)
+	s.chainCommonMiddleware("/.well-known/oauth-authorization-server", s.handleMetadata)
+	s.chainCommonMiddleware("/.well-known/openid-configuration", s.handleOpenIDConfiguration)
+
+	s.chainToolMiddleware(inspectorRoute, s.handleInspector)
+	s.chainToolMiddleware(inspectorRoute+"/export", handleInspectorExport)
+	s.chainToolMiddleware(inspectorRoute+"/events", s.handleInspectorEvents)
+	s.chainToolMiddleware(adminRoute, handleAdmin, newAdminAuth())
+	s.chainToolMiddleware(adminRoute+"/grants", handleAdminGrants, newAdminAuth())
+	s.chainToolMiddleware(adminRoute+"/revoke", handleAdminRevoke, newAdminAuth())
+	s.chainToolMiddleware(adminRoute+"/expire", handleAdminExpire, newAdminAuth())
+	s.chainToolMiddleware(adminRoute+"/faults", s.handleAdminFaults, newAdminAuth())
+	s.chainToolMiddleware("/metrics", metrics.Default.ServeHTTP)
 }
 
 // Serves the home page
@@ -89,53 +305,48 @@ func (s *OA2Server) handleHome(w http.ResponseWriter, r *http.Request) {
 		log.Fatal(err)
 	}
 
-	err = tmpl.ExecuteTemplate(w, "home", s.Config)
+	err = tmpl.ExecuteTemplate(w, "home", requestConfig(r))
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-// Channel over which we receive signals from the operating system
-var osSignal chan os.Signal
+// Fires up a goroutine which shuts the server down when the process receives SIGINT or SIGTERM,
+// giving the in-flight requests up to shutdownTimeout to complete.
+func (s *OA2Server) handleStopSignals() {
+	osSignal := make(chan os.Signal, 1)
+	signal.Notify(osSignal, syscall.SIGTERM, syscall.SIGINT)
 
-// Configures what OS signals we wish to be notified about.
-// Fires up a goroutine which listens for these signals and
-// runs the shutdown logic when they're received.
-func setupGracefulShutdown() {
-	osSignal = make(chan os.Signal)
-	signal.Notify(osSignal, syscall.SIGTERM)
-	signal.Notify(osSignal, syscall.SIGINT)
-	go onStopServer()
-}
+	go func() {
+		defer signal.Stop(osSignal)
 
-// Listens for OS signals and executes the shutdown logic
-func onStopServer() {
-	<-osSignal
-	cache.ClosePool()
-	os.Exit(0)
+		select {
+		case <-osSignal:
+		case <-s.done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := s.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Could not complete the in-flight requests: %s\n", err)
+		}
+	}()
 }
 
 // Reads the server config from the specified path and returns it
-func getServerConfig(serverConfigPath string) *config.OA2Config {
-	fd, err := os.Open(serverConfigPath)
-	if err != nil {
-		return nil
-	}
-	defer fd.Close()
-
-	jsonBytes, err := ioutil.ReadAll(fd)
+func readServerConfig(serverConfigPath string) (*config.OA2Config, error) {
+	jsonBytes, err := ioutil.ReadFile(serverConfigPath)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	if len(jsonBytes) <= 0 {
-		return nil
+		return nil, err
 	}
 
 	var config config.OA2Config
 	err = json.Unmarshal(jsonBytes, &config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// Remove trailing "/" in the URL, if any
@@ -143,35 +354,44 @@ func getServerConfig(serverConfigPath string) *config.OA2Config {
 		config.BaseURL = config.BaseURL[:len(config.BaseURL)-1]
 	}
 
-	return &config
+	return &config, nil
 }
 
 // Reads the IP rate limiting policies from the specified file and
 // returns them as an array, returns nil in case something goes wrong
 func getRatePolicies(ratePoliciesPath string) []middleware.RatePolicy {
+	policies, err := readRatePolicies(ratePoliciesPath)
+	if err != nil {
+		log.Printf("Could not read rate policies: %s\n", err)
+		return nil
+	}
+
+	return validRatePolicies(policies)
+}
+
+// Reads the rate limiting policies from the specified file, which may be in JSON or CSV
+func readRatePolicies(ratePoliciesPath string) ([]middleware.RatePolicy, error) {
 	// Opens the file, reads the contents.
 	fd, err := os.Open(ratePoliciesPath)
 	if err != nil {
-		log.Println("Could not read rate policies.")
-		return nil
+		return nil, err
 	}
 	defer fd.Close()
 
 	data, err := ioutil.ReadAll(fd)
 	if err != nil {
-		log.Println("Could not read rate policies.")
-		return nil
+		return nil, err
 	}
 
 	if len(data) <= 0 {
-		return nil
+		return nil, nil
 	}
 
 	// First, attempts to parse those contents as JSON.
 	// If it works, returns the policies.
 	policies, err := parseJSONPolicies(data)
 	if err == nil {
-		return policies
+		return policies, nil
 	}
 
 	// Rewinding the file read pointer since the file may
@@ -181,11 +401,26 @@ func getRatePolicies(ratePoliciesPath string) []middleware.RatePolicy {
 	// Attempts to parse the file as CSV
 	policies, err = parseCSVPolicies(fd)
 	if err != nil {
-		log.Println("Unknown format for rate policies. JSON or CSV supported.")
-		return nil
+		return nil, fmt.Errorf("unknown format, JSON or CSV supported: %s", err)
+	}
+
+	return policies, nil
+}
+
+// Returns the valid policies, logging those which are ignored
+func validRatePolicies(policies []middleware.RatePolicy) []middleware.RatePolicy {
+	valid := policies[:0]
+	for _, policy := range policies {
+		err := policy.Validate()
+		if err != nil {
+			log.Printf("Ignoring rate policy: %s\n", err)
+			continue
+		}
+
+		valid = append(valid, policy)
 	}
 
-	return policies
+	return valid
 }
 
 // Tries to parse the given data into an array of policies assuming that the format is JSON
@@ -199,23 +434,30 @@ func parseJSONPolicies(data []byte) ([]middleware.RatePolicy, error) {
 	return policies, nil
 }
 
-// Tries to parse the given data into an array of policies assuming that the format is CSV
+// Tries to parse the given data into an array of policies assuming that the format is CSV.
+// Each line holds the route, limit and minutes of a policy, optionally followed by its algorithm, key and grant type.
 func parseCSVPolicies(fd *os.File) ([]middleware.RatePolicy, error) {
-	lines, err := csv.NewReader(fd).ReadAll()
+	reader := csv.NewReader(fd)
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
 	if err != nil {
 		return nil, err
 	}
 
 	policies := make([]middleware.RatePolicy, len(lines))
 	for i, line := range lines {
+		if len(line) < 3 || len(line) > 6 {
+			return nil, fmt.Errorf("expected 3 to 6 fields for policy on line %d, got %d", i+1, len(line))
+		}
+
 		limit, err := strconv.Atoi(strings.TrimSpace(line[1]))
 		if err != nil {
-			log.Fatalf("Expect integer value for policy rate limit: %s", err.Error())
+			return nil, fmt.Errorf("expect integer value for policy rate limit: %s", err)
 		}
 
 		minutes, err := strconv.Atoi(strings.TrimSpace(line[2]))
 		if err != nil {
-			log.Fatalf("Expect integer value for policy time limit: %s", err.Error())
+			return nil, fmt.Errorf("expect integer value for policy time limit: %s", err)
 		}
 
 		policies[i] = middleware.RatePolicy{
@@ -223,6 +465,18 @@ func parseCSVPolicies(fd *os.File) ([]middleware.RatePolicy, error) {
 			Limit:   limit,
 			Minutes: minutes,
 		}
+
+		if len(line) > 3 {
+			policies[i].Algorithm = strings.TrimSpace(line[3])
+		}
+
+		if len(line) > 4 {
+			policies[i].Key = strings.TrimSpace(line[4])
+		}
+
+		if len(line) > 5 {
+			policies[i].GrantType = strings.TrimSpace(line[5])
+		}
 	}
 
 	return policies, nil
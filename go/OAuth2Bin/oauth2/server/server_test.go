@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2bin/oauth2/cache"
+	"oauth2bin/oauth2/config"
+	"oauth2bin/oauth2/jwt"
+	"oauth2bin/oauth2/metrics"
+	"oauth2bin/oauth2/middleware"
+)
+
+// Returns a server set up from the configuration files shipped with OAuth 2.0 Bin, like main does.
+// The returned function shuts the server down and restores the store used by the other tests.
+func newTestServer(t *testing.T, port string) (*OA2Server, func()) {
+	err := os.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousStore := cache.CurrentStore()
+	os.Setenv("STORE", cache.MemoryStoreKind)
+
+	s := NewOA2Server(port, "config/flowParams.json", "config/ratePolicies.csv")
+	return s, func() {
+		s.Shutdown(context.Background())
+		os.Unsetenv("STORE")
+		cache.SetStore(previousStore)
+		os.Chdir("oauth2/server")
+	}
+}
+
+// Returns the handler called with the configuration and signing key in its request context,
+// as a server passes them on to the handlers of its routes
+func withScope(cnfg *config.OA2Config, signer *jwt.Signer, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, withRequestScope(r, requestScope{cnfg: cnfg, signer: signer}))
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/.well-known/openid-configuration")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get the OpenID configuration: %v", err)
+	}
+
+	var document map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&document)
+	res.Body.Close()
+	if document["token_endpoint"] != s.currentConfig().BaseURL+"/token" {
+		t.Fatalf("Unexpected OpenID configuration: %v", document)
+	}
+
+	res, err = http.Get(ts.URL + "/not-found")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "Not Found") {
+		t.Fatal("Not found page not served for an unknown route")
+	}
+
+	// Requests go through the whole middleware chain of the route
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("clientID", "clientSecret")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get a token: %v", err)
+	}
+	res.Body.Close()
+
+	if res.Header.Get("RateLimit-Limit") == "" {
+		t.Fatalf("Token request not rate limited: %v", res.Header)
+	}
+
+	// Faults requested through the client ID are injected by the middleware chain
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("clientID;expired_tokens", "clientSecret")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get a token: %v", err)
+	}
+
+	var token map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&token)
+	res.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/introspect", strings.NewReader(url.Values{"token": {token["access_token"].(string)}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("clientID", "clientSecret")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not introspect the token: %v", err)
+	}
+
+	var info map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&info)
+	res.Body.Close()
+	if info["active"] != false || token["expires_in"] != 0.0 {
+		t.Fatalf("Token not issued expired: %v %v", token, info)
+	}
+}
+
+func TestServersInOneProcess(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	// The other server runs a configuration of its own
+	dir, err := ioutil.TempDir("", "oa2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cnfg := *s.currentConfig()
+	cnfg.BaseURL = "https://other.oauth2bin.org"
+	cnfg.ClientCredsCnfg.ClientSecret = "otherSecret"
+	jsonBytes, _ := json.Marshal(cnfg)
+	ioutil.WriteFile(filepath.Join(dir, "flowParams.json"), jsonBytes, 0600)
+
+	other := NewOA2Server("0", filepath.Join(dir, "flowParams.json"), "config/ratePolicies.csv")
+	defer other.Shutdown(context.Background())
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	otherTS := httptest.NewServer(other.Handler())
+	defer otherTS.Close()
+
+	// Requests a token with the client credentials and returns the status of the response
+	requestToken := func(serverURL, secret string) int {
+		req, _ := http.NewRequest(http.MethodPost, serverURL+"/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("clientID", secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if requestToken(ts.URL, "clientSecret") != http.StatusOK || requestToken(ts.URL, "otherSecret") != http.StatusUnauthorized {
+		t.Fatal("Clients of the other server authenticated")
+	}
+
+	if requestToken(otherTS.URL, "clientSecret") != http.StatusUnauthorized {
+		t.Fatal("Clients replaced by the configuration of another server")
+	}
+
+	for _, server := range []struct{ url, issuer string }{{ts.URL, s.currentConfig().BaseURL}, {otherTS.URL, cnfg.BaseURL}} {
+		res, err := http.Get(server.url + "/.well-known/openid-configuration")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var document map[string]interface{}
+		json.NewDecoder(res.Body).Decode(&document)
+		res.Body.Close()
+		if document["issuer"] != server.issuer {
+			t.Fatalf("Expected the issuer %s, got %v", server.issuer, document["issuer"])
+		}
+	}
+
+	// The store is shared, and left to the other server when one shuts down
+	store := cache.CurrentStore()
+	s.Shutdown(context.Background())
+	if cache.CurrentStore() != store || requestToken(otherTS.URL, "otherSecret") != http.StatusOK {
+		t.Fatal("Store not left to the other server")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	s, restore := newTestServer(t, port)
+	defer restore()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Start()
+		close(stopped)
+	}()
+
+	// Waits for the server to start listening
+	for i := 0; ; i++ {
+		res, err := http.Get("http://127.0.0.1:" + port + "/.well-known/jwks.json")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+
+		if i == 50 {
+			t.Fatalf("Server did not start: %s", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Could not shut down: %s", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after shutdown")
+	}
+
+	if _, err := http.Get("http://127.0.0.1:" + port + "/.well-known/jwks.json"); err == nil {
+		t.Fatal("Server still serving after shutdown")
+	}
+
+	// Shutting down again has no effect
+	if s.Shutdown(ctx) != nil {
+		t.Fatal("Second shutdown failed")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s, restore := newTestServer(t, "0")
+	defer restore()
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	issued := metrics.TokensIssued.Value(cache.ClientCredsFlowName, "client_credentials")
+	invalidClients := metrics.TokenErrors.Value("invalid_client")
+	for _, secret := range []string{"clientSecret", "wrongSecret"} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("clientID", secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if metrics.TokensIssued.Value(cache.ClientCredsFlowName, "client_credentials") != issued+1 {
+		t.Fatal("Issued token not counted")
+	}
+
+	if metrics.TokenErrors.Value("invalid_client") != invalidClients+1 {
+		t.Fatal("Token error not counted")
+	}
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get the metrics: %v", err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, sample := range []string{
+		`oauth2bin_tokens_issued_total{flow="client_credentials",grant_type="client_credentials"} `,
+		`oauth2bin_token_errors_total{error="invalid_client"} `,
+		`oauth2bin_http_request_duration_seconds_count{handler="/token",code="401"} `,
+		`oauth2bin_store_operation_duration_seconds_count{operation="set"} `,
+	} {
+		if !strings.Contains(string(body), sample) {
+			t.Fatalf("Sample %s missing from the metrics:\n%s", sample, body)
+		}
+	}
+}
+
+func TestShippedRatePolicies(t *testing.T) {
+	csvPolicies, err := readRatePolicies("../../config/ratePolicies.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPolicies, err := readRatePolicies("../../config/ratePolicies.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(csvPolicies, jsonPolicies) || len(validRatePolicies(csvPolicies)) != len(jsonPolicies) {
+		t.Fatalf("Shipped policies differ:\n%v\n%v", csvPolicies, jsonPolicies)
+	}
+
+	store := cache.NewMemoryStore()
+	defer store.Close()
+
+	handler := middleware.RateLimiter{Policies: csvPolicies, Store: store}.Handle(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Sends a token request with the parameters and returns its status
+	send := func(params url.Values) int {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// A device polling at the interval it is told to is never limited before its device code expires
+	authorization, err := cache.NewDeviceAuthorization("clientID", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < authorization.ExpiresIn/authorization.Interval; i++ {
+		status := send(url.Values{"grant_type": {cache.DeviceFlowName}, "device_code": {authorization.DeviceCode}, "client_id": {"clientID"}})
+		if status == http.StatusTooManyRequests {
+			t.Fatalf("Poll %d limited", i+1)
+		}
+	}
+
+	// The polls are counted apart from the other token requests, which keep their own limit
+	for i := 0; i < 6; i++ {
+		status := send(url.Values{"grant_type": {"client_credentials"}})
+		if (i < 5) != (status == http.StatusOK) {
+			t.Fatalf("HTTP %d: unexpected response to token request %d", status, i+1)
+		}
+	}
+}
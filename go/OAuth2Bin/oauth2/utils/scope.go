@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseScope splits a space-delimited scope string into its scope tokens.
+// Duplicate tokens are removed while preserving the order.
+// Refer RFC 6749 Section 3.3 (https://tools.ietf.org/html/rfc6749#section-3.3)
+func ParseScope(scope string) []string {
+	seen := make(map[string]struct{})
+	var scopes []string
+
+	for _, s := range strings.Fields(scope) {
+		if _, found := seen[s]; !found {
+			seen[s] = struct{}{}
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// IsScopeSubset returns true if every scope in 'requested' is present in 'granted'
+func IsScopeSubset(requested, granted []string) bool {
+	set := make(map[string]struct{}, len(granted))
+	for _, s := range granted {
+		set[s] = struct{}{}
+	}
+
+	for _, s := range requested {
+		if _, found := set[s]; !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NarrowScope validates the requested scope string against the scopes allowed for a client
+// and returns the scope to be granted as a space-delimited string.
+// If no scope is requested, all of the allowed scopes are granted.
+// If the client has no scopes configured, the requested scope is granted as is.
+func NarrowScope(requested string, allowed []string) (string, error) {
+	scopes := ParseScope(requested)
+	if len(allowed) == 0 {
+		return strings.Join(scopes, " "), nil
+	}
+
+	if len(scopes) == 0 {
+		return strings.Join(allowed, " "), nil
+	}
+
+	for _, s := range scopes {
+		if !IsScopeSubset([]string{s}, allowed) {
+			return "", fmt.Errorf("scope not allowed: %s", s)
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
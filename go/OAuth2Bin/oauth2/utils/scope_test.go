@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	scopes := ParseScope("  read write  read profile ")
+	expected := []string{"read", "write", "profile"}
+
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, scopes)
+	}
+
+	if len(ParseScope("")) != 0 {
+		t.Fatal("Empty scope string must yield no scopes")
+	}
+}
+
+func TestNarrowScope(t *testing.T) {
+	allowed := []string{"read", "write", "profile"}
+
+	scope, err := NarrowScope("", allowed)
+	if err != nil || scope != "read write profile" {
+		t.Fatalf("Absent scope must grant all allowed scopes, got %q", scope)
+	}
+
+	scope, err = NarrowScope("write read", allowed)
+	if err != nil || scope != "write read" {
+		t.Fatalf("Expected requested scope to be granted, got %q", scope)
+	}
+
+	if _, err = NarrowScope("read admin", allowed); err == nil {
+		t.Fatal("Scope outside of the allowed scopes was granted")
+	}
+
+	scope, err = NarrowScope("anything goes", nil)
+	if err != nil || scope != "anything goes" {
+		t.Fatalf("Client without configured scopes must be granted the requested scope, got %q", scope)
+	}
+}
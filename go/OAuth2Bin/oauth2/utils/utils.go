@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,61 +12,20 @@ import (
 	"time"
 )
 
-var scopeList = []string{
-	"Fly to Mars", "Travel back in time", "Ride a dragon",
-	"Go the center of the Earch", "Be Batman", "Run faster than light",
-	"Live foverer", "Be invisible", "Summon a genie",
-	"Discover a lost city", "Stop global warming", "See into the future",
-}
-
-// Returns a string slice containing 'count' number of
-// unique strings selected at random from scopeList.
-func getRandomUniqueScopes(count int) []string {
-	if count >= len(scopeList) {
-		return scopeList
-	}
-
-	// Create Go's equivalent of a set to store the unique indices
-	// of the strings to be selected from scopeList
-	indices := make(map[int]struct{})
-
-	// Loop until we have 'count' number of unique indices
-	for {
-		// Select a random index
-		r := rand.Int() % len(scopeList)
-
-		// Check if it has already been added to the set
-		if _, found := indices[r]; !found {
-			// If not, add it to the set
-			indices[r] = struct{}{}
-
-			// Stop if we have 'count' number of indices
-			if len(indices) >= count {
-				break
-			}
-		}
-	}
-
-	// Generate a string slice with the scope strings
-	// corresponding to the generated unique indices
-	scopes := make([]string, count)
-	i := 0
-	for j := range indices {
-		scopes[i] = scopeList[j]
-		i = i + 1
-	}
-
-	return scopes
-}
-
-// PresentAuthScreen shows the authorization screen to the user
-func PresentAuthScreen(w http.ResponseWriter, r *http.Request, flow int) {
+// PresentAuthScreen shows the authorization screen to the user listing the scope to be granted.
+// The ID of the authorization request held in the store, or the user code of a device
+// authorization request, is carried through the form so that it reaches the /response handler.
+func PresentAuthScreen(w http.ResponseWriter, r *http.Request, flow int, scope, requestID string) {
 	authScreenStruct := struct {
 		ScopeList []string
 		Flow      int
+		RequestID string
+		UserCode  string
 	}{
-		ScopeList: getRandomUniqueScopes(3),
+		ScopeList: ParseScope(scope),
 		Flow:      flow,
+		RequestID: requestID,
+		UserCode:  r.URL.Query().Get("user_code"),
 	}
 
 	tmpl, err := template.ParseFiles(
@@ -85,6 +43,28 @@ func PresentAuthScreen(w http.ResponseWriter, r *http.Request, flow int) {
 	}
 }
 
+// PresentDeviceScreen shows the device verification page on which the user enters
+// the user code displayed by the device. The message, if any, is shown above the form.
+func PresentDeviceScreen(w http.ResponseWriter, r *http.Request, status int, message string) {
+	tmpl, err := template.ParseFiles(
+		"public/templates/device.html",
+		"public/templates/nav.html",
+		"public/templates/footer.html",
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.WriteHeader(status)
+	err = tmpl.ExecuteTemplate(w, "verify", struct {
+		Message  string
+		UserCode string
+	}{Message: message, UserCode: r.URL.Query().Get("user_code")})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 // ShowError presents the error screen to the user
 func ShowError(w http.ResponseWriter, r *http.Request, status int, title, desc string) {
 	tmpl, err := template.ParseFiles(